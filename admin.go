@@ -0,0 +1,357 @@
+package echogy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/echogy-io/echogy/pkg/auth"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/echogy-io/echogy/pkg/tui"
+	"github.com/gliderlabs/ssh"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func countSessions() int {
+	count := 0
+	rangeForwarders(func(*forwarder) {
+		count++
+	})
+	return count
+}
+
+func init() {
+	metrics.RegisterSessions(countSessions)
+}
+
+type adminApi struct {
+	token string
+	auth  auth.Auth
+}
+
+type SessionInfo struct {
+	AccessId    string      `json:"accessId"`
+	Alias       string      `json:"alias,omitempty"`
+	User        string      `json:"user"`
+	RemoteAddr  string      `json:"remoteAddr"`
+	Fingerprint string      `json:"fingerprint,omitempty"`
+	Tunnel      string      `json:"tunnel"`
+	StartedAt   time.Time   `json:"startedAt"`
+	Uptime      string      `json:"uptime"`
+	Stats       *Stats      `json:"stats"`
+	Conns       []*ConnInfo `json:"conns"`
+}
+
+type ConnInfo struct {
+	Id         int64     `json:"id"`
+	RemoteAddr string    `json:"remoteAddr"`
+	OpenedAt   time.Time `json:"openedAt"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, &adminError{Error: msg})
+}
+
+func (fwd *forwarder) info() *SessionInfo {
+	ctx := fwd.sshCtx
+	alias, _ := ctx.Value(clientHttpAlias).(string)
+	fingerprint, _ := ctx.Value(clientPublicKeyFingerprintSha256).(string)
+	tunnel, _ := ctx.Value(sshTunnelAddrKey).(string)
+	info := &SessionInfo{
+		AccessId:    fwd.accessId,
+		Alias:       alias,
+		User:        ctx.User(),
+		RemoteAddr:  fwd.sshCtx.RemoteAddr().String(),
+		Fingerprint: fingerprint,
+		Tunnel:      tunnel,
+		StartedAt:   fwd.startTime,
+		Uptime:      time.Since(fwd.startTime).Truncate(time.Second).String(),
+		Stats:       newStats(ctx),
+		Conns:       make([]*ConnInfo, 0),
+	}
+	fwd.chanMap.Range(func(key, value any) bool {
+		c := value.(*fwdConn)
+		conn := &ConnInfo{Id: key.(int64), OpenedAt: c.openedAt}
+		if nil != c.conn {
+			conn.RemoteAddr = c.conn.RemoteAddr().String()
+		}
+		info.Conns = append(info.Conns, conn)
+		return true
+	})
+	sort.Slice(info.Conns, func(i, j int) bool {
+		return info.Conns[i].Id < info.Conns[j].Id
+	})
+	return info
+}
+
+func lookupForwarder(accessId string) (*forwarder, bool) {
+	value, _ := sessionHub.Load(accessId)
+	fwd, found := value.(*forwarder)
+	return fwd, found
+}
+
+// authorize checks the bearer token in constant time
+func (a *adminApi) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || 1 != subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="echogy"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *adminApi) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := make([]*SessionInfo, 0)
+	rangeForwarders(func(fwd *forwarder) {
+		sessions = append(sessions, fwd.info())
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (a *adminApi) getSession(w http.ResponseWriter, r *http.Request) {
+	fwd, found := lookupForwarder(r.PathValue("id"))
+	if !found {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, fwd.info())
+}
+
+func (a *adminApi) kickSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	fwd, found := lookupForwarder(id)
+	if !found {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	logger.Warn("kick session", map[string]interface{}{
+		"module":   "admin",
+		"accessId": id,
+	})
+	fwd.kick("Your tunnel was closed by the administrator")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminApi) closeConn(w http.ResponseWriter, r *http.Request) {
+	fwd, found := lookupForwarder(r.PathValue("id"))
+	if !found {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	connId, err := strconv.ParseInt(r.PathValue("conn"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid conn id")
+		return
+	}
+	if !fwd.closeConn(connId) {
+		writeError(w, http.StatusNotFound, "conn not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminApi) listAliases(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, reservations.List())
+}
+
+func (a *adminApi) reserveAlias(w http.ResponseWriter, r *http.Request) {
+	reservation := &Reservation{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(reservation); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid reservation: "+err.Error())
+			return
+		}
+	}
+	reservation.Alias = r.PathValue("alias")
+	if !auth.ValidAlias(reservation.Alias) {
+		writeError(w, http.StatusBadRequest, "invalid alias")
+		return
+	}
+	reservation.CreatedAt = time.Time{}
+	if err := reservations.Reserve(reservation); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logger.Warn("reserve alias", map[string]interface{}{
+		"module": "admin",
+		"alias":  reservation.Alias,
+		"owner":  reservation.Owner,
+	})
+	writeJSON(w, http.StatusOK, reservation)
+}
+
+func (a *adminApi) releaseAlias(w http.ResponseWriter, r *http.Request) {
+	alias := r.PathValue("alias")
+	released, err := reservations.Release(alias)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !released {
+		writeError(w, http.StatusNotFound, "alias not reserved")
+		return
+	}
+	logger.Warn("release alias", map[string]interface{}{
+		"module": "admin",
+		"alias":  alias,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminApi) listBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, bans.List())
+}
+
+func (a *adminApi) unban(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	lifted, err := bans.Unban(key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !lifted {
+		writeError(w, http.StatusNotFound, "not banned")
+		return
+	}
+	logger.Warn("unban", map[string]interface{}{
+		"module": "admin",
+		"key":    key,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminApi) reloadAuth(w http.ResponseWriter, r *http.Request) {
+	reloader, ok := a.auth.(auth.Reloader)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "auth does not support reloading")
+		return
+	}
+	if err := reloader.Reload(); err != nil {
+		logger.Error("reload auth", err, map[string]interface{}{
+			"module": "admin",
+		})
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logger.WarnN("reloaded auth")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newAdminMux(config *AdminConfig, a auth.Auth) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	// the management API is only served when a token is configured
+	if "" == config.Token {
+		return mux
+	}
+	api := &adminApi{token: config.Token, auth: a}
+	mux.HandleFunc("GET /api/sessions", api.authorize(api.listSessions))
+	mux.HandleFunc("GET /api/sessions/{id}", api.authorize(api.getSession))
+	mux.HandleFunc("DELETE /api/sessions/{id}", api.authorize(api.kickSession))
+	mux.HandleFunc("DELETE /api/sessions/{id}/conns/{conn}", api.authorize(api.closeConn))
+	mux.HandleFunc("GET /api/aliases", api.authorize(api.listAliases))
+	mux.HandleFunc("PUT /api/aliases/{alias}", api.authorize(api.reserveAlias))
+	mux.HandleFunc("DELETE /api/aliases/{alias}", api.authorize(api.releaseAlias))
+	mux.HandleFunc("GET /api/bans", api.authorize(api.listBans))
+	mux.HandleFunc("DELETE /api/bans/{key}", api.authorize(api.unban))
+	mux.HandleFunc("POST /api/auth/reload", api.authorize(api.reloadAuth))
+	return mux
+}
+
+// adminTunnels feeds the admin dashboard from sessionHub
+type adminTunnels struct{}
+
+func (adminTunnels) Tunnels() []*tui.TunnelSummary {
+	tunnels := make([]*tui.TunnelSummary, 0)
+	rangeForwarders(func(fwd *forwarder) {
+		ctx := fwd.sshCtx
+		tunnel, _ := ctx.Value(sshTunnelAddrKey).(string)
+		tunnels = append(tunnels, &tui.TunnelSummary{
+			AccessId:   fwd.accessId,
+			Tunnel:     tunnel,
+			User:       ctx.User(),
+			RemoteAddr: fwd.sshCtx.RemoteAddr().String(),
+			StartedAt:  fwd.startTime,
+			Stat:       stat.GetStat(ctx),
+			History:    stat.GetHistory(ctx),
+		})
+	})
+	return tunnels
+}
+
+func (adminTunnels) Disconnect(accessId string) bool {
+	fwd, found := lookupForwarder(accessId)
+	if !found {
+		return false
+	}
+	logger.Warn("kick session", map[string]interface{}{
+		"module":   "admin",
+		"accessId": accessId,
+	})
+	fwd.kick("Your tunnel was closed by the administrator")
+	return true
+}
+
+// adminSession runs the operator dashboard for an admin key
+func adminSession(session ssh.Session) {
+	pty, err := tui.NewAdminPty(session, adminTunnels{})
+	if err != nil {
+		io.WriteString(session.Stderr(), "The admin dashboard requires a terminal, connect with ssh -t\n")
+		session.Exit(1)
+		return
+	}
+	logger.Warn("admin dashboard opened", map[string]interface{}{
+		"module":     "admin",
+		"remoteAddr": session.RemoteAddr().String(),
+	})
+	if err = pty.Start(); err != nil {
+		logger.Error("run admin dashboard", err, map[string]interface{}{
+			"module": "admin",
+		})
+	}
+	session.Exit(0)
+}
+
+// adminServe runs the admin HTTP listener until ctx is done
+func adminServe(ctx context.Context, ln net.Listener, config *AdminConfig, a auth.Auth) {
+	fields := map[string]interface{}{
+		"module":  "admin",
+		"address": config.Addr,
+	}
+	server := &http.Server{
+		Handler:           newAdminMux(config, a),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logger.Warn("started admin server", fields)
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("admin server failed", err, fields)
+	}
+}
@@ -0,0 +1,114 @@
+package echogy
+
+import (
+	"errors"
+	"github.com/echogy-io/echogy/pkg/auth"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, mux *http.ServeMux, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if "" != token {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminApi(t *testing.T) {
+	reservations.load(filepath.Join(t.TempDir(), "reservations.json"))
+	defer reservations.load("")
+
+	loads := 0
+	a, err := auth.NewDynamic(func() (auth.Auth, error) {
+		loads++
+		if loads > 2 {
+			return nil, errors.New("broken config")
+		}
+		return auth.New(nil, nil), nil
+	})
+	if err != nil {
+		t.Fatalf("NewDynamic() error = %v", err)
+	}
+	mux := newAdminMux(&AdminConfig{Token: "secret"}, a)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{name: "missing token", method: "GET", path: "/api/sessions", want: http.StatusUnauthorized},
+		{name: "wrong token", method: "GET", path: "/api/sessions", token: "nope", want: http.StatusUnauthorized},
+		{name: "list sessions", method: "GET", path: "/api/sessions", token: "secret", want: http.StatusOK},
+		{name: "kick unknown", method: "DELETE", path: "/api/sessions/nope", token: "secret", want: http.StatusNotFound},
+		{name: "close conn of unknown", method: "DELETE", path: "/api/sessions/nope/conns/1", token: "secret", want: http.StatusNotFound},
+		{name: "reserve", method: "PUT", path: "/api/aliases/demo", token: "secret", body: `{"owner":"abc"}`, want: http.StatusOK},
+		{name: "reserve invalid", method: "PUT", path: "/api/aliases/Not_Valid", token: "secret", want: http.StatusBadRequest},
+		{name: "list aliases", method: "GET", path: "/api/aliases", token: "secret", want: http.StatusOK},
+		{name: "release", method: "DELETE", path: "/api/aliases/demo", token: "secret", want: http.StatusNoContent},
+		{name: "release again", method: "DELETE", path: "/api/aliases/demo", token: "secret", want: http.StatusNotFound},
+		{name: "reload", method: "POST", path: "/api/auth/reload", token: "secret", want: http.StatusNoContent},
+		{name: "reload failure", method: "POST", path: "/api/auth/reload", token: "secret", want: http.StatusInternalServerError},
+		{name: "metrics need no token", method: "GET", path: "/metrics", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := adminRequest(t, mux, tt.method, tt.path, tt.token, tt.body)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAdminApiDisabledWithoutToken(t *testing.T) {
+	mux := newAdminMux(&AdminConfig{}, nil)
+	rec := adminRequest(t, mux, "GET", "/api/sessions", "", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/sessions = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestReservationAllowed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reservations.json")
+	reservations.load(path)
+	defer reservations.load("")
+
+	reservations.Reserve(&Reservation{Alias: "owned", Owner: "fp1"})
+	reservations.Reserve(&Reservation{Alias: "configured"})
+
+	// reservations survive a reload from disk
+	if err := reservations.load(path); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		alias       string
+		configured  string
+		fingerprint string
+		want        bool
+	}{
+		{name: "free alias", alias: "free", want: true},
+		{name: "owner", alias: "owned", fingerprint: "fp1", want: true},
+		{name: "other key", alias: "owned", fingerprint: "fp2", want: false},
+		{name: "configured alias", alias: "configured", configured: "configured", want: true},
+		{name: "no owner", alias: "configured", fingerprint: "fp1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reservations.Allowed(tt.alias, tt.configured, tt.fingerprint); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
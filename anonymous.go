@@ -0,0 +1,80 @@
+package echogy
+
+import (
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/limit"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/gliderlabs/ssh"
+	"time"
+)
+
+// TunnelLimits bound the tunnels of anonymous clients, zero values are unlimited
+type TunnelLimits struct {
+	MaxLifetime     Duration `json:"maxLifetime"`
+	IdleTimeout     Duration `json:"idleTimeout"` // closes tunnels without forwarded connections
+	MaxTunnelsPerIP int      `json:"maxTunnelsPerIP"`
+	BytesPerSecond  int64    `json:"bytesPerSecond"` // shared by both directions of all connections
+}
+
+func (l *TunnelLimits) validate() []error {
+	if l.MaxLifetime < 0 || l.IdleTimeout < 0 || l.MaxTunnelsPerIP < 0 || l.BytesPerSecond < 0 {
+		return []error{fmt.Errorf("access.anonymousLimits: limits must not be negative")}
+	}
+	return nil
+}
+
+// anonymousTunnels counts the anonymous tunnels of each client IP
+var anonymousTunnels = limit.NewConcurrent(0)
+
+func isAnonymous(ctx ssh.Context) bool {
+	_, configured := ctx.Value(clientHttpAlias).(string)
+	return !configured
+}
+
+// tunnelLimits returns the limits of the tunnel of ctx, nil when unlimited
+func tunnelLimits(ctx ssh.Context) *TunnelLimits {
+	if !isAnonymous(ctx) {
+		return nil
+	}
+	return currentAccess().AnonymousLimits
+}
+
+func (l *TunnelLimits) expiresAt(start time.Time) time.Time {
+	if nil == l || 0 == l.MaxLifetime {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(l.MaxLifetime))
+}
+
+func (l *TunnelLimits) idleTimeout() time.Duration {
+	if nil == l {
+		return 0
+	}
+	return time.Duration(l.IdleTimeout)
+}
+
+func (l *TunnelLimits) bandwidth() *limit.Bandwidth {
+	if nil == l || 0 == l.BytesPerSecond {
+		return nil
+	}
+	return limit.NewBandwidth(l.BytesPerSecond)
+}
+
+// admitTunnel takes a tunnel slot of the client IP, release frees it
+func admitTunnel(limits *TunnelLimits, ip string) (release func(), ok bool) {
+	if nil == limits || 0 == limits.MaxTunnelsPerIP {
+		return func() {}, true
+	}
+	if !anonymousTunnels.Acquire(ip) {
+		metrics.SSHRejected.WithLabelValues("tunnels_per_ip").Inc()
+		logger.Warn("too many anonymous tunnels", map[string]interface{}{
+			"module": "policy",
+			"ip":     ip,
+		})
+		return nil, false
+	}
+	return func() {
+		anonymousTunnels.Release(ip)
+	}, true
+}
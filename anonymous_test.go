@@ -0,0 +1,84 @@
+package echogy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwarderExpiry(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name   string
+		limits *TunnelLimits
+		idle   time.Duration
+		active int64
+		at     time.Duration
+		want   string
+	}{
+		{"unlimited", nil, time.Hour, 0, time.Hour, ""},
+		{"within lifetime", &TunnelLimits{MaxLifetime: Duration(time.Hour)}, 0, 0, time.Minute, ""},
+		{"lifetime reached", &TunnelLimits{MaxLifetime: Duration(time.Hour)}, 0, 0, time.Hour, "Anonymous tunnels last 1h0m0s"},
+		{"recently active", &TunnelLimits{IdleTimeout: Duration(time.Minute)}, time.Second, 0, 0, ""},
+		{"idle", &TunnelLimits{IdleTimeout: Duration(time.Minute)}, time.Minute, 0, 0, "after 1m0s without requests"},
+		{"open connection", &TunnelLimits{IdleTimeout: Duration(time.Minute)}, time.Hour, 1, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := &forwarder{
+				startTime:   start,
+				expiresAt:   tt.limits.expiresAt(start),
+				idleTimeout: tt.limits.idleTimeout(),
+			}
+			fwd.lastActive.Store(time.Now().Add(-tt.idle).UnixNano())
+			fwd.chanCounter.Store(tt.active)
+			got := fwd.checkExpiry(start.Add(tt.at))
+			if "" == tt.want && "" != got || !strings.Contains(got, tt.want) {
+				t.Errorf("checkExpiry = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpiryWarning(t *testing.T) {
+	start := time.Now()
+	fwd := &forwarder{startTime: start, expiresAt: start.Add(time.Hour)}
+	if got := fwd.expiryWarning(start); "" != got {
+		t.Errorf("expiryWarning an hour ahead = %q", got)
+	}
+	if got := fwd.expiryWarning(start.Add(59 * time.Minute)); "This tunnel expires in 1m0s." != got {
+		t.Errorf("expiryWarning a minute ahead = %q", got)
+	}
+	if got := fwd.expiryWarning(start.Add(59*time.Minute + time.Second)); "" != got {
+		t.Errorf("expiryWarning repeated = %q", got)
+	}
+	if got := (&forwarder{}).expiryWarning(start); "" != got {
+		t.Errorf("expiryWarning without lifetime = %q", got)
+	}
+}
+
+func TestAdmitTunnel(t *testing.T) {
+	limits := &TunnelLimits{MaxTunnelsPerIP: 2}
+	applyConfig(&Config{Access: &AccessConfig{AnonymousLimits: limits}})
+
+	releases := make([]func(), 0)
+	for i, want := range []bool{true, true, false} {
+		release, ok := admitTunnel(limits, "192.0.2.1")
+		if ok != want {
+			t.Fatalf("tunnel %d admitted = %v, want %v", i, ok, want)
+		}
+		if ok {
+			releases = append(releases, release)
+		}
+	}
+	if _, ok := admitTunnel(limits, "192.0.2.2"); !ok {
+		t.Error("another IP was refused")
+	}
+	releases[0]()
+	if _, ok := admitTunnel(limits, "192.0.2.1"); !ok {
+		t.Error("tunnel refused after a release")
+	}
+	if _, ok := admitTunnel(nil, "192.0.2.1"); !ok {
+		t.Error("configured clients must not be limited")
+	}
+}
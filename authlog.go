@@ -0,0 +1,60 @@
+package echogy
+
+import (
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authLogger writes failed logins and bans in the syslog-like format fail2ban expects:
+//
+//	2024-01-02T15:04:05Z echogy[1234]: Failed password for alice from 192.0.2.1 port 51234
+type authLogger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var authLog = &authLogger{}
+
+// open appends to path, an empty path only logs events with the logger
+func (l *authLogger) open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if nil != l.file {
+		l.file.Close()
+		l.file = nil
+	}
+	if "" == path {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+// logField escapes a client supplied value for a line of the auth log, control characters
+// and spaces would let a username forge a line or shift the fields fail2ban matches
+func logField(value string) string {
+	quoted := strconv.QuoteToASCII(value)
+	return strings.ReplaceAll(quoted[1:len(quoted)-1], " ", `\x20`)
+}
+
+func (l *authLogger) event(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	logger.Warn(message, map[string]interface{}{
+		"module": "auth",
+	})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if nil == l.file {
+		return
+	}
+	fmt.Fprintf(l.file, "%s echogy[%d]: %s\n", time.Now().UTC().Format(time.RFC3339), os.Getpid(), message)
+}
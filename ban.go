@@ -0,0 +1,393 @@
+package echogy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/gliderlabs/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFindTime   = 10 * time.Minute
+	defaultBanTime    = 10 * time.Minute
+	defaultMaxBanTime = 24 * time.Hour
+	// banSweepEvery is the number of failures between removals of stale entries
+	banSweepEvery = 256
+)
+
+// BanConfig bans client IPs and usernames that fail to log in too often. Each ban of
+// the same key lasts twice as long as the previous one, up to maxBanTime.
+type BanConfig struct {
+	MaxFailures int      `json:"maxFailures"` // failures within findTime before a ban, 0 disables banning
+	FindTime    Duration `json:"findTime"`
+	BanTime     Duration `json:"banTime"`
+	MaxBanTime  Duration `json:"maxBanTime"` // also how long past bans count towards the next one
+	File        string   `json:"file"`       // persists bans, empty keeps them in memory
+	// LogFile receives a line per failed login and ban for fail2ban, empty disables it
+	LogFile   string   `json:"logFile"`
+	IgnoreIPs []string `json:"ignoreIPs"` // addresses or CIDR ranges never banned
+}
+
+func orDefault(d Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+func (c *BanConfig) validate() []error {
+	var errs []error
+	if c.MaxFailures < 0 || c.FindTime < 0 || c.BanTime < 0 || c.MaxBanTime < 0 {
+		errs = append(errs, fmt.Errorf("ban: limits must not be negative"))
+	}
+	if _, err := parseNetworks(c.IgnoreIPs); err != nil {
+		errs = append(errs, fmt.Errorf("ban.ignoreIPs: %v", err))
+	}
+	return errs
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if nil == ip {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", value)
+			}
+			bits := 8 * len(ip.To16())
+			if nil != ip.To4() {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Ban is an IP address or username refused until a time
+type Ban struct {
+	// Key is ip:<address> or user:<name>
+	Key   string    `json:"key"`
+	Until time.Time `json:"until"`
+	// Offenses counts the bans of the key, it doubles the next ban
+	Offenses int `json:"offenses"`
+}
+
+// Active reports whether the ban still refuses its key at now
+func (b *Ban) Active(now time.Time) bool {
+	return now.Before(b.Until)
+}
+
+type banStore struct {
+	mu          sync.Mutex
+	maxFailures int
+	findTime    time.Duration
+	banTime     time.Duration
+	maxBanTime  time.Duration
+	ignore      []*net.IPNet
+	path        string
+	failures    map[string][]time.Time
+	items       map[string]*Ban
+	calls       int
+	now         func() time.Time
+}
+
+var bans = newBanStore()
+
+func newBanStore() *banStore {
+	return &banStore{
+		failures: make(map[string][]time.Time),
+		items:    make(map[string]*Ban),
+		now:      time.Now,
+	}
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func userKey(user string) string {
+	return "user:" + user
+}
+
+// setPolicy applies the thresholds and ignored addresses of config, keeping the
+// failures and bans counted so far
+func (s *banStore) setPolicy(config *BanConfig) error {
+	if nil == config {
+		config = &BanConfig{}
+	}
+	ignore, err := parseNetworks(config.IgnoreIPs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setPolicyLocked(config, ignore)
+	return nil
+}
+
+func (s *banStore) setPolicyLocked(config *BanConfig, ignore []*net.IPNet) {
+	s.maxFailures = config.MaxFailures
+	s.findTime = orDefault(config.FindTime, defaultFindTime)
+	s.banTime = orDefault(config.BanTime, defaultBanTime)
+	s.maxBanTime = orDefault(config.MaxBanTime, defaultMaxBanTime)
+	s.ignore = ignore
+}
+
+// configure applies config and loads the bans persisted in its file
+func (s *banStore) configure(config *BanConfig) error {
+	if nil == config {
+		config = &BanConfig{}
+	}
+	ignore, err := parseNetworks(config.IgnoreIPs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setPolicyLocked(config, ignore)
+	s.path = config.File
+	s.failures = make(map[string][]time.Time)
+	s.items = make(map[string]*Ban)
+	if "" == s.path {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	items := make([]*Ban, 0)
+	if err = json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, b := range items {
+		s.items[b.Key] = b
+	}
+	return nil
+}
+
+func (s *banStore) ignored(key string) bool {
+	ip := net.ParseIP(strings.TrimPrefix(key, "ip:"))
+	if !strings.HasPrefix(key, "ip:") || nil == ip {
+		return false
+	}
+	for _, network := range s.ignore {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Banned returns the active ban of key
+func (s *banStore) Banned(key string) (Ban, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.items[key]
+	if !ok || !b.Active(s.now()) {
+		return Ban{}, false
+	}
+	return *b, true
+}
+
+// Fail records a failed login of key and returns the ban it starts, if any
+func (s *banStore) Fail(key string) (Ban, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxFailures <= 0 || s.ignored(key) {
+		return Ban{}, false
+	}
+	now := s.now()
+	s.calls++
+	if s.calls >= banSweepEvery {
+		s.calls = 0
+		s.sweepLocked(now)
+	}
+	if b, ok := s.items[key]; ok && b.Active(now) {
+		return Ban{}, false
+	}
+
+	recent := s.failures[key][:0]
+	for _, at := range s.failures[key] {
+		if now.Sub(at) < s.findTime {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < s.maxFailures {
+		s.failures[key] = recent
+		return Ban{}, false
+	}
+	delete(s.failures, key)
+
+	b, ok := s.items[key]
+	if !ok || now.Sub(b.Until) > s.maxBanTime {
+		b = &Ban{Key: key}
+		s.items[key] = b
+	}
+	b.Offenses++
+	duration := s.banTime
+	for i := 1; i < b.Offenses && duration < s.maxBanTime; i++ {
+		duration *= 2
+	}
+	b.Until = now.Add(min(duration, s.maxBanTime))
+	if err := s.saveLocked(); err != nil {
+		logger.Error("save bans failed", err, map[string]interface{}{
+			"module": "auth",
+			"path":   s.path,
+		})
+	}
+	return *b, true
+}
+
+// sweepLocked forgets expired failures and bans no longer counting towards the next one
+func (s *banStore) sweepLocked(now time.Time) {
+	for key, times := range s.failures {
+		if now.Sub(times[len(times)-1]) >= s.findTime {
+			delete(s.failures, key)
+		}
+	}
+	for key, b := range s.items {
+		if now.Sub(b.Until) > s.maxBanTime {
+			delete(s.items, key)
+		}
+	}
+}
+
+// List returns the active bans sorted by key
+func (s *banStore) List() []*Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	items := make([]*Ban, 0, len(s.items))
+	for _, b := range s.items {
+		if b.Active(now) {
+			c := *b
+			items = append(items, &c)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
+
+// Unban lifts the ban of key, its offenses still double the next one
+func (s *banStore) Unban(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.items[key]
+	if !ok || !b.Active(s.now()) {
+		return false, nil
+	}
+	b.Until = s.now()
+	delete(s.failures, key)
+	return true, s.saveLocked()
+}
+
+func (s *banStore) saveLocked() error {
+	if "" == s.path {
+		return nil
+	}
+	items := make([]*Ban, 0, len(s.items))
+	for _, b := range s.items {
+		items = append(items, b)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+const publicKeyRejected = "publicKeyRejected"
+
+func remotePort(addr net.Addr) int {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.Port
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// refuseBanned reports whether the client IP of conn is banned
+func refuseBanned(conn net.Conn) bool {
+	ip := remoteIP(conn.RemoteAddr())
+	b, banned := bans.Banned(ipKey(ip))
+	if !banned {
+		return false
+	}
+	metrics.SSHRejected.WithLabelValues("banned").Inc()
+	authLog.event("Refused connection from %s port %d: banned until %s", ip, remotePort(conn.RemoteAddr()),
+		b.Until.UTC().Format(time.RFC3339))
+	return true
+}
+
+// loginBanned reports whether ctx may no longer log in, password logins also check the username
+func loginBanned(ctx ssh.Context, password bool) bool {
+	if _, banned := bans.Banned(ipKey(remoteIP(ctx.RemoteAddr()))); banned {
+		return true
+	}
+	if user, ok := ctx.Value(ssh.ContextKeyUser).(string); ok && password {
+		_, banned := bans.Banned(userKey(user))
+		return banned
+	}
+	return false
+}
+
+// loginFailed records a failed login of ctx, failed passwords also count against the username
+func loginFailed(ctx ssh.Context, method string) {
+	user, _ := ctx.Value(ssh.ContextKeyUser).(string)
+	ip := remoteIP(ctx.RemoteAddr())
+	authLog.event("Failed %s for %s from %s port %d", method, logField(user), ip, remotePort(ctx.RemoteAddr()))
+	keys := []string{ipKey(ip)}
+	if "password" == method && "" != user {
+		keys = append(keys, userKey(user))
+	}
+	for _, key := range keys {
+		if b, banned := bans.Fail(key); banned {
+			authLog.event("Ban %s for %s", logField(key), b.Until.Sub(bans.now()).Round(time.Second))
+		}
+	}
+}
+
+// watchPublicKeyFailure counts a connection closed after only rejected public keys as one
+// failure, clients offer each of their keys before falling back to other methods
+func watchPublicKeyFailure(ctx ssh.Context) {
+	go func() {
+		<-ctx.Done()
+		rejected, _ := ctx.Value(publicKeyRejected).(bool)
+		if rejected && nil == ctx.Value(ssh.ContextKeyConn) {
+			loginFailed(ctx, "publickey")
+		}
+	}()
+}
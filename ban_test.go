@@ -0,0 +1,220 @@
+package echogy
+
+import (
+	"github.com/echogy-io/echogy/pkg/auth"
+	gossh "golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBanStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	path := filepath.Join(t.TempDir(), "bans.json")
+	s := newBanStore()
+	s.now = func() time.Time { return now }
+	if err := s.configure(&BanConfig{
+		MaxFailures: 2,
+		FindTime:    Duration(time.Minute),
+		BanTime:     Duration(time.Minute),
+		MaxBanTime:  Duration(3 * time.Minute),
+		File:        path,
+		IgnoreIPs:   []string{"10.0.0.0/8", "192.0.2.1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		advance time.Duration
+		key     string
+		want    time.Duration // ban started by the failure, 0 for none
+	}{
+		{0, "ip:192.0.2.2", 0},
+		{2 * time.Minute, "ip:192.0.2.2", 0},
+		{0, "ip:192.0.2.2", time.Minute},
+		{0, "ip:192.0.2.2", 0},
+		{time.Minute, "ip:192.0.2.2", 0},
+		{0, "ip:192.0.2.2", 2 * time.Minute},
+		{2 * time.Minute, "ip:192.0.2.2", 0},
+		{0, "ip:192.0.2.2", 3 * time.Minute},
+		{0, "ip:192.0.2.1", 0},
+		{0, "ip:192.0.2.1", 0},
+		{0, "ip:10.1.2.3", 0},
+		{0, "ip:10.1.2.3", 0},
+		{0, "user:alice", 0},
+		{0, "user:alice", time.Minute},
+		{time.Hour, "ip:192.0.2.2", 0},
+		{0, "ip:192.0.2.2", time.Minute},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		b, banned := s.Fail(tt.key)
+		var got time.Duration
+		if banned {
+			got = b.Until.Sub(now)
+		}
+		if got != tt.want {
+			t.Errorf("step %d: Fail(%q) banned for %v, want %v", i, tt.key, got, tt.want)
+		}
+	}
+
+	if _, banned := s.Banned("ip:192.0.2.2"); !banned {
+		t.Fatal("ip:192.0.2.2 not banned")
+	}
+	reloaded := newBanStore()
+	reloaded.now = s.now
+	if err := reloaded.configure(&BanConfig{MaxFailures: 2, File: path}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reloaded.List()); 1 != got {
+		t.Errorf("%d bans after reload, want 1", got)
+	}
+	if lifted, err := reloaded.Unban("ip:192.0.2.2"); !lifted || err != nil {
+		t.Errorf("Unban = %v, %v", lifted, err)
+	}
+	if lifted, _ := reloaded.Unban("ip:192.0.2.2"); lifted {
+		t.Error("Unban of a lifted ban succeeded")
+	}
+	if _, banned := reloaded.Banned("ip:192.0.2.2"); banned {
+		t.Error("ban still active after Unban")
+	}
+}
+
+func TestBanConfigValidate(t *testing.T) {
+	tests := []struct {
+		config BanConfig
+		errs   int
+	}{
+		{BanConfig{MaxFailures: 5, IgnoreIPs: []string{"127.0.0.1", "::1", "10.0.0.0/8"}}, 0},
+		{BanConfig{MaxFailures: -1}, 1},
+		{BanConfig{IgnoreIPs: []string{"localhost"}}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.config.validate(); len(errs) != tt.errs {
+			t.Errorf("%+v: errors %v, want %d", tt.config, errs, tt.errs)
+		}
+	}
+}
+
+func TestBanBruteForce(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "auth.log")
+	if err := bans.configure(&BanConfig{MaxFailures: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := authLog.open(logFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		bans.configure(nil)
+		authLog.open("")
+	})
+
+	authenticator := auth.New(nil, []*auth.PasswordAuth{{Username: "bob", Password: "secret", Alias: "bob"}})
+	server := newSessionServer("", "webs.sh", newHostKeys([]gossh.Signer{newTestSigner(t, false)}), 80, authenticator, newSSHLimits(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	login := func(password string) error {
+		client, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User: "bob",
+			Auth: []gossh.AuthMethod{gossh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+				return []string{password}, nil
+			})},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+		if nil == err {
+			client.Close()
+		}
+		return err
+	}
+	if err = login("secret"); err != nil {
+		t.Fatal(err)
+	}
+	login("guess")
+	login("guess")
+	if err = login("secret"); nil == err {
+		t.Fatal("login from a banned IP succeeded")
+	}
+	if _, banned := bans.Banned(userKey("bob")); !banned {
+		t.Error("user:bob not banned")
+	}
+
+	authLog.open("")
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Failed password for bob from 127.0.0.1 port ", "Ban ip:127.0.0.1 for 10m0s", "Refused connection from 127.0.0.1"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("auth log misses %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestAuthLogForgedUser(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "auth.log")
+	if err := bans.configure(&BanConfig{MaxFailures: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := authLog.open(logFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		bans.configure(nil)
+		authLog.open("")
+	})
+
+	authenticator := auth.New(nil, []*auth.PasswordAuth{{Username: "bob", Password: "secret", Alias: "bob"}})
+	server := newSessionServer("", "webs.sh", newHostKeys([]gossh.Signer{newTestSigner(t, false)}), 80, authenticator, newSSHLimits(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	// the username tries to add a failure of another address
+	client, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+		User: "x from 192.0.2.7 port 1\nFailed password for x",
+		Auth: []gossh.AuthMethod{gossh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+			return []string{"guess"}, nil
+		})},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if nil == err {
+		client.Close()
+		t.Fatal("login with a wrong password succeeded")
+	}
+
+	authLog.open("")
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		if strings.Contains(line, "192.0.2.7 port") || strings.Contains(line, "for x from") {
+			t.Errorf("forged auth log line %q", line)
+		}
+	}
+	want := `Failed password for x\x20from\x20192.0.2.7\x20port\x201\nFailed\x20password\x20for\x20x from 127.0.0.1 port `
+	if !strings.Contains(lines[0], want) {
+		t.Errorf("auth log line %q, want it to contain %q", lines[0], want)
+	}
+	if 3 != len(lines) {
+		t.Errorf("auth log has %d lines, want a failure and two bans:\n%s", len(lines), data)
+	}
+}
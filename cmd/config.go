@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/echogy-io/echogy"
+	"github.com/echogy-io/echogy/pkg/auth"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type AuthConfig struct {
+	PubKeys   []*auth.PubKeyAuth   `json:"pubKeys"`
+	Passwords []*auth.PasswordAuth `json:"passwords"`
+}
+
+type SysConfig struct {
+	LogLevel    string `json:"logLevel"`
+	LogFile     string `json:"logFile"` // Path to log file
+	EnablePProf bool   `json:"pprof"`
+	*echogy.Config
+	Auth *AuthConfig `json:"auth"`
+}
+
+var logLevels = []string{"debug", "info", "warn", "error", "fatal", "panic"}
+
+// Validate reports every invalid setting, fields are named by their JSON path
+func (c *SysConfig) Validate() error {
+	var errs []error
+	if "" != c.LogLevel && !contains(logLevels, c.LogLevel) {
+		errs = append(errs, fmt.Errorf("logLevel: %q is not one of %s", c.LogLevel, strings.Join(logLevels, ", ")))
+	}
+	if nil == c.Config {
+		errs = append(errs, errors.New("httpAddr, sshAddr, domain and privateKey are required"))
+	} else if err := c.Config.Validate(); nil != err {
+		errs = append(errs, err)
+	}
+	if nil != c.Auth {
+		for i, key := range c.Auth.PubKeys {
+			if nil == key {
+				errs = append(errs, fmt.Errorf("auth.pubKeys[%d]: must not be null", i))
+			} else if err := key.Validate(); nil != err {
+				errs = append(errs, prefixErrors(fmt.Sprintf("auth.pubKeys[%d].", i), err))
+			}
+		}
+		for i, password := range c.Auth.Passwords {
+			if nil == password {
+				errs = append(errs, fmt.Errorf("auth.passwords[%d]: must not be null", i))
+			} else if err := password.Validate(); nil != err {
+				errs = append(errs, prefixErrors(fmt.Sprintf("auth.passwords[%d].", i), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixErrors prefixes every line of err, errors.Join puts one error per line
+func prefixErrors(prefix string, err error) error {
+	lines := strings.Split(err.Error(), "\n")
+	errs := make([]error, len(lines))
+	for i, line := range lines {
+		errs[i] = errors.New(prefix + line)
+	}
+	return errors.Join(errs...)
+}
+
+// syntaxError locates a JSON syntax error by line and column
+func syntaxError(data []byte, err error) error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+	before := data[:min(int(syntax.Offset), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return fmt.Errorf("line %d column %d: %v", line, column, err)
+}
+
+// parseConfig reads a JSON, YAML or TOML document, chosen by the extension of path
+func parseConfig(path string, data []byte) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); nil != err {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); nil != err {
+			return nil, err
+		}
+	default:
+		if err := json.Unmarshal(data, &raw); nil != err {
+			return nil, syntaxError(data, err)
+		}
+	}
+	if nil == raw {
+		raw = make(map[string]interface{})
+	}
+	return raw, nil
+}
+
+// decodeConfig converts raw strictly, unknown fields are reported with their path
+func decodeConfig(raw map[string]interface{}) (*SysConfig, error) {
+	// YAML and TOML decode into other Go types than JSON does
+	data, err := json.Marshal(raw)
+	if nil != err {
+		return nil, err
+	}
+	var normalized interface{}
+	if err = json.Unmarshal(data, &normalized); nil != err {
+		return nil, err
+	}
+	if errs := unknownFields(normalized, reflect.TypeOf(SysConfig{}), ""); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	var c SysConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&c); nil != err {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && "" != typeErr.Field {
+			return nil, fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// loadConfig reads the config file at path, an empty path starts from no settings,
+// then applies the ECHOGY_* environment variables and validates the result
+func loadConfig(path string) (*SysConfig, error) {
+	raw := make(map[string]interface{})
+	if "" != path {
+		data, err := os.ReadFile(path)
+		if nil != err {
+			return nil, err
+		}
+		if raw, err = parseConfig(path, data); nil != err {
+			return nil, err
+		}
+	}
+	if err := applyEnv(raw, os.Environ()); nil != err {
+		return nil, err
+	}
+	c, err := decodeConfig(raw)
+	if nil != err {
+		return nil, err
+	}
+	if err = c.Validate(); nil != err {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkConfig implements the check-config subcommand, it returns the exit code
+func checkConfig(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	fs.SetOutput(out)
+	conf := fs.String("c", "config.json", "config file to check, empty to check ECHOGY_* variables only")
+	if err := fs.Parse(args); nil != err {
+		return 2
+	}
+	name := *conf
+	if "" == name {
+		name = "environment"
+	}
+	if _, err := loadConfig(*conf); nil != err {
+		fmt.Fprintf(out, "%s is invalid:\n", name)
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+		return 1
+	}
+	fmt.Fprintf(out, "%s is valid\n", name)
+	return 0
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// jsonFields maps the lower-cased JSON names of t to their field types, embedded structs included
+func jsonFields(t reflect.Type, fields map[string]reflect.Type, names map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if "-" == tag {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && "" == name {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				jsonFields(ft, fields, names)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if "" == name {
+			name = f.Name
+		}
+		// encoding/json matches names case-insensitively
+		fields[strings.ToLower(name)] = ft
+		names[strings.ToLower(name)] = name
+	}
+}
+
+// unknownFields walks raw along t and reports keys that t does not declare
+func unknownFields(raw interface{}, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+	var errs []error
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		names := make(map[string]string)
+		jsonFields(t, fields, names)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ft, found := fields[strings.ToLower(k)]
+			if !found {
+				errs = append(errs, unknownField(path+k, k, names))
+				continue
+			}
+			errs = append(errs, unknownFields(m[k], ft, path+names[strings.ToLower(k)]+".")...)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range items {
+			errs = append(errs, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i))...)
+		}
+	}
+	return errs
+}
+
+func unknownField(path, key string, names map[string]string) error {
+	best, distance := "", 3
+	for _, name := range names {
+		if d := levenshtein(strings.ToLower(key), strings.ToLower(name)); d < distance {
+			best, distance = name, d
+		}
+	}
+	if "" != best {
+		return fmt.Errorf("%s: unknown field, did you mean %q?", path, best)
+	}
+	return fmt.Errorf("%s: unknown field", path)
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// authEntries indexes the credentials of c, passwords by username and keys by fingerprint
+func authEntries(c *SysConfig) map[string]interface{} {
+	entries := make(map[string]interface{})
+	if nil == c.Auth {
+		return entries
+	}
+	for _, p := range c.Auth.Passwords {
+		entries["auth.passwords."+p.Username] = *p
+	}
+	for _, k := range c.Auth.PubKeys {
+		key := k.PubKey
+		if pub, _, _, _, err := gossh.ParseAuthorizedKey([]byte(k.PubKey)); nil == err {
+			key = gossh.FingerprintSHA256(pub)
+		}
+		entries["auth.pubKeys."+key] = *k
+	}
+	return entries
+}
+
+// authChanges lists the users and keys added, removed or changed between old and next,
+// sorted by key. Passwords never appear, a changed entry is only named.
+func authChanges(old, next *SysConfig) []string {
+	before, after := authEntries(old), authEntries(next)
+	changes := make([]string, 0)
+	for key, entry := range before {
+		if changed, found := after[key]; !found {
+			changes = append(changes, key+": removed")
+		} else if !reflect.DeepEqual(entry, changed) {
+			changes = append(changes, key+": changed")
+		}
+	}
+	for key := range after {
+		if _, found := before[key]; !found {
+			changes = append(changes, key+": added")
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
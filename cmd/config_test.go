@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSampleConfig(t *testing.T) {
+	var out bytes.Buffer
+	if code := checkConfig([]string{"-c", "../config.sample.json"}, &out); 0 != code {
+		t.Fatalf("checkConfig() = %d, output:\n%s", code, out.String())
+	}
+}
+
+func decodeJSON(data string) (*SysConfig, error) {
+	raw, err := parseConfig("config.json", []byte(data))
+	if nil != err {
+		return nil, err
+	}
+	return decodeConfig(raw)
+}
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"valid", `{"logLevel": "info", "domain": "webs.sh", "auth": {"pubKeys": [{"pubKey": "", "alias": "a"}]}}`, ""},
+		{"field names ignore case", `{"sshAddr": ":2222", "SSHAddr": ":2222"}`, ""},
+		{"syntax", "{\n  \"domain\": \"webs.sh\",,\n}", "line 2 column 23:"},
+		{"top level", `{"domian": "webs.sh"}`, `domian: unknown field, did you mean "domain"?`},
+		{"embedded", `{"history": {"maxEntrys": 1}}`, `history.maxEntrys: unknown field, did you mean "maxEntries"?`},
+		{"slice", `{"auth": {"passwords": [{}, {"usename": "a"}]}}`, `auth.passwords[1].usename: unknown field, did you mean "username"?`},
+		{"no suggestion", `{"admin": {"listen": ":9090"}}`, "admin.listen: unknown field"},
+		{"type", `{"auth": {"passwords": [{"username": 3}]}}`, "auth.passwords.0.username: expected string, got number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeJSON(tt.data)
+			if "" == tt.want {
+				if nil != err {
+					t.Fatalf("decodeConfig() = %v, want nil", err)
+				}
+				return
+			}
+			if nil == err || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("decodeConfig() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSysConfigValidate(t *testing.T) {
+	c, err := decodeJSON(`{
+  "logLevel": "verbose",
+  "auth": {
+    "pubKeys": [{"pubKey": "ssh-ed25519 nope", "alias": "Bad_Alias"}],
+    "passwords": [null]
+  }
+}`)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = c.Validate()
+	if nil == err {
+		t.Fatal("Validate() = nil")
+	}
+	for _, want := range []string{
+		`logLevel: "verbose" is not one of`,
+		"httpAddr, sshAddr, domain and privateKey are required",
+		"auth.pubKeys[0].pubKey:",
+		`auth.pubKeys[0].alias: "Bad_Alias" is not a lowercase DNS label`,
+		"auth.passwords[0]: must not be null",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %q, missing %q", err, want)
+		}
+	}
+}
+
+func containsLine(s, prefix string) bool {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseConfig(t *testing.T) {
+	want := map[string]interface{}{
+		"domain":  "webs.sh",
+		"history": map[string]interface{}{"maxEntries": float64(50), "maxAge": "1h"},
+		"auth": map[string]interface{}{"passwords": []interface{}{
+			map[string]interface{}{"username": "u", "password": "p"},
+		}},
+	}
+	tests := []struct {
+		path string
+		data string
+	}{
+		{"config.json", `{"domain": "webs.sh", "history": {"maxEntries": 50, "maxAge": "1h"}, "auth": {"passwords": [{"username": "u", "password": "p"}]}}`},
+		{"config.yaml", "domain: webs.sh\nhistory:\n  maxEntries: 50\n  maxAge: 1h\nauth:\n  passwords:\n    - username: u\n      password: p\n"},
+		{"config.toml", "domain = \"webs.sh\"\n[history]\nmaxEntries = 50\nmaxAge = \"1h\"\n[[auth.passwords]]\nusername = \"u\"\npassword = \"p\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			raw, err := parseConfig(tt.path, []byte(tt.data))
+			if nil != err {
+				t.Fatal(err)
+			}
+			// compare the JSON form decodeConfig sees
+			data, _ := json.Marshal(raw)
+			var got map[string]interface{}
+			json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseConfig() = %v, want %v", got, want)
+			}
+			if _, err = decodeConfig(raw); nil != err {
+				t.Errorf("decodeConfig() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// envPrefix starts the environment variables overriding config fields
+const envPrefix = "ECHOGY_"
+
+// envName converts a JSON field name to its environment form, maxEntries to MAX_ENTRIES
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			// SSHAddr splits before the last capital of the acronym
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+type envField struct {
+	path []string
+	t    reflect.Type
+}
+
+// envFields maps the environment variable of every setting below t to its JSON path
+func envFields(t reflect.Type, prefix string, path []string, into map[string]envField) {
+	fields := make(map[string]reflect.Type)
+	names := make(map[string]string)
+	jsonFields(t, fields, names)
+	for k, ft := range fields {
+		name := prefix + envName(names[k])
+		fieldPath := append(append([]string{}, path...), names[k])
+		st := ft
+		for st.Kind() == reflect.Pointer {
+			st = st.Elem()
+		}
+		if st.Kind() == reflect.Struct && !reflect.PointerTo(st).Implements(unmarshalerType) {
+			envFields(st, name+"_", fieldPath, into)
+			continue
+		}
+		into[name] = envField{path: fieldPath, t: st}
+	}
+}
+
+// envValue converts value to the JSON form of t, lists and maps are written as JSON
+func envValue(t reflect.Type, value string) (interface{}, error) {
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		var v interface{}
+		if nil == json.Unmarshal([]byte(value), &v) {
+			return v, nil
+		}
+		return value, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); nil != err {
+		return nil, fmt.Errorf("expected JSON: %v", err)
+	}
+	return v, nil
+}
+
+// setPath stores value in raw under path, matching existing keys case-insensitively
+func setPath(raw map[string]interface{}, path []string, value interface{}) {
+	for i, name := range path {
+		key := name
+		for k := range raw {
+			if strings.EqualFold(k, name) {
+				key = k
+				break
+			}
+		}
+		if i == len(path)-1 {
+			raw[key] = value
+			return
+		}
+		next, ok := raw[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			raw[key] = next
+		}
+		raw = next
+	}
+}
+
+// applyEnv overrides the settings of raw with ECHOGY_* variables of environ,
+// such as ECHOGY_HTTP_ADDR or ECHOGY_ADMIN_TOKEN. Variables naming no setting
+// are left to other uses.
+func applyEnv(raw map[string]interface{}, environ []string) error {
+	fields := make(map[string]envField)
+	envFields(reflect.TypeOf(SysConfig{}), envPrefix, nil, fields)
+	var errs []error
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		f, ok := fields[name]
+		if !ok {
+			continue
+		}
+		v, err := envValue(f.t, value)
+		if nil != err {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			continue
+		}
+		setPath(raw, f.path, v)
+	}
+	return errors.Join(errs...)
+}
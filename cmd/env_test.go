@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"domain", "DOMAIN"},
+		{"httpAddr", "HTTP_ADDR"},
+		{"SSHAddr", "SSH_ADDR"},
+		{"urlPath", "URL_PATH"},
+		{"maxTotalBytes", "MAX_TOTAL_BYTES"},
+		{"pubKeys", "PUB_KEYS"},
+	}
+	for _, tt := range tests {
+		if got := envName(tt.name); got != tt.want {
+			t.Errorf("envName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	raw := map[string]interface{}{
+		"sshAddr": ":2222",
+		"admin":   map[string]interface{}{"addr": ":9090"},
+	}
+	err := applyEnv(raw, []string{
+		"ECHOGY_SSH_ADDR=:22",
+		"ECHOGY_ADMIN_TOKEN=secret",
+		"ECHOGY_ADMIN_MAX_ALIASES=10",
+		"ECHOGY_HISTORY_MAX_AGE=1h",
+		"ECHOGY_SHUTDOWN_TIMEOUT=5",
+		"ECHOGY_PPROF=true",
+		"ECHOGY_TRACING_HEADERS={\"x-key\": \"v\"}",
+		"ECHOGY_AUTH_PASSWORDS=[{\"username\": \"u\", \"password\": \"p\"}]",
+		"ECHOGY_LISTENERS=facade:3",
+		"PATH=/bin",
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"sshAddr":         ":22",
+		"admin":           map[string]interface{}{"addr": ":9090", "token": "secret", "maxAliases": int64(10)},
+		"history":         map[string]interface{}{"maxAge": "1h"},
+		"shutdownTimeout": float64(5),
+		"pprof":           true,
+		"tracing":         map[string]interface{}{"headers": map[string]interface{}{"x-key": "v"}},
+		"auth": map[string]interface{}{"passwords": []interface{}{
+			map[string]interface{}{"username": "u", "password": "p"},
+		}},
+	}
+	if !reflect.DeepEqual(raw, want) {
+		t.Errorf("applyEnv() = %v, want %v", raw, want)
+	}
+
+	c, err := decodeConfig(raw)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if ":22" != c.SSHAddr || "secret" != c.Admin.Token || 1 != len(c.Auth.Passwords) || "v" != c.Tracing.Headers["x-key"] {
+		t.Errorf("decodeConfig() = %+v", c)
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	err := applyEnv(make(map[string]interface{}), []string{
+		"ECHOGY_PPROF=sometimes",
+		"ECHOGY_AUTH_PUB_KEYS=ssh-ed25519 AAAA",
+	})
+	if nil == err {
+		t.Fatal("applyEnv() = nil")
+	}
+	for _, want := range []string{"ECHOGY_PPROF:", "ECHOGY_AUTH_PUB_KEYS: expected JSON"} {
+		if !containsLine(err.Error(), want) {
+			t.Errorf("applyEnv() = %q, missing %q", err, want)
+		}
+	}
+}
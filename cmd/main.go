@@ -2,22 +2,20 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/echogy-io/echogy/pkg/auth"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/echogy-io/echogy"
 	"github.com/echogy-io/echogy/pkg/logger"
 	"github.com/echogy-io/echogy/pkg/pprof"
+	"github.com/echogy-io/echogy/pkg/upgrade"
 	"github.com/rs/zerolog"
 )
 
-var _conf = flag.String("c", "config.json", "config file, format json")
+var _conf = flag.String("c", "config.json", "config file, json, yaml or toml by extension, empty to configure by ECHOGY_* variables only")
 var _pidFile = flag.String("pid", "", "pid file path (default: executable directory)")
 
 func logLevel(level string) zerolog.Level {
@@ -47,21 +45,50 @@ func setOSEnv() {
 	os.Setenv("TERM_PROGRAM", "xterm")
 }
 
-type AuthConfig struct {
-	PubKeys   []*auth.PubKeyAuth   `json:"pubKeys"`
-	Passwords []*auth.PasswordAuth `json:"passwords"`
+// upgradeTimeout bounds how long a new process may take to bind its inherited listeners
+const upgradeTimeout = 30 * time.Second
+
+// reloadConfig re-reads the config file and applies what can change without a restart,
+// nothing is applied when the file is invalid or its core settings cannot be applied
+func reloadConfig(current *SysConfig, a *auth.Dynamic) *SysConfig {
+	fields := logger.Fields{"module": "reload", "path": *_conf}
+	next, err := loadConfig(*_conf)
+	if nil != err {
+		logger.Error("invalid config, keep running", err, fields)
+		return current
+	}
+	// the core settings go first, nothing changes when they cannot be applied
+	if _, err = echogy.Reload(next.Config); nil != err {
+		logger.Error("reload config failed, keep running", err, fields)
+		return current
+	}
+	// the credentials come from the validated config, not from reading the file again
+	a.Set(newAuth(next))
+	for _, change := range authChanges(current, next) {
+		logger.Warn("config changed", logger.Fields{"module": "reload", "change": change})
+	}
+	if next.LogLevel != current.LogLevel {
+		logger.SetLogLevel(logLevel(next.LogLevel))
+		logger.Warn("config changed", logger.Fields{"module": "reload", "change": fmt.Sprintf("logLevel: %s -> %s", current.LogLevel, next.LogLevel)})
+	}
+	logger.Warn("config reloaded", fields)
+	return next
 }
 
-type SysConfig struct {
-	LogLevel    string `json:"logLevel"`
-	LogFile     string `json:"logFile"` // Path to log file
-	EnablePProf bool   `json:"pprof"`
-	*echogy.Config
-	Auth *AuthConfig `json:"auth"`
+// newAuth builds the credentials of the auth section of c
+func newAuth(c *SysConfig) auth.Auth {
+	if nil == c.Auth {
+		return auth.New(nil, nil)
+	}
+	return auth.New(c.Auth.PubKeys, c.Auth.Passwords)
 }
 
 func main() {
 
+	if len(os.Args) > 1 && "check-config" == os.Args[1] {
+		os.Exit(checkConfig(os.Args[2:], os.Stdout))
+	}
+
 	flag.Parse()
 
 	// Create PID file
@@ -76,29 +103,25 @@ func main() {
 
 	setOSEnv()
 
-	// Write PID to file
-	pid := os.Getpid()
-	if err := os.WriteFile(pidPath, []byte(fmt.Sprint(pid)), 0644); err != nil {
-		panic(err)
+	// Write PID to file, an upgraded process takes the file over once its listeners are ready
+	upgrade.SetPidFile(pidPath)
+	if !upgrade.Inherited() {
+		pid := os.Getpid()
+		if err := os.WriteFile(pidPath, []byte(fmt.Sprint(pid)), 0644); err != nil {
+			panic(err)
+		}
 	}
-	defer os.Remove(pidPath)
+	defer upgrade.RemovePidFile()
 
-	f, err := os.ReadFile(*_conf)
+	sysConfig, err := loadConfig(*_conf)
 
 	if nil != err {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Failed to load config %s:\n%v\n", *_conf, err)
+		os.Exit(1)
 	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	var sysConfig SysConfig
-
-	err = json.Unmarshal(f, &sysConfig)
-
-	if nil != err {
-		panic(fmt.Sprintf("Failed to setup log file: %v", err))
-	}
-
 	logger.SetLogLevel(logLevel(sysConfig.LogLevel))
 
 	// Setup log file output if configured
@@ -110,21 +133,42 @@ func main() {
 	}
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
+	notifySignals(c)
 
 	if sysConfig.EnablePProf {
-		go func() {
-			pprof.Serve()
-		}()
+		if ln, err := upgrade.Listen("pprof", "tcp", pprof.Addr); nil != err {
+			logger.Error("pprof server failed", err, logger.Fields{"module": "pprof", "listen": pprof.Addr})
+		} else {
+			go pprof.Serve(ln)
+		}
 	}
 
-	_auth := auth.New(sysConfig.Auth.PubKeys, sysConfig.Auth.Passwords)
+	// credentials are re-read from the config file on SIGHUP or when the admin API reloads auth
+	_auth, err := auth.NewDynamic(func() (auth.Auth, error) {
+		c, err := loadConfig(*_conf)
+		if nil != err {
+			return nil, err
+		}
+		return newAuth(c), nil
+	})
+	if nil != err {
+		panic(fmt.Sprintf("Failed to load auth: %v", err))
+	}
 
-	go func() {
-		echogy.Serve(ctx, sysConfig.Config, _auth)
-	}()
-	<-c
+	done := make(chan struct{})
+	go func(config *echogy.Config) {
+		echogy.Serve(ctx, config, _auth)
+		close(done)
+	}(sysConfig.Config)
+	waitShutdown(c, func() {
+		sysConfig = reloadConfig(sysConfig, _auth)
+	})
 	logger.WarnN("Echogy will be shutdown")
 	cancelFunc()
-	time.Sleep(1 * time.Second)
+	// a second signal skips draining
+	select {
+	case <-done:
+	case <-c:
+		logger.WarnN("Echogy shutdown forced")
+	}
 }
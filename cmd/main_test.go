@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/echogy-io/echogy"
+	"github.com/echogy-io/echogy/pkg/auth"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadConfigAuth(t *testing.T) {
+	data, err := os.ReadFile("../config.sample.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sample map[string]interface{}
+	if err = json.Unmarshal(data, &sample); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	write := func(user, reservationsFile string) {
+		raw := map[string]interface{}{
+			"httpAddr":   "127.0.0.1:0",
+			"sshAddr":    "127.0.0.1:0",
+			"domain":     "webs.sh",
+			"privateKey": sample["privateKey"],
+			"access":     map[string]interface{}{"anonymous": true},
+			"admin":      map[string]interface{}{"reservationsFile": reservationsFile},
+			"auth": map[string]interface{}{"passwords": []interface{}{
+				map[string]interface{}{"username": user, "password": "p", "alias": user},
+			}},
+		}
+		data, _ := json.Marshal(raw)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(conf string) { *_conf = conf }(*_conf)
+	*_conf = path
+
+	write("stale", "")
+	current, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads := 0
+	a, err := auth.NewDynamic(func() (auth.Auth, error) {
+		loads++
+		return newAuth(current), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		echogy.Serve(ctx, current.Config, a)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for _, err = echogy.Reload(current.Config); nil != err; _, err = echogy.Reload(current.Config) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	write("fresh", "")
+	next := reloadConfig(current, a)
+	if next == current {
+		t.Fatal("reloadConfig() kept the current config")
+	}
+	if 1 != loads {
+		t.Errorf("auth loaded %d times, want the reloaded config used", loads)
+	}
+	if _, ok := a.Password("fresh", "p"); !ok {
+		t.Error("credentials of the reloaded config not applied")
+	}
+	if _, ok := a.Password("stale", "p"); ok {
+		t.Error("old credentials still accepted")
+	}
+
+	// the reservations file is broken, the core settings fail and nothing is applied
+	broken := filepath.Join(dir, "reservations.json")
+	os.WriteFile(broken, []byte("{"), 0600)
+	write("other", broken)
+	if kept := reloadConfig(next, a); kept != next {
+		t.Error("reloadConfig() replaced the config after a failed reload")
+	}
+	if _, ok := a.Password("other", "p"); ok {
+		t.Error("credentials applied although the reload failed")
+	}
+	if _, ok := a.Password("fresh", "p"); !ok {
+		t.Error("running credentials dropped after a failed reload")
+	}
+}
+
+func TestAuthChanges(t *testing.T) {
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINIDzfi6A8gn9iBKdaR6fu/o5NGYzUEF96ly166QOWPd user@example.com"
+	old := &SysConfig{Auth: &AuthConfig{
+		PubKeys: []*auth.PubKeyAuth{{PubKey: key, Alias: "demo"}},
+		Passwords: []*auth.PasswordAuth{
+			{Username: "alice", Password: "a-secret", Alias: "alice"},
+			{Username: "bob", Password: "b-secret", Alias: "bob"},
+		},
+	}}
+	next := &SysConfig{Auth: &AuthConfig{
+		PubKeys: []*auth.PubKeyAuth{{PubKey: key, Alias: "demo", Admin: true}},
+		Passwords: []*auth.PasswordAuth{
+			{Username: "alice", Password: "a-renewed", Alias: "alice"},
+			{Username: "carol", Password: "c-secret", Alias: "carol"},
+		},
+	}}
+
+	got := authChanges(old, next)
+	want := []string{
+		"auth.passwords.alice: changed",
+		"auth.passwords.bob: removed",
+		"auth.passwords.carol: added",
+		"auth.pubKeys.SHA256:",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("authChanges() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("authChanges()[%d] = %q, want prefix %q", i, got[i], want[i])
+		}
+	}
+	if !strings.HasSuffix(got[3], ": changed") {
+		t.Errorf("key change = %q, want it changed", got[3])
+	}
+	for _, change := range got {
+		if strings.Contains(change, "secret") || strings.Contains(change, "renewed") {
+			t.Errorf("change %q leaks a password", change)
+		}
+	}
+	if changes := authChanges(&SysConfig{}, &SysConfig{}); 0 != len(changes) {
+		t.Errorf("authChanges() without auth = %q", changes)
+	}
+}
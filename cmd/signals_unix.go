@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/upgrade"
+)
+
+// notifySignals delivers the signals handled by waitShutdown to c
+func notifySignals(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2, syscall.SIGHUP)
+}
+
+// waitShutdown blocks until a signal asks this process to stop, SIGHUP reloads the
+// config, SIGUSR2 hands the listeners to a new process and stops once it is ready
+func waitShutdown(c chan os.Signal, reload func()) {
+	for sig := range c {
+		if syscall.SIGHUP == sig {
+			reload()
+			continue
+		}
+		if syscall.SIGUSR2 != sig {
+			return
+		}
+		logger.WarnN("Echogy is upgrading")
+		p, err := upgrade.Upgrade(upgradeTimeout)
+		if err != nil {
+			logger.Error("upgrade failed, keep serving", err, logger.Fields{"module": "upgrade"})
+			continue
+		}
+		logger.Warn("upgraded, draining old process", logger.Fields{"module": "upgrade", "pid": p.Pid})
+		return
+	}
+}
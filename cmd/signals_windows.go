@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals delivers the signals handled by waitShutdown to c
+func notifySignals(c chan os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+// waitShutdown blocks until a signal asks this process to stop, Windows has no
+// signals to reload the config or upgrade
+func waitShutdown(c chan os.Signal, reload func()) {
+	<-c
+}
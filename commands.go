@@ -0,0 +1,269 @@
+package echogy
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/gliderlabs/ssh"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes of exec commands
+const (
+	exitOK     = 0
+	exitFailed = 1 // the command ran but could not do what was asked
+	exitUsage  = 2 // unknown command or invalid arguments
+)
+
+// execCommand runs for `ssh host <name> [args]` instead of a tunnel
+type execCommand struct {
+	name string
+	args string
+	help string
+	run  func(session ssh.Session, args []string) int
+}
+
+var execCommands []*execCommand
+
+func init() {
+	// assigned in init since help lists the commands
+	execCommands = []*execCommand{
+		{"status", "[accessId]", "Show the state and counters of your tunnel", statusCommand},
+		{"list", "", "List your live tunnels", listCommand},
+		{"history", "[--last N] [accessId]", "Show the last requests of your tunnel, 20 by default", historyCommand},
+		{"har", "[accessId]", "Export the requests of your tunnel as HTTP archive", harCommand},
+		{"release-alias", "[alias]", "Release the aliases reserved for your key and its stable subdomain", releaseAliasCommand},
+		{"whoami", "", "Show how you are logged in", whoamiCommand},
+		{"help", "", "Show this help", helpCommand},
+	}
+}
+
+// isTunnelCommand reports whether the session asks for a tunnel rather than an exec command
+func isTunnelCommand(cmd []string) bool {
+	return 0 == len(cmd) || "json" == cmd[0] || strings.HasPrefix(cmd[0], "-")
+}
+
+// runCommand runs the exec command of the session and returns its exit code
+func runCommand(session ssh.Session, cmd []string) int {
+	for _, c := range execCommands {
+		if c.name == cmd[0] {
+			return c.run(session, cmd[1:])
+		}
+	}
+	fmt.Fprintf(session.Stderr(), "unknown command %q\n\n", cmd[0])
+	writeHelp(session.Stderr())
+	return exitUsage
+}
+
+func writeHelp(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ssh -R 80:localhost:3000 host -- [json] [options]    open a tunnel, json streams its events")
+	fmt.Fprintln(w, "       ssh host <command> [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Tunnel options, repeat header options for more headers:")
+	tunnelFlags(&tunnelOptions{rules: &headerRules{}}, w).PrintDefaults()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range execCommands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	tw.Flush()
+}
+
+func helpCommand(session ssh.Session, _ []string) int {
+	writeHelp(session)
+	return exitOK
+}
+
+// ownedForwarders returns the live tunnels of the client of ctx, oldest first
+func ownedForwarders(ctx ssh.Context) []*forwarder {
+	owned := make([]*forwarder, 0)
+	rangeForwarders(func(fwd *forwarder) {
+		if isSameOwner(ctx, fwd.sshCtx) {
+			owned = append(owned, fwd)
+		}
+	})
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].startTime.Before(owned[j].startTime)
+	})
+	return owned
+}
+
+// commandForwarder finds the tunnel a command is about, telling the client when there is none
+func commandForwarder(session ssh.Session, args []string) (*forwarder, int) {
+	if len(args) > 1 {
+		fmt.Fprintln(session.Stderr(), "too many arguments")
+		return nil, exitUsage
+	}
+	accessId := ""
+	if 1 == len(args) {
+		accessId = args[0]
+	}
+	fwd, found := findOwnedForwarder(session.Context(), accessId)
+	if !found {
+		fmt.Fprintln(session.Stderr(), "no tunnel found")
+		return nil, exitFailed
+	}
+	return fwd, exitOK
+}
+
+func tunnelURL(fwd *forwarder) string {
+	tunnel, _ := fwd.sshCtx.Value(sshTunnelAddrKey).(string)
+	return "https://" + tunnel
+}
+
+func statusCommand(session ssh.Session, args []string) int {
+	fwd, code := commandForwarder(session, args)
+	if nil == fwd {
+		return code
+	}
+	s := stat.GetStat(fwd.sshCtx).Snapshot()
+	tw := tabwriter.NewWriter(session, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Tunnel:\t%s\n", tunnelURL(fwd))
+	fmt.Fprintf(tw, "Started:\t%s, %s ago\n", fwd.startTime.Format(time.RFC3339), time.Since(fwd.startTime).Truncate(time.Second))
+	if !fwd.expiresAt.IsZero() {
+		fmt.Fprintf(tw, "Expires:\t%s\n", fwd.expiresAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Requests:\t%d, 2xx %d, 3xx %d, 4xx %d, 5xx %d\n", s.Request, s.Status[1], s.Status[2], s.Status[3], s.Status[4])
+	fmt.Fprintf(tw, "Connections:\t%d open, %d total\n", s.ConnCount, s.TotalConn)
+	fmt.Fprintf(tw, "Traffic:\t%d bytes in, %d bytes out\n", s.Receive, s.Send)
+	tw.Flush()
+	return exitOK
+}
+
+func listCommand(session ssh.Session, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(session.Stderr(), "list takes no arguments")
+		return exitUsage
+	}
+	tw := tabwriter.NewWriter(session, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCESS ID\tURL\tUPTIME\tREQUESTS\tCONNS")
+	for _, fwd := range ownedForwarders(session.Context()) {
+		s := stat.GetStat(fwd.sshCtx).Snapshot()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", fwd.accessId, tunnelURL(fwd),
+			time.Since(fwd.startTime).Truncate(time.Second), s.Request, s.ConnCount)
+	}
+	tw.Flush()
+	return exitOK
+}
+
+const defaultHistoryLast = 20
+
+func historyCommand(session ssh.Session, args []string) int {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(session.Stderr())
+	last := flags.Int("last", defaultHistoryLast, "number of requests to show")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if *last <= 0 {
+		fmt.Fprintln(session.Stderr(), "--last must be positive")
+		return exitUsage
+	}
+	fwd, code := commandForwarder(session, flags.Args())
+	if nil == fwd {
+		return code
+	}
+	items := stat.GetHistory(fwd.sshCtx).Items()
+	if len(items) > *last {
+		items = items[len(items)-*last:]
+	}
+	for _, e := range items {
+		fmt.Fprintln(session, requestLine(e))
+	}
+	return exitOK
+}
+
+// releaseAliasCommand releases what the key of the client holds: the given alias,
+// or every alias reserved for the key and its stable subdomain
+func releaseAliasCommand(session ssh.Session, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(session.Stderr(), "too many arguments")
+		return exitUsage
+	}
+	fingerprint, _ := session.Context().Value(clientPublicKeyFingerprintSha256).(string)
+	if "" == fingerprint {
+		fmt.Fprintln(session.Stderr(), "aliases are held by public keys, log in with one")
+		return exitFailed
+	}
+	aliases := make([]string, 0)
+	for _, r := range reservations.List() {
+		if fingerprint == r.Owner && (0 == len(args) || args[0] == r.Alias) {
+			aliases = append(aliases, r.Alias)
+		}
+	}
+	released := make([]string, 0, len(aliases)+1)
+	for _, alias := range aliases {
+		if _, err := reservations.Release(alias); err != nil {
+			fmt.Fprintf(session.Stderr(), "release %s: %v\n", alias, err)
+			return exitFailed
+		}
+		released = append(released, alias)
+	}
+	forget := 0 == len(args)
+	if !forget {
+		owner, _ := keySubdomains.Owner(args[0])
+		forget = fingerprint == owner
+	}
+	if forget {
+		subdomain, found, err := keySubdomains.Forget(fingerprint)
+		if err != nil {
+			fmt.Fprintf(session.Stderr(), "release %s: %v\n", subdomain, err)
+			return exitFailed
+		}
+		if found {
+			released = append(released, subdomain)
+		}
+	}
+	if 0 == len(released) {
+		if 1 == len(args) {
+			fmt.Fprintf(session.Stderr(), "%s is not held by your key\n", args[0])
+		} else {
+			fmt.Fprintln(session.Stderr(), "your key holds no alias")
+		}
+		return exitFailed
+	}
+	fmt.Fprintf(session, "Released %s\n", strings.Join(released, ", "))
+	return exitOK
+}
+
+func whoamiCommand(session ssh.Session, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(session.Stderr(), "whoami takes no arguments")
+		return exitUsage
+	}
+	ctx := session.Context()
+	user, _ := ctx.Value(ssh.ContextKeyUser).(string)
+	alias, _ := ctx.Value(clientHttpAlias).(string)
+	fingerprint, _ := ctx.Value(clientPublicKeyFingerprintSha256).(string)
+	login := "password"
+	if "" != fingerprint {
+		login = "public key"
+	}
+	if isAnonymous(ctx) {
+		login += ", anonymous"
+	}
+	if isAdmin(ctx) {
+		login += ", admin"
+	}
+	tw := tabwriter.NewWriter(session, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "User:\t%s\n", user)
+	fmt.Fprintf(tw, "Login:\t%s\n", login)
+	if "" != alias {
+		fmt.Fprintf(tw, "Alias:\t%s\n", alias)
+	}
+	if "" != fingerprint {
+		fmt.Fprintf(tw, "Key:\t%s\n", fingerprint)
+	}
+	fmt.Fprintf(tw, "Address:\t%s\n", ctx.RemoteAddr())
+	tw.Flush()
+	return exitOK
+}
@@ -0,0 +1,119 @@
+package echogy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	gossh "golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+// runTestCommand runs cmd on a new session of client and returns its output and exit code
+func runTestCommand(t *testing.T, client *gossh.Client, cmd string) (string, string, int) {
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	err = session.Run(cmd)
+	var exit *gossh.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), stderr.String(), exit.ExitStatus()
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", cmd, err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+func TestExecCommands(t *testing.T) {
+	addr := startTestServer(t, nil)
+	key := newTestSigner(t, false)
+
+	tunnel, err := dialTestServer(addr, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	if !forward(tunnel) {
+		t.Fatal("forward refused")
+	}
+	session, err := tunnel.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Start("json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := dialTestServer(addr, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	stranger, err := dialTestServer(addr, newTestSigner(t, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	if err = reservations.Reserve(&Reservation{Alias: "heldapp", Owner: fingerprintSHA256(key.PublicKey())}); err != nil {
+		t.Fatal(err)
+	}
+	defer reservations.Release("heldapp")
+
+	tests := []struct {
+		name   string
+		client *gossh.Client
+		cmd    string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"help", client, "help", exitOK, "release-alias", ""},
+		{"unknown", client, "bogus", exitUsage, "", `unknown command "bogus"`},
+		{"whoami", client, "whoami", exitOK, "User:    register", ""},
+		{"list", client, "list", exitOK, ".webs.sh", ""},
+		{"list of others", stranger, "list", exitOK, "ACCESS ID", ""},
+		{"status", client, "status", exitOK, "Requests:", ""},
+		{"status of others", stranger, "status", exitFailed, "", "no tunnel found"},
+		{"status unknown", client, "status nope", exitFailed, "", "no tunnel found"},
+		{"history", client, "history --last 5", exitOK, "", ""},
+		{"history invalid", client, "history --last 0", exitUsage, "", "must be positive"},
+		{"history bad flag", client, "history --first 5", exitUsage, "", "not defined"},
+		{"release unknown", client, "release-alias nope", exitFailed, "", "not held by your key"},
+		{"release of others", stranger, "release-alias heldapp", exitFailed, "", "not held by your key"},
+		{"release alias", client, "release-alias heldapp", exitOK, "Released heldapp", ""},
+		{"release subdomain", client, "release-alias", exitOK, "Released", ""},
+		{"release nothing", client, "release-alias", exitFailed, "", "holds no alias"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runTestCommand(t, tt.client, tt.cmd)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d, stderr %q", code, tt.code, stderr)
+			}
+			if !strings.Contains(stdout, tt.stdout) {
+				t.Errorf("stdout = %q, want %q", stdout, tt.stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.stderr)
+			}
+		})
+	}
+
+	if stdout, _, _ := runTestCommand(t, stranger, "list"); strings.Contains(stdout, ".webs.sh") {
+		t.Errorf("list shows tunnels of other keys: %q", stdout)
+	}
+}
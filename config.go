@@ -1,8 +1,282 @@
 package echogy
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/echogy-io/echogy/pkg/tracing"
+	gossh "golang.org/x/crypto/ssh"
+	"os"
+	"strings"
+	"time"
+)
+
 type Config struct {
-	HttpAddr   string `json:"httpAddr"`
-	SSHAddr    string `json:"SSHAddr"`
-	Domain     string `json:"domain"`
-	PrivateKey string `json:"privateKey"`
+	HttpAddr string `json:"httpAddr"`
+	SSHAddr  string `json:"SSHAddr"`
+	Domain   string `json:"domain"`
+	// PrivateKey is the host key in PEM, or PrivateKeyFile its path
+	PrivateKey     string `json:"privateKey"`
+	PrivateKeyFile string `json:"privateKeyFile"`
+	// HostCertificate is an OpenSSH host certificate of the host key, in authorized_keys
+	// format, or HostCertificateFile its path. Clients trusting the signing CA skip the
+	// host key prompt.
+	HostCertificate     string `json:"hostCertificate"`
+	HostCertificateFile string `json:"hostCertificateFile"`
+	// HostKeys are paths of further host keys. The first key of each algorithm serves
+	// handshakes, all keys are announced to clients so a new key can be rolled out.
+	HostKeys []string `json:"hostKeys"`
+	// HostKeyDir keeps the host keys generated when none is configured,
+	// the directory of the executable by default
+	HostKeyDir string           `json:"hostKeyDir"`
+	SSH        *SSHConfig       `json:"ssh"`
+	Ban        *BanConfig       `json:"ban"`
+	Access     *AccessConfig    `json:"access"`
+	Subdomains *SubdomainConfig `json:"subdomains"`
+	History    *HistoryConfig   `json:"history"`
+	Admin      *AdminConfig     `json:"admin"`
+	Tracing    *TracingConfig   `json:"tracing"`
+	// ShutdownTimeout bounds how long open connections are drained on shutdown
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// UpgradeDrainTimeout bounds how long the old process serves its tunnels after an upgrade
+	UpgradeDrainTimeout Duration `json:"upgradeDrainTimeout"`
+}
+
+// inlineOrFile returns value, or the contents of the file at path
+func inlineOrFile(field, value, path string) ([]byte, error) {
+	if "" != value && "" != path {
+		return nil, fmt.Errorf("%s: set either %s or %sFile", field, field, field)
+	}
+	if "" == path {
+		return []byte(value), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%sFile: %v", field, err)
+	}
+	return data, nil
+}
+
+// hostSigner loads the host key and its certificate, nil when no key is configured
+func (c *Config) hostSigner() (gossh.Signer, error) {
+	key, err := inlineOrFile("privateKey", c.PrivateKey, c.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if 0 == len(key) {
+		if "" != c.HostCertificate || "" != c.HostCertificateFile {
+			return nil, errors.New("hostCertificate: needs privateKey or privateKeyFile")
+		}
+		return nil, nil
+	}
+	signer, err := gossh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("privateKey: %v", err)
+	}
+	cert, err := inlineOrFile("hostCertificate", c.HostCertificate, c.HostCertificateFile)
+	if err != nil || 0 == len(cert) {
+		return signer, err
+	}
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(cert)
+	if err != nil {
+		return nil, fmt.Errorf("hostCertificate: %v", err)
+	}
+	certificate, ok := pub.(*gossh.Certificate)
+	if !ok || gossh.HostCert != certificate.CertType {
+		return nil, errors.New("hostCertificate: not an OpenSSH host certificate")
+	}
+	certSigner, err := gossh.NewCertSigner(certificate, signer)
+	if err != nil {
+		return nil, fmt.Errorf("hostCertificate: %v", err)
+	}
+	return certSigner, nil
+}
+
+const defaultShutdownTimeout = 30 * time.Second
+
+func (c *Config) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(c.ShutdownTimeout)
+}
+
+const defaultUpgradeDrainTimeout = time.Hour
+
+func (c *Config) upgradeDrainTimeout() time.Duration {
+	if c.UpgradeDrainTimeout <= 0 {
+		return defaultUpgradeDrainTimeout
+	}
+	return time.Duration(c.UpgradeDrainTimeout)
+}
+
+// SSHConfig restricts the SSH algorithms offered to clients and limits connections,
+// empty algorithm lists keep the defaults of golang.org/x/crypto/ssh
+type SSHConfig struct {
+	Ciphers             []string `json:"ciphers"`
+	KeyExchanges        []string `json:"keyExchanges"`
+	MACs                []string `json:"macs"`
+	HostKeyAlgorithms   []string `json:"hostKeyAlgorithms"`
+	PublicKeyAlgorithms []string `json:"publicKeyAlgorithms"` // client key signatures accepted for login
+	MaxAuthTries        int      `json:"maxAuthTries"`        // 0 keeps the default of 6
+	// HandshakesPerMinute limits new connections per client IP, HandshakeBurst of them at once
+	HandshakesPerMinute float64 `json:"handshakesPerMinute"`
+	HandshakeBurst      int     `json:"handshakeBurst"`
+	// MaxSessionsPerKey bounds concurrent connections logged in with the same public key
+	MaxSessionsPerKey int `json:"maxSessionsPerKey"`
+	// IdleTimeout closes connections without traffic for this long
+	IdleTimeout Duration `json:"idleTimeout"`
+}
+
+// TracingConfig exports OpenTelemetry spans to an OTLP/HTTP collector
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint"` // collector host:port, empty disables tracing
+	URLPath     string            `json:"urlPath"`
+	Insecure    bool              `json:"insecure"`
+	SampleRatio float64           `json:"sampleRatio"`
+	ServiceName string            `json:"serviceName"`
+	Headers     map[string]string `json:"headers"`
+}
+
+func (t *TracingConfig) options() tracing.Options {
+	return tracing.Options{
+		Endpoint:    t.Endpoint,
+		URLPath:     t.URLPath,
+		Insecure:    t.Insecure,
+		SampleRatio: t.SampleRatio,
+		ServiceName: t.ServiceName,
+		Headers:     t.Headers,
+	}
+}
+
+// AdminConfig enables the admin HTTP listener serving Prometheus metrics and the management API
+type AdminConfig struct {
+	Addr             string `json:"addr"`
+	AliasLabel       bool   `json:"aliasLabel"`       // label tunnel metrics with their alias
+	MaxAliases       int    `json:"maxAliases"`       // distinct alias label values before folding into "_other"
+	Token            string `json:"token"`            // bearer token of the management API, empty disables it
+	ReservationsFile string `json:"reservationsFile"` // persists reserved aliases, empty keeps them in memory
+}
+
+// HistoryConfig bounds the request history of tunnels, see stat.HistoryOptions
+type HistoryConfig struct {
+	MaxEntries    int      `json:"maxEntries"`
+	MaxBytes      int64    `json:"maxBytes"`
+	MaxAge        Duration `json:"maxAge"`
+	MaxTotalBytes int64    `json:"maxTotalBytes"`
+	Dir           string   `json:"dir"`
+}
+
+func (h *HistoryConfig) options() stat.HistoryOptions {
+	if nil == h {
+		return stat.HistoryOptions{}
+	}
+	return stat.HistoryOptions{
+		MaxEntries:    h.MaxEntries,
+		MaxBytes:      h.MaxBytes,
+		MaxAge:        time.Duration(h.MaxAge),
+		MaxTotalBytes: h.MaxTotalBytes,
+		Dir:           h.Dir,
+	}
+}
+
+// Duration is a time.Duration written as "90s", "10m" or a number of seconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("invalid duration: %s", b)
+	}
+	return nil
+}
+
+func validateAddr(field, addr string) error {
+	if "" == addr {
+		return fmt.Errorf("%s: required", field)
+	}
+	if _, _, err := parseHostAddr(addr); err != nil {
+		return fmt.Errorf("%s: %q is not host:port: %v", field, addr, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting, fields are named by their JSON path
+func (c *Config) Validate() error {
+	var errs []error
+	if err := validateAddr("httpAddr", c.HttpAddr); nil != err {
+		errs = append(errs, err)
+	}
+	if err := validateAddr("SSHAddr", c.SSHAddr); nil != err {
+		errs = append(errs, err)
+	}
+	if "" == c.Domain {
+		errs = append(errs, fmt.Errorf("domain: required"))
+	} else if strings.Contains(c.Domain, "://") || strings.ContainsAny(c.Domain, "/: ") {
+		errs = append(errs, fmt.Errorf("domain: %q must be a bare host name", c.Domain))
+	}
+	if _, err := c.hostSigners(false); err != nil {
+		errs = append(errs, err)
+	}
+	if nil != c.SSH {
+		errs = append(errs, c.SSH.validate()...)
+	}
+	if nil != c.Ban {
+		errs = append(errs, c.Ban.validate()...)
+	}
+	if nil != c.Subdomains {
+		errs = append(errs, c.Subdomains.validate()...)
+	}
+	if nil != c.Access && nil != c.Access.AnonymousLimits {
+		errs = append(errs, c.Access.AnonymousLimits.validate()...)
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdownTimeout: must not be negative"))
+	}
+	if c.UpgradeDrainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("upgradeDrainTimeout: must not be negative"))
+	}
+	if nil != c.History {
+		h := c.History
+		if h.MaxEntries < 0 || h.MaxBytes < 0 || h.MaxAge < 0 || h.MaxTotalBytes < 0 {
+			errs = append(errs, fmt.Errorf("history: limits must not be negative"))
+		}
+	}
+	if nil != c.Admin {
+		if "" != c.Admin.Addr {
+			if err := validateAddr("admin.addr", c.Admin.Addr); nil != err {
+				errs = append(errs, err)
+			}
+		} else if "" != c.Admin.Token {
+			errs = append(errs, fmt.Errorf("admin.token: needs admin.addr"))
+		}
+		if c.Admin.MaxAliases < 0 {
+			errs = append(errs, fmt.Errorf("admin.maxAliases: must not be negative"))
+		}
+	}
+	if nil != c.Tracing {
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			errs = append(errs, fmt.Errorf("tracing.sampleRatio: %v is not between 0 and 1", c.Tracing.SampleRatio))
+		}
+	}
+	return errors.Join(errs...)
 }
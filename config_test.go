@@ -0,0 +1,124 @@
+package echogy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func testPrivateKey(t *testing.T) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func testPublicKey(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(gossh.MarshalAuthorizedKey(key))
+}
+
+func TestHostSignerCertificate(t *testing.T) {
+	privateKey := testPrivateKey(t)
+	signer, err := gossh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := gossh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &gossh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        gossh.HostCert,
+		ValidPrincipals: []string{"webs.sh"},
+		ValidBefore:     gossh.CertTimeInfinity,
+	}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{PrivateKey: privateKey, HostCertificate: string(gossh.MarshalAuthorizedKey(cert))}
+	hostSigner, err := c.hostSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hostSigner.PublicKey().(*gossh.Certificate); !ok {
+		t.Errorf("hostSigner() public key is %T, want a certificate", hostSigner.PublicKey())
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	privateKey := testPrivateKey(t)
+	keyFile := filepath.Join(t.TempDir(), "host_key")
+	if err := os.WriteFile(keyFile, []byte(privateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	valid := func() *Config {
+		return &Config{HttpAddr: ":80", SSHAddr: ":2222", Domain: "webs.sh", PrivateKey: privateKey}
+	}
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"missing", func(c *Config) { *c = Config{} }, []string{"httpAddr: required", "SSHAddr: required", "domain: required"}},
+		{"bad port", func(c *Config) { c.HttpAddr = "localhost:http2" }, []string{"httpAddr:"}},
+		{"domain url", func(c *Config) { c.Domain = "https://webs.sh" }, []string{"domain:"}},
+		{"bad key", func(c *Config) { c.PrivateKey = "not a key" }, []string{"privateKey:"}},
+		{"key file", func(c *Config) { c.PrivateKey, c.PrivateKeyFile = "", keyFile }, nil},
+		{"key and file", func(c *Config) { c.PrivateKeyFile = keyFile }, []string{"privateKey: set either privateKey or privateKeyFile"}},
+		{"missing key file", func(c *Config) { c.PrivateKey, c.PrivateKeyFile = "", keyFile+".missing" }, []string{"privateKeyFile:"}},
+		{"bad certificate", func(c *Config) { c.HostCertificate = testPublicKey(t) }, []string{"hostCertificate: not an OpenSSH host certificate"}},
+		{"token without addr", func(c *Config) { c.Admin = &AdminConfig{Token: "secret"} }, []string{"admin.token: needs admin.addr"}},
+		{"sample ratio", func(c *Config) { c.Tracing = &TracingConfig{SampleRatio: 2} }, []string{"tracing.sampleRatio:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			err := c.Validate()
+			if nil == tt.want {
+				if nil != err {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if nil == err {
+				t.Fatalf("Validate() = nil, want %q", tt.want)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("Validate() = %q, want %d errors", lines, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("error %d = %q, want prefix %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package echogy
 
 import (
+	"bytes"
 	"fmt"
 	gossh "golang.org/x/crypto/ssh"
 	"io"
 	"net"
+	"net/http"
 	"time"
 )
 
@@ -96,6 +98,38 @@ func (b *bufferedReader) Bytes() []byte {
 	return append([]byte(nil), b.buffer...)
 }
 
+// setHeaderFields replaces the header fields of the request head at the start of buf with h,
+// it reports false when buf does not hold the whole head
+func setHeaderFields(buf []byte, h http.Header) ([]byte, bool) {
+	if len(h) == 0 {
+		return buf, true
+	}
+	end := bytes.Index(buf, []byte("\r\n\r\n"))
+	line := bytes.Index(buf, []byte("\r\n"))
+	if end < 0 || line < 0 {
+		return buf, false
+	}
+	head := make([]byte, 0, len(buf)+128)
+	head = append(head, buf[:line+2]...)
+	for k, values := range h {
+		for _, v := range values {
+			head = append(head, k+": "+v+"\r\n"...)
+		}
+	}
+	for _, field := range bytes.Split(buf[line+2:end], []byte("\r\n")) {
+		if len(field) == 0 {
+			continue
+		}
+		name, _, _ := bytes.Cut(field, []byte(":"))
+		if _, replaced := h[http.CanonicalHeaderKey(string(bytes.TrimSpace(name)))]; replaced {
+			continue
+		}
+		head = append(head, field...)
+		head = append(head, "\r\n"...)
+	}
+	return append(head, buf[end+2:]...), true
+}
+
 // Len returns the total length of buffered data
 func (b *bufferedReader) Len() int {
 	return len(b.buffer)
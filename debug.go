@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type fakeListen struct {
@@ -73,11 +74,14 @@ type Request struct {
 	Method  string            `json:"method"`
 	Uri     string            `json:"uri"`
 	Headers map[string]string `json:"headers"`
+	Time    string            `json:"time"`
+	Body    string            `json:"body,omitempty"`
 }
 
 type Response struct {
 	Status  int               `json:"status"`
 	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body,omitempty"`
 }
 
 type WrapHttpEntity struct {
@@ -85,15 +89,38 @@ type WrapHttpEntity struct {
 	Response *Response `json:"response"`
 	Request  *Request  `json:"request"`
 	UseTime  int64     `json:"useTime"`
+	TTFB     int64     `json:"ttfb"`
 }
 
 type Stats struct {
-	RequestBytes      int64 `json:"requestBytes"`
-	ResponseBytes     int64 `json:"responseBytes"`
-	Requests          int   `json:"requests"`
-	Responses         int   `json:"responses"`
-	ActiveConnections int   `json:"activeConnections"`
-	TotalConnections  int   `json:"totalConnections"`
+	RequestBytes      int64            `json:"requestBytes"`
+	ResponseBytes     int64            `json:"responseBytes"`
+	Requests          int64            `json:"requests"`
+	Responses         int64            `json:"responses"`
+	ActiveConnections int64            `json:"activeConnections"`
+	TotalConnections  int64            `json:"totalConnections"`
+	StatusClasses     map[string]int64 `json:"statusClasses"`
+	TTFB              *Latency         `json:"ttfb"`
+	Total             *Latency         `json:"total"`
+}
+
+// Latency summarises a latency histogram in milliseconds
+type Latency struct {
+	Count uint64 `json:"count"`
+	Max   int64  `json:"max"`
+	P50   int64  `json:"p50"`
+	P90   int64  `json:"p90"`
+	P99   int64  `json:"p99"`
+}
+
+func newLatency(h stat.HistogramSnapshot) *Latency {
+	return &Latency{
+		Count: h.Count,
+		Max:   h.Max,
+		P50:   h.P50,
+		P90:   h.P90,
+		P99:   h.P99,
+	}
 }
 
 type SyncEventMessage struct {
@@ -120,7 +147,11 @@ func writeEvent(w http.ResponseWriter, rc *http.ResponseController, msg *EventMe
 }
 
 func (f *debugServer) getStat() *Stats {
-	s := stat.GetStat(f.ctx)
+	return newStats(f.ctx)
+}
+
+func newStats(ctx ssh.Context) *Stats {
+	s := stat.GetStat(ctx).Snapshot()
 	return &Stats{
 		RequestBytes:      s.Receive,
 		ResponseBytes:     s.Send,
@@ -128,6 +159,15 @@ func (f *debugServer) getStat() *Stats {
 		Responses:         s.Response,
 		ActiveConnections: s.ConnCount,
 		TotalConnections:  s.TotalConn,
+		StatusClasses: map[string]int64{
+			"1xx": s.Status[0],
+			"2xx": s.Status[1],
+			"3xx": s.Status[2],
+			"4xx": s.Status[3],
+			"5xx": s.Status[4],
+		},
+		TTFB:  newLatency(s.TTFB),
+		Total: newLatency(s.Total),
 	}
 }
 
@@ -137,7 +177,7 @@ func (f *debugServer) eventHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	queue := stat.GetQueue(f.ctx)
+	history := stat.GetHistory(f.ctx)
 
 	sem := &SyncEventMessage{
 		Stats:        f.getStat(),
@@ -148,14 +188,9 @@ func (f *debugServer) eventHandler(w http.ResponseWriter, r *http.Request) {
 		sem.Tunnel = t
 	}
 
-	for i, item := range queue.Items() {
-		e := item.(*stat.RequestEntity)
-		entity := &WrapHttpEntity{
-			Id:       i + 1,
-			Request:  simpleRequest(e.Request),
-			Response: simpleResponse(e.Response),
-			UseTime:  e.UseTime,
-		}
+	for i, item := range history.Items() {
+		entity := wrapEntity(item)
+		entity.Id = i + 1
 		sem.HttpEntities = append(sem.HttpEntities, entity)
 	}
 
@@ -208,6 +243,14 @@ func simpleHeader(h http.Header) map[string]string {
 	return sh
 }
 
+// textBody returns the captured body when it can be shown as text
+func textBody(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return ""
+}
+
 func simpleRequest(r *http.Request) *Request {
 	h := simpleHeader(r.Header)
 	h["Host"] = r.Host
@@ -225,16 +268,26 @@ func simpleResponse(w *http.Response) *Response {
 	}
 }
 
-func (f *debugServer) UpdateEvent(w *http.Response, r *http.Request, t int64) {
+func wrapEntity(e *stat.RequestEntity) *WrapHttpEntity {
+	req := simpleRequest(e.Request)
+	req.Time = e.StartTime.Format(time.RFC3339Nano)
+	req.Body = textBody(e.RequestBody)
+	resp := simpleResponse(e.Response)
+	resp.Body = textBody(e.ResponseBody)
+	return &WrapHttpEntity{
+		Request:  req,
+		Response: resp,
+		UseTime:  e.UseTime,
+		TTFB:     e.TTFB,
+	}
+}
+
+func (f *debugServer) UpdateEvent(e *stat.RequestEntity) {
 	f.chEvent <- &EventMessage{
 		Name: "update",
 		Data: &UpdateEventMessage{
-			Stats: f.getStat(),
-			HttpEntity: &WrapHttpEntity{
-				Request:  simpleRequest(r),
-				Response: simpleResponse(w),
-				UseTime:  t,
-			},
+			Stats:      f.getStat(),
+			HttpEntity: wrapEntity(e),
 		},
 	}
 }
@@ -259,6 +312,7 @@ func getOrCreateDebugServer(conn *gossh.ServerConn, ctx ssh.Context) *debugServe
 		mux := http.NewServeMux()
 		mux.Handle("/", http.FileServer(http.FS(dist)))
 		mux.Handle("/events", http.HandlerFunc(svr.eventHandler))
+		mux.Handle("/har", http.HandlerFunc(svr.harHandler))
 
 		server := &http.Server{
 			Handler: mux,
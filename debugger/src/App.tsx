@@ -8,6 +8,7 @@ import { RequestList } from './components/RequestList';
 import { RequestDetail } from './components/RequestDetail';
 import { mockRequests, mockStats, mockTunnel } from './mock/data';
 import {Footer} from "./components/Footer.tsx";
+import { HarImport, parseHar } from './utils/har';
 
 const useMock = import.meta.env.VITE_USE_MOCK === 'true';
 
@@ -27,6 +28,7 @@ function App() {
         totalConnections: 0,
     });
     const [tunnel, setTunnel] = useState<Tunnel | undefined>(useMock ? mockTunnel : undefined);
+    const [imported, setImported] = useState<HarImport | null>(null);
     const [selectedRequest, setSelectedRequest] = useState<HttpEntity | null>(null);
     const [tab, setTab] = useState<'request' | 'response'>('request');
 
@@ -61,9 +63,25 @@ function App() {
         setIsDark(!isDark);
     };
 
+    const handleImportHar = (file: File) => {
+        file.text().then(text => {
+            setImported({name: file.name, requests: parseHar(text)});
+            setSelectedRequest(null);
+        }).catch(err => {
+            console.error(err);
+            alert(`Failed to import ${file.name}: ${err.message}`);
+        });
+    };
+
+    // live requests keep arriving while an archive is open and show again once it is closed
+    const handleCloseHar = () => {
+        setImported(null);
+        setSelectedRequest(null);
+    };
+
     return (
         <div className="h-screen flex flex-col bg-echogy-bg-primary dark:bg-echogy-bg-primary-dark text-echogy-text-primary dark:text-echogy-text-primary-dark">
-            <Header isDark={isDark} onToggleTheme={handleToggleTheme} />
+            <Header isDark={isDark} onToggleTheme={handleToggleTheme} onImportHar={handleImportHar} />
 
             <main className="flex-1 flex flex-col min-h-0 px-4">
                 {/* Tunnel URLs and Stats */}
@@ -80,7 +98,9 @@ function App() {
                 <div className="flex-1 flex gap-4 min-h-0 pb-4">
                     <div className="flex-1 min-h-0">
                         <RequestList
-                            requests={requests}
+                            requests={imported ? imported.requests : requests}
+                            source={imported?.name}
+                            onCloseSource={handleCloseHar}
                             selectedRequest={selectedRequest}
                             onSelectRequest={setSelectedRequest}
                         />
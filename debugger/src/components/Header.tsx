@@ -3,9 +3,12 @@ import React from 'react';
 interface HeaderProps {
     isDark: boolean;
     onToggleTheme: () => void;
+    onImportHar: (file: File) => void;
 }
 
-export const Header: React.FC<HeaderProps> = ({ isDark, onToggleTheme }) => {
+export const Header: React.FC<HeaderProps> = ({ isDark, onToggleTheme, onImportHar }) => {
+    const fileInput = React.useRef<HTMLInputElement>(null);
+
     return (
         <header className="bg-echogy-bg-primary dark:bg-echogy-bg-primary-dark border-b border-echogy-border dark:border-echogy-border-dark">
             <div className="px-6 py-4 flex justify-between items-center">
@@ -15,24 +18,52 @@ export const Header: React.FC<HeaderProps> = ({ isDark, onToggleTheme }) => {
                     </h1>
                     <span className="text-echogy-text-secondary dark:text-echogy-text-secondary-dark">Web Debugger</span>
                 </div>
-                <button
-                    onClick={onToggleTheme}
-                    className="p-2 rounded-full hover:bg-echogy-bg-secondary/50 transition-colors"
-                >
-                    {isDark ? (
-                        <svg className="w-6 h-6 text-echogy-text-primary dark:text-echogy-text-primary-dark"
-                             fill="none" stroke="currentColor" viewBox="0 0 24 24">
-                            <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2}
-                                  d="M12 3v1m0 16v1m9-9h-1M4 12H3m15.364 6.364l-.707-.707M6.343 6.343l-.707-.707m12.728 0l-.707.707M6.343 17.657l-.707.707M16 12a4 4 0 11-8 0 4 4 0 018 0z"/>
-                        </svg>
-                    ) : (
-                        <svg className="w-6 h-6 text-echogy-text-primary dark:text-echogy-text-primary-dark"
-                             fill="none" stroke="currentColor" viewBox="0 0 24 24">
-                            <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2}
-                                  d="M20.354 15.354A9 9 0 018.646 3.646 9.003 9.003 0 0012 21a9.003 9.003 0 008.354-5.646z"/>
-                        </svg>
-                    )}
-                </button>
+                <div className="flex items-center space-x-2">
+                    <a
+                        href="/har"
+                        download
+                        className="px-3 py-1.5 text-sm rounded-md text-echogy-text-secondary dark:text-echogy-text-secondary-dark hover:bg-echogy-bg-secondary/50 transition-colors"
+                    >
+                        Export HAR
+                    </a>
+                    <button
+                        onClick={() => fileInput.current?.click()}
+                        className="px-3 py-1.5 text-sm rounded-md text-echogy-text-secondary dark:text-echogy-text-secondary-dark hover:bg-echogy-bg-secondary/50 transition-colors"
+                    >
+                        Import HAR
+                    </button>
+                    <input
+                        ref={fileInput}
+                        type="file"
+                        accept=".har,application/json"
+                        className="hidden"
+                        onChange={e => {
+                            const file = e.target.files?.[0];
+                            if (file) {
+                                onImportHar(file);
+                            }
+                            e.target.value = '';
+                        }}
+                    />
+                    <button
+                        onClick={onToggleTheme}
+                        className="p-2 rounded-full hover:bg-echogy-bg-secondary/50 transition-colors"
+                    >
+                        {isDark ? (
+                            <svg className="w-6 h-6 text-echogy-text-primary dark:text-echogy-text-primary-dark"
+                                 fill="none" stroke="currentColor" viewBox="0 0 24 24">
+                                <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2}
+                                      d="M12 3v1m0 16v1m9-9h-1M4 12H3m15.364 6.364l-.707-.707M6.343 6.343l-.707-.707m12.728 0l-.707.707M6.343 17.657l-.707.707M16 12a4 4 0 11-8 0 4 4 0 018 0z"/>
+                            </svg>
+                        ) : (
+                            <svg className="w-6 h-6 text-echogy-text-primary dark:text-echogy-text-primary-dark"
+                                 fill="none" stroke="currentColor" viewBox="0 0 24 24">
+                                <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2}
+                                      d="M20.354 15.354A9 9 0 018.646 3.646 9.003 9.003 0 0012 21a9.003 9.003 0 008.354-5.646z"/>
+                            </svg>
+                        )}
+                    </button>
+                </div>
             </div>
         </header>
     );
@@ -44,16 +44,23 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request, tab, onTa
                         <div className="font-mono text-sm space-y-2">
                             {tab === 'request' ? (
                                 /* Request Headers */
-                                Object.entries(request.request.headers).map(([key, value]) => (
-                                    <div key={key} className="flex">
-                                        <span className="text-echogy-text-secondary dark:text-echogy-text-secondary-dark w-32">
-                                            {key}:
-                                        </span>
-                                        <span className="text-echogy-text-primary dark:text-echogy-text-primary-dark flex-1">
-                                            {value}
-                                        </span>
-                                    </div>
-                                ))
+                                <>
+                                    {Object.entries(request.request.headers).map(([key, value]) => (
+                                        <div key={key} className="flex">
+                                            <span className="text-echogy-text-secondary dark:text-echogy-text-secondary-dark w-32">
+                                                {key}:
+                                            </span>
+                                            <span className="text-echogy-text-primary dark:text-echogy-text-primary-dark flex-1">
+                                                {value}
+                                            </span>
+                                        </div>
+                                    ))}
+                                    {request.request.body && (
+                                        <pre className="mt-4 whitespace-pre-wrap break-all text-echogy-text-primary dark:text-echogy-text-primary-dark">
+                                            {request.request.body}
+                                        </pre>
+                                    )}
+                                </>
                             ) : (
                                 /* Response Headers */
                                 <>
@@ -81,6 +88,11 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request, tab, onTa
                                             </span>
                                         </div>
                                     ))}
+                                    {request.response.body && (
+                                        <pre className="mt-4 whitespace-pre-wrap break-all text-echogy-text-primary dark:text-echogy-text-primary-dark">
+                                            {request.response.body}
+                                        </pre>
+                                    )}
                                 </>
                             )}
                         </div>
@@ -4,11 +4,14 @@ import { formatTime } from '../utils/format';
 
 interface RequestListProps {
     requests: HttpEntity[];
+    // source names the imported archive shown instead of the live requests
+    source?: string;
+    onCloseSource?: () => void;
     selectedRequest: HttpEntity | null;
     onSelectRequest: (request: HttpEntity) => void;
 }
 
-export const RequestList: React.FC<RequestListProps> = ({ requests, selectedRequest, onSelectRequest }) => {
+export const RequestList: React.FC<RequestListProps> = ({ requests, source, onCloseSource, selectedRequest, onSelectRequest }) => {
     return (
         <div className="h-full flex flex-col bg-echogy-bg-secondary dark:bg-echogy-bg-secondary-dark rounded-lg border border-echogy-border dark:border-echogy-border-dark">
             {/* Header */}
@@ -29,6 +32,17 @@ export const RequestList: React.FC<RequestListProps> = ({ requests, selectedRequ
                         </svg>
                     </button>
                 </div>
+                {source && (
+                    <div className="flex items-center space-x-2 text-sm text-echogy-text-secondary dark:text-echogy-text-secondary-dark">
+                        <span className="font-mono truncate max-w-[240px]" title={source}>{source}</span>
+                        <button
+                            onClick={onCloseSource}
+                            className="px-2 py-1 rounded hover:bg-echogy-bg-hover dark:hover:bg-echogy-bg-hover-dark transition-colors"
+                        >
+                            Back to live
+                        </button>
+                    </div>
+                )}
             </div>
             
             {/* Table Container */}
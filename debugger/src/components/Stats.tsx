@@ -1,10 +1,23 @@
 import React from 'react';
-import { Stats as StatsType } from '../types';
+import { Latency, Stats as StatsType } from '../types';
+import { formatTime } from '../utils/format';
 
 interface StatsProps {
     stats: StatsType;
 }
 
+const LatencyRow: React.FC<{ label: string; latency?: Latency }> = ({ label, latency }) => (
+    <div className="flex items-center space-x-3 text-sm">
+        <span className="w-12 text-echogy-text-secondary dark:text-echogy-text-secondary-dark">{label}</span>
+        {['p50', 'p90', 'p99'].map(p => (
+            <span key={p} className="text-echogy-text-primary dark:text-echogy-text-primary-dark">
+                <span className="text-echogy-text-secondary dark:text-echogy-text-secondary-dark">{p} </span>
+                {formatTime(latency ? latency[p as 'p50' | 'p90' | 'p99'] : 0)}
+            </span>
+        ))}
+    </div>
+);
+
 export const Stats: React.FC<StatsProps> = ({ stats }) => {
     return (
         <div className="h-full flex flex-col">
@@ -105,6 +118,20 @@ export const Stats: React.FC<StatsProps> = ({ stats }) => {
                     </div>
 
                 </div>
+
+                <div className="mt-4 space-y-1">
+                    <LatencyRow label="TTFB" latency={stats.ttfb}/>
+                    <LatencyRow label="Total" latency={stats.total}/>
+                    <div className="flex items-center space-x-3 text-sm">
+                        <span className="w-12 text-echogy-text-secondary dark:text-echogy-text-secondary-dark">Status</span>
+                        {['2xx', '3xx', '4xx', '5xx'].map(c => (
+                            <span key={c} className="text-echogy-text-primary dark:text-echogy-text-primary-dark">
+                                <span className="text-echogy-text-secondary dark:text-echogy-text-secondary-dark">{c} </span>
+                                {stats.statusClasses?.[c] ?? 0}
+                            </span>
+                        ))}
+                    </div>
+                </div>
             </div>
         </div>
     );
@@ -2,6 +2,14 @@ export interface Tunnel {
     href: string;
 }
 
+export interface Latency {
+    count: number;
+    max: number;
+    p50: number;
+    p90: number;
+    p99: number;
+}
+
 export interface Stats {
     requests: number;
     responses: number;
@@ -9,6 +17,9 @@ export interface Stats {
     responseBytes: number;
     activeConnections: number;
     totalConnections: number;
+    statusClasses?: Record<string, number>;
+    ttfb?: Latency;
+    total?: Latency;
 }
 
 export interface HttpRequest {
@@ -16,11 +27,13 @@ export interface HttpRequest {
     uri: string;
     headers: Record<string, string>;
     time: string;
+    body?: string;
 }
 
 export interface HttpResponse {
     status: number;
     headers: Record<string, string>;
+    body?: string;
 }
 
 export interface HttpEntity {
@@ -28,4 +41,5 @@ export interface HttpEntity {
     request: HttpRequest;
     response: HttpResponse;
     useTime: number;
+    ttfb?: number;
 }
@@ -0,0 +1,88 @@
+import { HttpEntity } from '../types';
+
+interface HarNameValue {
+    name: string;
+    value: string;
+}
+
+interface HarEntry {
+    startedDateTime: string;
+    time: number;
+    request: {
+        method: string;
+        url: string;
+        headers: HarNameValue[];
+        postData?: { text?: string };
+    };
+    response: {
+        status: number;
+        headers: HarNameValue[];
+        content?: { text?: string; encoding?: string };
+    };
+}
+
+interface Har {
+    log: {
+        version: string;
+        entries: HarEntry[];
+    };
+}
+
+const toHeaders = (headers: HarNameValue[] = []): Record<string, string> => {
+    const h: Record<string, string> = {};
+    headers.forEach(({name, value}) => {
+        if (!(name in h)) {
+            h[name] = value;
+        }
+    });
+    return h;
+};
+
+const toUri = (url: string): string => {
+    try {
+        const u = new URL(url);
+        return u.pathname + u.search;
+    } catch {
+        return url;
+    }
+};
+
+const toBody = (content?: { text?: string; encoding?: string }): string | undefined => {
+    if (!content?.text) {
+        return undefined;
+    }
+    if (content.encoding === 'base64') {
+        return undefined;
+    }
+    return content.text;
+};
+
+// HarImport is an archive opened for offline viewing, kept apart from the live requests
+export interface HarImport {
+    name: string;
+    requests: HttpEntity[];
+}
+
+// parseHar converts an HTTP Archive (HAR 1.2) into entities for offline viewing, newest first
+export const parseHar = (text: string): HttpEntity[] => {
+    const har = JSON.parse(text) as Har;
+    if (!har?.log?.version || !Array.isArray(har.log.entries)) {
+        throw new Error('not a HAR file');
+    }
+    return har.log.entries.map((entry, index) => ({
+        id: `har-${index + 1}`,
+        request: {
+            method: entry.request.method,
+            uri: toUri(entry.request.url),
+            headers: toHeaders(entry.request.headers),
+            time: entry.startedDateTime,
+            body: entry.request.postData?.text,
+        },
+        response: {
+            status: entry.response.status,
+            headers: toHeaders(entry.response.headers),
+            body: toBody(entry.response.content),
+        },
+        useTime: Math.round(entry.time),
+    })).reverse();
+};
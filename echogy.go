@@ -2,16 +2,34 @@ package echogy
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/echogy-io/echogy/pkg/auth"
 	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/echogy-io/echogy/pkg/tracing"
+	"github.com/echogy-io/echogy/pkg/upgrade"
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
+	"net"
 	"sync"
+	"time"
 )
 
+// Version is reported to clients and in exported archives, set at build time
+var Version = "dev"
+
+// sessionHub maps each accessId to its *forwarder, or to a *pendingTunnel while the
+// forwarder is created
 var sessionHub *sync.Map
 
+// pendingTunnel claims an accessId for the connection of ctx
+type pendingTunnel struct {
+	ctx ssh.Context
+}
+
 func init() {
 	sessionHub = &sync.Map{}
 }
@@ -25,8 +43,16 @@ const (
 	sshTunnelAddrKey                 = "sshTunnelAddrKey"
 	sshDebugServer                   = "sshDebugServer"
 	sshRemoteForward                 = "sshRemoteForward"
+	sshTunnelOwner                   = "sshTunnelOwner"
+	sshTunnelView                    = "sshTunnelView"
+	sshHeaderRules                   = "sshHeaderRules"
+	sshProxyProtocol                 = "sshProxyProtocol"
 	clientPublicKeyFingerprintSha256 = "clientPublicKeyFingerprint"
 	clientHttpAlias                  = "clientHttpAlias"
+	sshConnStart                     = "sshConnStart"
+	sshIdleConn                      = "sshIdleConn"
+	clientAdmin                      = "clientAdmin"
+	adminUser                        = "admin"
 	debugPort                        = 4300
 )
 
@@ -39,7 +65,7 @@ type remoteForwardRequest struct {
 	BindPort uint32
 }
 
-func requestHandler(bindPort uint32) func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (bool, []byte) {
+func requestHandler(domain string, authenticator auth.Auth, bindPort uint32) func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (bool, []byte) {
 	return func(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (bool, []byte) {
 		switch req.Type {
 		case sshSessionTypeForward:
@@ -58,12 +84,18 @@ func requestHandler(bindPort uint32) func(ctx ssh.Context, _ *ssh.Server, req *g
 			if reqPayload.BindPort == 0 {
 				reqPayload.BindPort = bindPort
 			}
+			if nil == ctx.Value(sshRemoteForward) {
+				go headlessTunnel(ctx, domain, authenticator)
+			}
 			ctx.SetValue(sshRemoteForward, &reqPayload)
 			return true, gossh.Marshal(&remoteForwardSuccess{bindPort})
 
 		case sshSessionTypeCancelForward:
-			id := ctx.Value(sshAccessIdKey).(string)
-			sessionHub.Delete(id)
+			if id, ok := ctx.Value(sshAccessIdKey).(string); ok {
+				if fwd, found := lookupForwarder(id); found && fwd.sshCtx == ctx {
+					sessionHub.CompareAndDelete(id, fwd)
+				}
+			}
 			return true, nil
 		default:
 			return false, nil
@@ -71,68 +103,157 @@ func requestHandler(bindPort uint32) func(ctx ssh.Context, _ *ssh.Server, req *g
 	}
 }
 
-func isRegister(ctx ssh.Context) bool {
-	a := ctx.Value(clientHttpAlias)
-	return "register" == ctx.User() && nil == a
+const (
+	tunnelBySession  = "session"
+	tunnelByHeadless = "headless"
+)
+
+// claimTunnel decides who serves the tunnel of a connection, the first of a session and
+// a forward request. A headless tunnel keeps shared for the sessions that come later.
+func claimTunnel(ctx ssh.Context, by string, shared *sharedView) bool {
+	ctx.Lock()
+	defer ctx.Unlock()
+	if owner, _ := ctx.Value(sshTunnelOwner).(string); "" != owner {
+		return false
+	}
+	ctx.SetValue(sshTunnelOwner, by)
+	if nil != shared {
+		ctx.SetValue(sshTunnelView, shared)
+	}
+	return true
 }
 
-func newSessionServer(sshAddr string, facadeDomain string, sshKey []byte, bindPort uint32, auth auth.Auth) *ssh.Server {
-	key, _ := gossh.ParseRawPrivateKey(sshKey)
-	signer, _ := gossh.NewSignerFromKey(key)
+// headlessTunnel serves the tunnel of a forward request that came before any session,
+// such as for ssh -N. A session opened later shows the tunnel, without one the connection
+// is closed when the tunnel ends.
+func headlessTunnel(ctx ssh.Context, domain string, authenticator auth.Auth) {
+	shared := newSharedView()
+	if !claimTunnel(ctx, tunnelByHeadless, shared) {
+		return
+	}
+	logger.Info("serving tunnel without session", map[string]interface{}{
+		"module":     "serve",
+		"remoteAddr": ctx.RemoteAddr().String(),
+	})
+	notice := func(event, message string) {
+		logger.Info("headless tunnel notice", map[string]interface{}{
+			"module":     "serve",
+			"remoteAddr": ctx.RemoteAddr().String(),
+			"event":      event,
+			"message":    message,
+		})
+		shared.notice(event, message)
+	}
+	runTunnel(ctx, domain, authenticator, notice, shared.newView(silentView(ctx)))
+	if shared.end() {
+		return
+	}
+	if conn, ok := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn); ok {
+		conn.Close()
+	}
+}
 
-	reqFunc := requestHandler(bindPort)
+// observeHandshake records the handshake duration once, on the first request or channel of a connection
+func observeHandshake(ctx ssh.Context) {
+	ctx.Lock()
+	defer ctx.Unlock()
+	start, ok := ctx.Value(sshConnStart).(time.Time)
+	if !ok {
+		return
+	}
+	ctx.SetValue(sshConnStart, nil)
+	metrics.HandshakeDuration.Observe(time.Since(start).Seconds())
+}
+
+// sshGuard checks authenticated connections on their first request or channel
+type sshGuard struct {
+	keys   *hostKeys
+	limits *sshLimits
+}
+
+// established admits an authenticated connection, closing it when its key has too many sessions
+func (g *sshGuard) established(ctx ssh.Context) bool {
+	observeHandshake(ctx)
+	if !admitSession(g.limits.sessionLimiter(), ctx) {
+		if conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn); ok {
+			conn.Close()
+		}
+		return false
+	}
+	g.keys.announceTo(ctx)
+	return true
+}
+
+func (g *sshGuard) channel(handler ssh.ChannelHandler) ssh.ChannelHandler {
+	return func(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+		if !g.established(ctx) {
+			newChan.Reject(gossh.ResourceShortage, "too many sessions")
+			return
+		}
+		handler(srv, conn, newChan, ctx)
+	}
+}
+
+func (g *sshGuard) request(handler ssh.RequestHandler) ssh.RequestHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		if !g.established(ctx) {
+			return false, nil
+		}
+		return handler(ctx, srv, req)
+	}
+}
+
+func isAdmin(ctx ssh.Context) bool {
+	admin, _ := ctx.Value(clientAdmin).(bool)
+	return admin
+}
+
+func newSessionServer(sshAddr string, facadeDomain string, keys *hostKeys, bindPort uint32, authenticator auth.Auth, limits *sshLimits) *ssh.Server {
+
+	guard := &sshGuard{keys: keys, limits: limits}
+	reqFunc := guard.request(requestHandler(facadeDomain, authenticator, bindPort))
 
 	return &ssh.Server{
-		//IdleTimeout: 300 * time.Second,
 		Version:     "Echogy",
-		HostSigners: []ssh.Signer{signer},
+		HostSigners: keys.handshake(),
 		Addr:        sshAddr,
+		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+			return limits.current().serverConfig()
+		},
 		PtyCallback: func(ctx ssh.Context, pty ssh.Pty) bool {
 			return true
 		},
-		Handler: sessionHandler(facadeDomain),
-		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
-			sha256 := fingerprintSHA256(key)
-			if nil != auth {
-				alias, found := auth.PubKey(key)
-				if found {
-					ctx.SetValue(clientPublicKeyFingerprintSha256, sha256)
-					ctx.SetValue(clientHttpAlias, alias)
-					return true
-				}
-			}
-			if isRegister(ctx) {
-				ctx.SetValue(clientPublicKeyFingerprintSha256, sha256)
-				return true
+		Handler: sessionHandler(facadeDomain, authenticator),
+		ConnCallback: func(ctx ssh.Context, conn net.Conn) net.Conn {
+			if !admitHandshake(limits.handshakeLimiter(), conn) || refuseBanned(conn) {
+				return nil
 			}
-			return false
+			watchPublicKeyFailure(ctx)
+			ctx.SetValue(sshConnStart, time.Now())
+			// the idle timeout of the live policy, reloads change it for open connections
+			idle := newIdleConn(conn, limits)
+			ctx.SetValue(sshIdleConn, idle)
+			return idle
+		},
+		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return publicKeyLogin(ctx, key, authenticator)
 		},
 		KeyboardInteractiveHandler: func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
-			user := ctx.User()
-			answers, err := challenger("", "", []string{"Login to Echogy.io\nEnter Password: "}, []bool{false})
-			if !isRegister(ctx) && nil != err {
-				return false
-			}
-			if "" != answers[0] {
-				alias, found := auth.Password(user, answers[0])
-				if found {
-					ctx.SetValue(clientHttpAlias, alias)
-				}
-			}
-			return true
+			return passwordLogin(ctx, challenger, authenticator)
 		},
 		ChannelHandlers: map[string]ssh.ChannelHandler{
-			sshRequestTypeDirectTcpip: DirectTCPIPHandler,
-			sshRequestTypeSession:     ssh.DefaultSessionHandler,
+			sshRequestTypeDirectTcpip: guard.channel(DirectTCPIPHandler),
+			sshRequestTypeSession:     guard.channel(ssh.DefaultSessionHandler),
 		},
 		RequestHandlers: map[string]ssh.RequestHandler{
 			sshSessionTypeForward:       reqFunc,
 			sshSessionTypeCancelForward: reqFunc,
+			hostKeysProveRequest:        keys.proveHandler,
 		},
 	}
 }
 
-func sessionHandler(domain string) func(ssh.Session) {
+func sessionHandler(domain string, authenticator auth.Auth) func(ssh.Session) {
 	return func(session ssh.Session) {
 		defer func() {
 			session.Close()
@@ -140,56 +261,156 @@ func sessionHandler(domain string) func(ssh.Session) {
 
 		ctx := session.Context()
 
-		var accessId string
-		var err error
-		var bound bool
+		if cmd := session.Command(); !isTunnelCommand(cmd) {
+			session.Exit(runCommand(session, cmd))
+			return
+		}
+
+		if isAdmin(ctx) && adminUser == ctx.User() {
+			adminSession(session)
+			return
+		}
 
-		if accessId, bound = ctx.Value(clientHttpAlias).(string); !bound {
-			accessId, err = withAddrGenerateAccessId(session.RemoteAddr())
+		options, err := parseTunnelOptions(session.Command(), session.Stderr())
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				session.Exit(exitOK)
+			} else {
+				session.Exit(exitUsage)
+			}
+			return
+		}
+		notice := sessionNotice(session, options.json)
+		if claimTunnel(ctx, tunnelBySession, nil) {
+			setTunnelOptions(ctx, options)
+			runTunnel(ctx, domain, authenticator, notice, sessionView(session, options.json))
+			return
+		}
+		// the forward request started the tunnel, the session shows it
+		shared, ok := ctx.Value(sshTunnelView).(*sharedView)
+		if ok {
+			setTunnelOptions(ctx, options)
+			ok = shared.show(ctx, sessionView(session, options.json), notice)
 		}
+		if !ok {
+			notice(eventError, "The tunnel of this connection is already running.")
+		}
+	}
+}
+
+// setTunnelOptions keeps the options of a session for the conns forwarded from now on
+func setTunnelOptions(ctx ssh.Context, options *tunnelOptions) {
+	ctx.SetValue(sshHeaderRules, options.rules)
+	ctx.SetValue(sshProxyProtocol, options.proxyProtocol)
+}
 
-	regenerating:
+// runTunnel serves the tunnel of a connection until the client leaves, notice tells
+// the client why its tunnel differs from the requested one or cannot start
+func runTunnel(ctx ssh.Context, domain string, authenticator auth.Auth, notice noticeFunc, newView newViewFunc) {
+	// the domain may change on reload, running tunnels keep theirs
+	tunnelDomain := domain
+	if c := liveConfig.Load(); nil != c {
+		tunnelDomain = c.Domain
+	}
+
+	alias, _ := ctx.Value(clientHttpAlias).(string)
+	fingerprint, _ := ctx.Value(clientPublicKeyFingerprintSha256).(string)
+
+	var accessId string
+	var err error
+
+	requested := requestedSubdomain(ctx, tunnelDomain)
+	refused := ""
+	if "" != requested {
+		refused = subdomainRefused(requested, alias, fingerprint, authenticator)
+	}
+
+	switch {
+	case "" != requested && "" == refused:
+		accessId = requested
+	case "" != alias:
+		accessId = alias
+	case "" != fingerprint:
+		// keys without an alias keep their subdomain across connections
+		accessId, err = keySubdomains.For(fingerprint)
+	default:
+		accessId, err = generateRandomString(subdomainLength, AlphaNum)
+	}
+
+	// the claim holds accessId in sessionHub until the forwarder replaces it, so two
+	// clients never get the same subdomain
+	claim := &pendingTunnel{ctx: ctx}
+	for {
 		if nil != err {
 			logger.Error("generating accessId", err, map[string]interface{}{
 				"module": "serve",
 			})
-			session.Write([]byte("generating accessId error"))
+			notice(eventError, "generating accessId error")
 			return
 		}
-
-		if _, found := sessionHub.Load(accessId); found {
-			accessId, err = generateAccessId()
-			goto regenerating
+		if reservations.Allowed(accessId, alias, fingerprint) {
+			if _, taken := sessionHub.LoadOrStore(accessId, claim); !taken {
+				break
+			}
 		}
+		accessId, err = generateAccessId()
+	}
 
-		tunnel := fmt.Sprintf("%s.%s", accessId, domain)
+	if "" != refused {
+		notice(eventWarning, fmt.Sprintf("Subdomain %s %s, using %s instead.", requested, refused, accessId))
+	}
 
-		ctx.SetValue(sshTunnelAddrKey, tunnel)
+	tunnel := fmt.Sprintf("%s.%s", accessId, tunnelDomain)
 
-		channel, err := newForwarder(accessId, domain, session)
+	history := stat.GetHistory(ctx)
+	defer history.Close()
 
-		if nil != err {
-			logger.Error("create dispatchRemoteForward", err, map[string]interface{}{
-				"module":     "serve",
-				"remoteAddr": session.RemoteAddr().String(),
+	// only configured aliases are stable enough to persist history for
+	if alias == accessId {
+		if err := stat.Restore(ctx, accessId); err != nil {
+			logger.Error("restore history", err, map[string]interface{}{
+				"module":   "serve",
+				"accessId": accessId,
 			})
-			return
 		}
-		ctx.SetValue(sshAccessIdKey, accessId)
-		sessionHub.Store(accessId, channel)
-		logger.Debug("establishing ssh conn", map[string]interface{}{
-			"module":   "serve",
-			"accessId": accessId,
-		})
-		channel.serve() // blocked with loop
-		sessionHub.Delete(accessId)
-		channel.Close()
+	}
+
+	ctx.SetValue(sshTunnelAddrKey, tunnel)
+
+	limits := tunnelLimits(ctx)
+	release, admitted := admitTunnel(limits, remoteIP(ctx.RemoteAddr()))
+	if !admitted {
+		sessionHub.CompareAndDelete(accessId, claim)
+		notice(eventError, fmt.Sprintf("Too many anonymous tunnels from your address, at most %d at once.", limits.MaxTunnelsPerIP))
+		return
+	}
+	defer release()
+
+	channel, err := newForwarder(accessId, tunnelDomain, ctx, limits, newView)
 
-		logger.Debug("clean ssh conn", map[string]interface{}{
-			"module":   "serve",
-			"accessId": accessId,
+	if nil != err {
+		sessionHub.CompareAndDelete(accessId, claim)
+		logger.Error("create dispatchRemoteForward", err, map[string]interface{}{
+			"module":     "serve",
+			"remoteAddr": ctx.RemoteAddr().String(),
 		})
+		return
 	}
+	ctx.SetValue(sshAccessIdKey, accessId)
+	sessionHub.CompareAndSwap(accessId, claim, channel)
+	logger.Debug("establishing ssh conn", map[string]interface{}{
+		"module":   "serve",
+		"accessId": accessId,
+	})
+	channel.serve() // blocked with loop
+	// a cancelled forward may have freed accessId for another client already
+	sessionHub.CompareAndDelete(accessId, channel)
+	channel.Close()
+
+	logger.Debug("clean ssh conn", map[string]interface{}{
+		"module":   "serve",
+		"accessId": accessId,
+	})
 }
 
 func Serve(ctx context.Context, config *Config, auth auth.Auth) {
@@ -204,7 +425,124 @@ func Serve(ctx context.Context, config *Config, auth auth.Auth) {
 		return
 	}
 
-	server := newSessionServer(config.SSHAddr, config.Domain, []byte(config.PrivateKey), sshPort, auth)
+	liveConfig.Store(config)
+	applyConfig(config)
+	warnNoAccess(config)
+
+	if nil != config.Admin && "" != config.Admin.Addr {
+		if err := reservations.load(config.Admin.ReservationsFile); err != nil {
+			logger.Error("load alias reservations", err, map[string]interface{}{
+				"module": "serve",
+				"path":   config.Admin.ReservationsFile,
+			})
+		}
+		adminLn, err := upgrade.Listen("admin", "tcp", config.Admin.Addr)
+		if err != nil {
+			logger.Error("admin server failed", err, map[string]interface{}{
+				"module":  "admin",
+				"address": config.Admin.Addr,
+			})
+		} else {
+			go adminServe(ctx, adminLn, config.Admin, auth)
+		}
+	}
+
+	if nil != config.Tracing && "" != config.Tracing.Endpoint {
+		shutdownTracing, err := tracing.Setup(ctx, config.Tracing.options())
+		if err != nil {
+			logger.Error("setup tracing", err, map[string]interface{}{
+				"module":   "serve",
+				"endpoint": config.Tracing.Endpoint,
+			})
+		} else {
+			defer func() {
+				flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				shutdownTracing(flushCtx)
+			}()
+		}
+	}
+
+	signers, err := config.hostSigners(true)
+	if err != nil {
+		logger.Fatal("load host keys", err, map[string]interface{}{
+			"module": "serve",
+		})
+		return
+	}
+	keys := newHostKeys(signers)
+	if err = keys.restrict(config.SSH.hostKeyAlgorithms()); err != nil {
+		logger.Fatal("restrict host keys", err, map[string]interface{}{
+			"module": "serve",
+		})
+		return
+	}
+	for _, algorithm := range config.SSH.insecure() {
+		logger.Warn("insecure ssh algorithm enabled", map[string]interface{}{
+			"module":    "serve",
+			"algorithm": algorithm,
+		})
+	}
+	if err = keySubdomains.configure(config.Subdomains); err != nil {
+		logger.Fatal("load subdomains", err, map[string]interface{}{
+			"module": "serve",
+		})
+		return
+	}
+	if err = bans.configure(config.Ban); err != nil {
+		logger.Fatal("load bans", err, map[string]interface{}{
+			"module": "serve",
+		})
+		return
+	}
+	if nil != config.Ban {
+		if err = authLog.open(config.Ban.LogFile); err != nil {
+			logger.Fatal("open auth log", err, map[string]interface{}{
+				"module": "serve",
+				"path":   config.Ban.LogFile,
+			})
+			return
+		}
+	}
+	limits := newSSHLimits(config.SSH)
+	liveHostKeys.Store(keys)
+	liveSSHLimits.Store(limits)
+	server := newSessionServer(config.SSHAddr, config.Domain, keys, sshPort, auth, limits)
+
+	// listeners are inherited from the previous process after an upgrade
+	facadeLn, err := upgrade.Listen("facade", "tcp", config.HttpAddr)
+	if err != nil {
+		logger.Fatal("start Listen", err, map[string]interface{}{
+			"module":  "facade",
+			"address": config.HttpAddr,
+		})
+		return
+	}
+	sshLn, err := upgrade.Listen("ssh", "tcp", config.SSHAddr)
+	if err != nil {
+		logger.Fatal("start Listen", err, map[string]interface{}{
+			"module":  "serve",
+			"address": config.SSHAddr,
+		})
+		return
+	}
+	if err = upgrade.Ready(); err != nil {
+		logger.Error("write pid file", err, map[string]interface{}{
+			"module": "serve",
+		})
+	}
+
+	// tunnels unknown here may belong to the previous process, which drains after an upgrade
+	previous := upgrade.Previous()
+	forward := func(facadeId string, req *hijackHttp) bool {
+		if channel, found := lookupForwarder(facadeId); found {
+			channel.dispatchRemoteForward(req)
+			return true
+		}
+		return forwardPrevious(previous, req)
+	}
+	handover := &handoff{forward: forward}
+	upgrade.SetHandoff(handover.open)
 
 	wg.Add(1)
 	go func() {
@@ -213,14 +551,7 @@ func Serve(ctx context.Context, config *Config, auth auth.Auth) {
 			"module":  "serve",
 			"address": config.HttpAddr,
 		})
-		facadeServe(ctx, config.HttpAddr, func(facadeId string, req *hijackHttp) bool {
-			if value, found := sessionHub.Load(facadeId); found {
-				channel := value.(*forwarder)
-				channel.dispatchRemoteForward(req)
-				return true
-			}
-			return false
-		})
+		facadeServe(ctx, facadeLn, forward)
 	}()
 
 	wg.Wait()
@@ -232,7 +563,10 @@ func Serve(ctx context.Context, config *Config, auth auth.Auth) {
 			"module":  "serve",
 			"address": config.SSHAddr,
 		})
-		err := server.ListenAndServe()
+		err := server.Serve(sshLn)
+		if errors.Is(err, ssh.ErrServerClosed) {
+			return
+		}
 		logger.Fatal("ssh server shutdown", err, map[string]interface{}{
 			"module":  "serve",
 			"address": config.SSHAddr,
@@ -240,11 +574,12 @@ func Serve(ctx context.Context, config *Config, auth auth.Auth) {
 	}()
 	wg.Wait()
 	<-ctx.Done()
-	server.Shutdown(ctx)
-	sessionHub.Range(func(key, value interface{}) bool {
-		fwd := value.(*forwarder)
-		fwd.Close()
-		return true
-	})
+	if upgrade.Upgraded() {
+		// visitors reach the tunnels through the new process until their clients leave
+		drain(server, liveConfig.Load().upgradeDrainTimeout(), activeTunnels)
+	} else {
+		drain(server, liveConfig.Load().shutdownTimeout(), activeConns)
+	}
+	handover.close()
 	logger.WarnN("Echogy shutdown")
 }
@@ -5,6 +5,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/echogy-io/echogy/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"net"
 	"net/http"
 	"strings"
@@ -43,28 +48,45 @@ func handleConnection(c net.Conn, forward func(facadeId string, request *hijackH
 		logger.Warn("bad request", map[string]interface{}{
 			"module": "facade",
 		})
+		metrics.FacadeConnections.WithLabelValues("bad_request").Inc()
 		badRequest(c)
 		return
 	}
 
+	ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), req.Header), "facade.connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.Host),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("client.address", c.RemoteAddr().String()),
+		))
+
 	domainSep := strings.Split(req.Host, ".")
 	if len(domainSep) <= 1 {
+		span.SetStatus(codes.Error, "bad request")
+		span.End()
 		logger.Warn("bad request", map[string]interface{}{
 			"module": "facade",
 			"method": req.Method,
 			"url":    req.URL.String(),
 			"host":   req.Host,
 		})
+		metrics.FacadeConnections.WithLabelValues("bad_request").Inc()
 		badRequest(c)
 		return
 	}
 	id := domainSep[0]
+	span.SetAttributes(attribute.String("echogy.access_id", id))
 
+	// each request gets its own span, which the local service continues
 	conn := newHijackConn(reader.toBufferedConn(c))
+	conn.SetSpan(ctx, span)
 
 	canForward := forward(id, conn)
 
 	if canForward {
+		metrics.FacadeConnections.WithLabelValues("forwarded").Inc()
 		logger.Debug("found dispatchRemoteForward", map[string]interface{}{
 			"module":   "facade",
 			"method":   req.Method,
@@ -72,6 +94,9 @@ func handleConnection(c net.Conn, forward func(facadeId string, request *hijackH
 			"path":     req.URL.Path,
 		})
 	} else {
+		metrics.FacadeConnections.WithLabelValues("not_found").Inc()
+		span.SetStatus(codes.Error, "tunnel not found")
+		span.End()
 		notFound(id, c)
 		logger.Warn("not found dispatchRemoteForward", map[string]interface{}{
 			"module":   "facade",
@@ -82,36 +107,26 @@ func handleConnection(c net.Conn, forward func(facadeId string, request *hijackH
 	}
 }
 
-func facadeServe(ctx context.Context, addr string, forward func(facadeId string, request *hijackHttp) bool) {
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		logger.Fatal("start Listen", err, map[string]interface{}{
-			"module":  "facade",
-			"address": addr,
-		})
-		return
-	}
-	defer func() {
-		if nil != ln {
-			ln.Close()
-		}
+func facadeServe(ctx context.Context, ln net.Listener, forward func(facadeId string, request *hijackHttp) bool) {
+	addr := ln.Addr().String()
+	// closing the listener stops accepting, open connections keep being served
+	go func() {
+		<-ctx.Done()
+		ln.Close()
 	}()
 
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			c, err := ln.Accept()
-			if nil != err {
-				logger.Error("start Accept", err, map[string]interface{}{
-					"module":  "facade",
-					"address": addr,
-				})
-				c.Close()
-			} else {
-				go handleConnection(c, forward)
+		c, err := ln.Accept()
+		if nil != err {
+			if nil != ctx.Err() {
+				return
 			}
+			logger.Error("start Accept", err, map[string]interface{}{
+				"module":  "facade",
+				"address": addr,
+			})
+			continue
 		}
+		go handleConnection(c, forward)
 	}
 }
@@ -0,0 +1,119 @@
+package echogy
+
+import (
+	"bufio"
+	"github.com/echogy-io/echogy/pkg/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestSetHeaderFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   string
+		header http.Header
+		want   string
+	}{
+		{
+			name:   "adds header",
+			head:   "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\nbody",
+			header: http.Header{"Traceparent": {"00-1-2-01"}},
+			want:   "GET / HTTP/1.1\r\nTraceparent: 00-1-2-01\r\nHost: a.webs.sh\r\n\r\nbody",
+		},
+		{
+			name:   "replaces header case insensitively",
+			head:   "GET / HTTP/1.1\r\nHost: a.webs.sh\r\ntraceparent: 00-old\r\n\r\n",
+			header: http.Header{"Traceparent": {"00-new"}},
+			want:   "GET / HTTP/1.1\r\nTraceparent: 00-new\r\nHost: a.webs.sh\r\n\r\n",
+		},
+		{
+			name:   "empty header keeps head",
+			head:   "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n",
+			header: http.Header{},
+			want:   "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := setHeaderFields([]byte(tt.head), tt.header)
+			if !ok {
+				t.Fatalf("setHeaderFields() = false")
+			}
+			if string(got) != tt.want {
+				t.Errorf("head = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleConnectionTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.Install(tracing.NewProvider(tracing.Options{}, sdktrace.WithSyncer(exporter)))
+	defer tracing.Install(tracing.NewProvider(tracing.Options{}))
+
+	// requests of a keep-alive connection, each continuing its own trace
+	incoming := []struct {
+		traceId string
+		spanId  string
+	}{
+		{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"},
+	}
+	forwarded := make(chan string, len(incoming))
+	closed := make(chan struct{})
+
+	visitor, facade := net.Pipe()
+	go handleConnection(facade, func(id string, conn *hijackHttp) bool {
+		go func() {
+			defer close(closed)
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				forwarded <- req.Header.Get("Traceparent")
+				conn.Write([]byte("HTTP/1.1 204 No Content\r\n\r\n"))
+			}
+		}()
+		return true
+	})
+
+	responses := bufio.NewReader(visitor)
+	for _, in := range incoming {
+		visitor.Write([]byte("GET /ping HTTP/1.1\r\nHost: demo.webs.sh\r\nTraceparent: 00-" +
+			in.traceId + "-" + in.spanId + "-01\r\n\r\n"))
+		resp, err := http.ReadResponse(responses, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	visitor.Close()
+	<-closed
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range exporter.GetSpans().Snapshots() {
+		if "facade.request" == span.Name() {
+			spans[span.SpanContext().TraceID().String()] = span
+		}
+	}
+	for i, in := range incoming {
+		traceparent := <-forwarded
+		span, found := spans[in.traceId]
+		if !found {
+			t.Fatalf("request %d: no facade.request span in trace %s", i, in.traceId)
+		}
+		if span.Parent().SpanID().String() != in.spanId {
+			t.Errorf("request %d: parent = %v, want the incoming span", i, span.Parent().SpanID())
+		}
+		want := "00-" + in.traceId + "-" + span.SpanContext().SpanID().String() + "-01"
+		if traceparent != want {
+			t.Errorf("request %d: forwarded traceparent = %q, want %q", i, traceparent, want)
+		}
+	}
+}
@@ -2,15 +2,20 @@ package echogy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/echogy-io/echogy/pkg/limit"
 	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
 	"github.com/echogy-io/echogy/pkg/stat"
-	"github.com/echogy-io/echogy/pkg/tui"
+	"github.com/echogy-io/echogy/pkg/tracing"
 	"github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	gossh "golang.org/x/crypto/ssh"
 	"io"
 	"net"
-	"net/http"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -18,8 +23,9 @@ import (
 )
 
 type fwdConn struct {
-	ch   gossh.Channel
-	conn net.Conn
+	ch       gossh.Channel
+	conn     net.Conn
+	openedAt time.Time
 }
 
 func (f *fwdConn) Close() error {
@@ -35,29 +41,96 @@ func (f *fwdConn) Close() error {
 type forwarder struct {
 	context           context.Context
 	cancelFunc        context.CancelFunc
-	sess              ssh.Session
+	sshCtx            ssh.Context
 	accessId          string
-	pty               *tui.HttpReversProxyPty
+	view              tunnelView
 	remoteForwardChan chan net.Conn
 	chanCounter       atomic.Int64
 	chanMap           *sync.Map
+	chanSeq           atomic.Int64
+	startTime         time.Time
+	// expiresAt ends the tunnel, zero when it lives as long as the session
+	expiresAt   time.Time
+	idleTimeout time.Duration
+	// lastActive is the unix nano time a forwarded conn last opened or closed
+	lastActive atomic.Int64
+	bandwidth  *limit.Bandwidth
+	goodbye    sync.Once
+	warned     atomic.Bool
 }
 
-func newForwarder(accessId, domain string, session ssh.Session) (*forwarder, error) {
-	pty, err := tui.NewHttpReverseProxyPty(session, fmt.Sprintf("%s.%s", accessId, domain))
+const expiryWarningBefore = time.Minute
+
+// keepaliveInterval is how often a tunnel checks its client is still there
+var keepaliveInterval = 30 * time.Second
+
+func newForwarder(accessId, domain string, sshCtx ssh.Context, limits *TunnelLimits, newView newViewFunc) (*forwarder, error) {
+	start := time.Now()
+	expiresAt := limits.expiresAt(start)
+	view, err := newView(fmt.Sprintf("%s.%s", accessId, domain), expiresAt)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancelFunc := context.WithCancel(session.Context())
-	return &forwarder{
+	ctx, cancelFunc := context.WithCancel(sshCtx)
+	fwd := &forwarder{
 		context:           ctx,
 		cancelFunc:        cancelFunc,
 		accessId:          accessId,
-		pty:               pty,
-		sess:              session,
+		view:              view,
+		sshCtx:            sshCtx,
 		chanMap:           &sync.Map{},
 		remoteForwardChan: make(chan net.Conn, 4),
-	}, nil
+		startTime:         start,
+		expiresAt:         expiresAt,
+		idleTimeout:       limits.idleTimeout(),
+		bandwidth:         limits.bandwidth(),
+	}
+	fwd.touch()
+	return fwd, nil
+}
+
+// headerRules rewrite headers of requests and responses, nil pipes bytes unchanged.
+// A session showing a tunnel started by its forward request may set them later.
+func (fwd *forwarder) headerRules() *headerRules {
+	rules, _ := fwd.sshCtx.Value(sshHeaderRules).(*headerRules)
+	return rules
+}
+
+// proxyProtocol is the PROXY protocol version written ahead of each channel, 0 for none
+func (fwd *forwarder) proxyProtocol() int {
+	version, _ := fwd.sshCtx.Value(sshProxyProtocol).(int)
+	return version
+}
+
+func (fwd *forwarder) touch() {
+	fwd.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the tunnel has had no forwarded conn
+func (fwd *forwarder) idleFor() time.Duration {
+	if fwd.chanCounter.Load() > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, fwd.lastActive.Load()))
+}
+
+// checkExpiry returns why the tunnel ends now, empty while it may go on
+func (fwd *forwarder) checkExpiry(now time.Time) string {
+	if !fwd.expiresAt.IsZero() && !now.Before(fwd.expiresAt) {
+		return fmt.Sprintf("Anonymous tunnels last %s, this one has expired.", fwd.expiresAt.Sub(fwd.startTime))
+	}
+	if fwd.idleTimeout > 0 && fwd.idleFor() >= fwd.idleTimeout {
+		return fmt.Sprintf("Your tunnel was closed after %s without requests.", fwd.idleTimeout)
+	}
+	return ""
+}
+
+// expiryWarning returns a warning once when the tunnel expires within expiryWarningBefore
+func (fwd *forwarder) expiryWarning(now time.Time) string {
+	if fwd.expiresAt.IsZero() || fwd.expiresAt.Sub(now) > expiryWarningBefore || !fwd.warned.CompareAndSwap(false, true) {
+		return ""
+	}
+	return fmt.Sprintf("This tunnel expires in %s.", max(fwd.expiresAt.Sub(now).Round(time.Second), 0))
 }
 
 type remoteForwardChannelData struct {
@@ -68,18 +141,29 @@ type remoteForwardChannelData struct {
 }
 
 func (fwd *forwarder) dispatchRemoteForward(hijackConn *hijackHttp) {
-	hijackConn.SetDispatch(func(w *http.Response, r *http.Request, t int64) {
-		stat.Put(fwd.sess.Context(), w, r, t)
-		fwd.pty.Update()
-		if debug, ok := fwd.sess.Context().Value(sshDebugServer).(*debugServer); ok {
-			debug.UpdateEvent(w, r, t)
+	_, span := tracing.Tracer().Start(hijackConn.TraceContext(), "tunnel.dispatch",
+		trace.WithAttributes(attribute.String("echogy.access_id", fwd.accessId)))
+	defer span.End()
+	hijackConn.SetDispatch(func(e *stat.RequestEntity) {
+		trace.SpanFromContext(hijackConn.TraceContext()).AddEvent("response", trace.WithAttributes(
+			attribute.String("http.request.method", e.Request.Method),
+			attribute.String("url.path", e.Request.URL.Path),
+			attribute.Int("http.response.status_code", e.Response.StatusCode),
+			attribute.Int64("echogy.ttfb_ms", e.TTFB),
+			attribute.Int64("echogy.duration_ms", e.UseTime),
+		))
+		stat.Put(fwd.sshCtx, e)
+		metrics.Requests.WithLabelValues(metrics.Alias(fwd.accessId), metrics.StatusClass(e.Response.StatusCode)).Inc()
+		fwd.view.Request(e)
+		if debug, ok := fwd.sshCtx.Value(sshDebugServer).(*debugServer); ok {
+			debug.UpdateEvent(e)
 		}
 	})
 	fwd.remoteForwardChan <- hijackConn
 }
 
 func (fwd *forwarder) serve() {
-	remoteAddr := fwd.sess.RemoteAddr().String()
+	remoteAddr := fwd.sshCtx.RemoteAddr().String()
 	logger.Info("created dispatchRemoteForward conn", map[string]interface{}{
 		"module":     "conn",
 		"accessId":   fwd.accessId,
@@ -87,9 +171,9 @@ func (fwd *forwarder) serve() {
 	})
 
 	go func() {
-		err := fwd.pty.Start()
+		err := fwd.view.Start()
 		if err != nil {
-			logger.Error("start pty conn", err, map[string]interface{}{
+			logger.Error("start tunnel view", err, map[string]interface{}{
 				"module":     "conn",
 				"accessId":   fwd.accessId,
 				"remoteAddr": remoteAddr,
@@ -98,10 +182,17 @@ func (fwd *forwarder) serve() {
 		fwd.cancelFunc()
 	}()
 
-	keepalive := time.NewTicker(30 * time.Second)
+	keepalive := time.NewTicker(keepaliveInterval)
 
 	defer keepalive.Stop()
 
+	var expiry <-chan time.Time
+	if !fwd.expiresAt.IsZero() || fwd.idleTimeout > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		expiry = ticker.C
+	}
+
 	counter := 0
 
 	for {
@@ -112,25 +203,57 @@ func (fwd *forwarder) serve() {
 			if counter > 5 {
 				fwd.cancelFunc()
 			} else {
-				_, err := fwd.sess.SendRequest("keepalive@openssh.com", true, nil)
+				err := fwd.sendKeepalive()
 				if err != nil {
 					logger.WarnN("Failed to send keepalive request")
+					metrics.KeepaliveFailures.Inc()
 					counter++
 				} else {
 					logger.DebugN("send keepalive request")
 					counter = 0
 				}
 			}
+		case now := <-expiry:
+			if warning := fwd.expiryWarning(now); "" != warning {
+				fwd.view.Warn(warning)
+			}
+			if reason := fwd.checkExpiry(now); "" != reason {
+				logger.Info("tunnel expired", map[string]interface{}{
+					"module":   "conn",
+					"accessId": fwd.accessId,
+					"reason":   reason,
+				})
+				fwd.sayGoodbye(reason)
+				fwd.cancelFunc()
+				return
+			}
 		case facadeConn := <-fwd.remoteForwardChan:
 			go fwd.doRemoteForwarded(facadeConn)
 		}
 	}
 }
 
+// sendKeepalive checks the SSH connection is alive, it needs no session
+func (fwd *forwarder) sendKeepalive() error {
+	conn, ok := fwd.sshCtx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return errors.New("no ssh connection")
+	}
+	send := func() error {
+		_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+		return err
+	}
+	if idle, ok := fwd.sshCtx.Value(sshIdleConn).(*idleConn); ok {
+		// keepalives must not keep an idle connection open
+		return idle.keepalive(send)
+	}
+	return send()
+}
+
 func (fwd *forwarder) getForwardDest() *remoteForwardRequest {
-	_, localPortStr, _ := net.SplitHostPort(fwd.sess.LocalAddr().String())
+	_, localPortStr, _ := net.SplitHostPort(fwd.sshCtx.LocalAddr().String())
 	localPort, _ := strconv.Atoi(localPortStr)
-	value := fwd.sess.Context().Value(sshRemoteForward)
+	value := fwd.sshCtx.Value(sshRemoteForward)
 	if nil != value {
 		return value.(*remoteForwardRequest)
 	}
@@ -140,16 +263,10 @@ func (fwd *forwarder) getForwardDest() *remoteForwardRequest {
 	}
 }
 
-func (fwd *forwarder) doRemoteForwarded(facadeConn net.Conn) {
-	s := stat.GetStat(fwd.sess.Context())
-	s.ConnCount += 1
-	s.TotalConn += 1
-	defer func() {
-		s.ConnCount -= 1
-	}()
-
-	remoteAddr := fwd.sess.RemoteAddr().String()
-	svrConn := fwd.sess.Context().Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+// openChannel opens a forwarded-tcpip channel to the client for the visitor of facadeConn
+func (fwd *forwarder) openChannel(traceCtx context.Context, facadeConn net.Conn) (gossh.Channel, error) {
+	remoteAddr := fwd.sshCtx.RemoteAddr().String()
+	svrConn := fwd.sshCtx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
 	logger.Debug("open dispatchRemoteForward channel", map[string]interface{}{
 		"module":     "conn",
 		"accessId":   fwd.accessId,
@@ -167,45 +284,156 @@ func (fwd *forwarder) doRemoteForwarded(facadeConn net.Conn) {
 		OriginPort: uint32(facadePort),
 	})
 
-	gosshChan, _, err := svrConn.OpenChannel("forwarded-tcpip", payload)
-
+	_, openSpan := tracing.Tracer().Start(traceCtx, "ssh.open_channel", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("echogy.access_id", fwd.accessId),
+			attribute.String("ssh.channel_type", "forwarded-tcpip"),
+			attribute.String("ssh.client_address", remoteAddr),
+		))
+	defer openSpan.End()
+	gosshChan, reqs, err := svrConn.OpenChannel("forwarded-tcpip", payload)
 	if err != nil {
+		openSpan.RecordError(err)
+		openSpan.SetStatus(codes.Error, "open channel failed")
 		logger.Error("open dispatchRemoteForward channel", err, map[string]interface{}{
 			"module":     "conn",
 			"accessId":   fwd.accessId,
 			"remoteAddr": remoteAddr,
 		})
-		facadeConn.Close()
-		return
+		return nil, err
+	}
+	go gossh.DiscardRequests(reqs)
+	if version := fwd.proxyProtocol(); proxyProtocolNone != version {
+		header := proxyProtocolHeader(version, facadeConn.RemoteAddr(), facadeConn.LocalAddr())
+		if _, err = gosshChan.Write(header); err != nil {
+			gosshChan.Close()
+			return nil, err
+		}
+	}
+	return gosshChan, nil
+}
+
+func (fwd *forwarder) doRemoteForwarded(facadeConn net.Conn) {
+	s := stat.GetStat(fwd.sshCtx)
+	s.ConnOpened()
+	defer s.ConnClosed()
+
+	traceCtx := context.Background()
+	if hijackConn, ok := facadeConn.(*hijackHttp); ok {
+		traceCtx = hijackConn.TraceContext()
+	}
+
+	// with header rules a reverse proxy opens channels as requests need them
+	rules := fwd.headerRules()
+	var gosshChan gossh.Channel
+	if nil == rules {
+		ch, err := fwd.openChannel(traceCtx, facadeConn)
+		if err != nil {
+			facadeConn.Close()
+			return
+		}
+		gosshChan = ch
 	}
-	chId := fwd.chanCounter.Add(1)
+	// ids are never reused so a conn can be addressed while others come and go
+	chId := fwd.chanSeq.Add(1)
+	fwd.chanCounter.Add(1)
+	fwd.touch()
+	metrics.ForwardsTotal.Inc()
+	metrics.ForwardsActive.Inc()
+	openedAt := time.Now()
+	var bytesIn, bytesOut int64
 
 	defer func() {
+		fwd.touch()
 		fwd.chanCounter.Add(-1)
+		metrics.ForwardsActive.Dec()
 		if value, loaded := fwd.chanMap.LoadAndDelete(chId); loaded {
 			value.(*fwdConn).Close()
 		}
+		alias := metrics.Alias(fwd.accessId)
+		metrics.TunnelBytes.WithLabelValues(alias, "in").Add(float64(bytesIn))
+		metrics.TunnelBytes.WithLabelValues(alias, "out").Add(float64(bytesOut))
+		fwd.view.ConnClosed(chId, bytesIn, bytesOut, time.Since(openedAt))
 	}()
 
 	fwd.chanMap.Store(chId, &fwdConn{
-		ch:   gosshChan,
-		conn: facadeConn,
+		ch:       gosshChan,
+		conn:     facadeConn,
+		openedAt: openedAt,
 	})
+	fwd.view.ConnOpened(chId, facadeConn.RemoteAddr().String())
 
+	if nil == gosshChan {
+		bytesIn, bytesOut = fwd.proxy(traceCtx, facadeConn, rules)
+	} else {
+		bytesIn, bytesOut = fwd.pipe(traceCtx, facadeConn, gosshChan)
+	}
+}
+
+// pipe copies bytes between the visitor and the client until either side closes
+func (fwd *forwarder) pipe(traceCtx context.Context, facadeConn net.Conn, gosshChan gossh.Channel) (bytesIn, bytesOut int64) {
+	copied := make(chan struct{})
 	go func() {
 		defer func() {
 			facadeConn.Close()
 			gosshChan.Close()
+			close(copied)
 		}()
-		_, e := io.Copy(facadeConn, gosshChan)
+		n, e := tracedCopy(traceCtx, "out", facadeConn, fwd.bandwidth.Reader(gosshChan))
+		bytesOut = n
 		if nil != e {
 			logger.ErrorN("io.Copy facade write", e)
 		}
 	}()
-	_, e := io.Copy(gosshChan, facadeConn)
+	n, e := tracedCopy(traceCtx, "in", gosshChan, fwd.bandwidth.Reader(facadeConn))
+	bytesIn = n
 	if nil != e {
 		logger.ErrorN("io.Copy conn write", e)
 	}
+	// both conns are closed, so the copy to the visitor ends too
+	facadeConn.Close()
+	gosshChan.Close()
+	<-copied
+	return bytesIn, bytesOut
+}
+
+// tracedCopy copies src to dst within a span, direction is in for visitor to client and out for client to visitor
+func tracedCopy(ctx context.Context, direction string, dst io.Writer, src io.Reader) (int64, error) {
+	_, span := tracing.Tracer().Start(ctx, "tunnel.copy."+direction,
+		trace.WithAttributes(attribute.String("echogy.direction", direction)))
+	defer span.End()
+	n, err := io.Copy(dst, src)
+	span.SetAttributes(attribute.Int64("echogy.bytes", n))
+	if nil != err {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "copy failed")
+	}
+	return n, err
+}
+
+// closeConn closes the forwarded conn with id, it reports whether it was open
+func (fwd *forwarder) closeConn(id int64) bool {
+	value, loaded := fwd.chanMap.LoadAndDelete(id)
+	if loaded {
+		value.(*fwdConn).Close()
+	}
+	return loaded
+}
+
+// sayGoodbye tells the client why the tunnel ends, once
+func (fwd *forwarder) sayGoodbye(reason string) {
+	fwd.goodbye.Do(func() {
+		fwd.view.Goodbye(reason)
+	})
+}
+
+// kick tells the client why its tunnel is going away and drops the SSH connection
+func (fwd *forwarder) kick(reason string) {
+	fwd.sayGoodbye(reason)
+	fwd.Close()
+	if conn, ok := fwd.sshCtx.Value(ssh.ContextKeyConn).(*gossh.ServerConn); ok {
+		conn.Close()
+	}
 }
 
 func (fwd *forwarder) Close() error {
@@ -215,9 +443,9 @@ func (fwd *forwarder) Close() error {
 		return true
 	})
 
-	if dbg, ok := fwd.sess.Context().Value(sshDebugServer).(*debugServer); ok {
+	if dbg, ok := fwd.sshCtx.Value(sshDebugServer).(*debugServer); ok {
 		dbg.Close()
 	}
 	logger.DebugN("close all pairs fwd conn")
-	return fwd.sess.Exit(0)
+	return fwd.view.Close()
 }
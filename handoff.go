@@ -0,0 +1,78 @@
+package echogy
+
+import (
+	"context"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// handoffDialTimeout bounds connecting to the previous process
+const handoffDialTimeout = time.Second
+
+// handoff keeps the tunnels of this process reachable after an upgrade, the new
+// process passes visitors of tunnels it does not know to a loopback listener here
+type handoff struct {
+	forward func(facadeId string, request *hijackHttp) bool
+
+	mu     sync.Mutex
+	ln     net.Listener
+	cancel context.CancelFunc
+}
+
+// open starts the loopback facade once and returns its address
+func (h *handoff) open() (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if nil == h.ln {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		h.ln, h.cancel = ln, cancel
+		go facadeServe(ctx, ln, h.forward)
+	}
+	return h.ln.Addr().String(), nil
+}
+
+// close stops the loopback facade, open connections keep being served
+func (h *handoff) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if nil != h.cancel {
+		h.cancel()
+	}
+}
+
+// forwardPrevious passes a visitor to the previous process at addr, which still serves
+// the tunnels opened before the upgrade while it drains
+func forwardPrevious(addr string, request *hijackHttp) bool {
+	if "" == addr {
+		return false
+	}
+	upstream, err := net.DialTimeout("tcp", addr, handoffDialTimeout)
+	if err != nil {
+		logger.Debug("previous process gone", map[string]interface{}{
+			"module":  "facade",
+			"address": addr,
+			"error":   err.Error(),
+		})
+		return false
+	}
+	// the raw connection is piped, the previous process inspects and traces the requests
+	go func() {
+		defer func() {
+			upstream.Close()
+			request.Close()
+		}()
+		go func() {
+			io.Copy(upstream, request.Conn)
+			upstream.Close()
+		}()
+		io.Copy(request.Conn, upstream)
+	}()
+	return true
+}
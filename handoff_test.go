@@ -0,0 +1,80 @@
+package echogy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestForwardPrevious(t *testing.T) {
+	// the previous process only knows the tunnel old
+	previous := &handoff{forward: func(facadeId string, request *hijackHttp) bool {
+		if "old" != facadeId {
+			return false
+		}
+		io.WriteString(request, "HTTP/1.0 200 OK\r\nContent-Length: 3\r\n\r\nold")
+		request.Close()
+		return true
+	}}
+	addr, err := previous.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := previous.open(); again != addr {
+		t.Fatalf("open() = %s, want %s", again, addr)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go facadeServe(ctx, ln, func(facadeId string, request *hijackHttp) bool {
+		return forwardPrevious(addr, request)
+	})
+
+	get := func(host string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		closed bool
+		status int
+		body   string
+	}{
+		{name: "tunnel of previous process", host: "old.webs.sh", status: http.StatusOK, body: "old"},
+		{name: "unknown tunnel", host: "new.webs.sh", status: http.StatusNotFound},
+		{name: "previous process gone", host: "old.webs.sh", closed: true, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.closed {
+				previous.close()
+			}
+			status, body := get(tt.host)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if "" != tt.body && body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+
+	if forwardPrevious("", nil) {
+		t.Errorf("forwardPrevious() without a previous process = true")
+	}
+}
@@ -0,0 +1,78 @@
+package echogy
+
+import (
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/har"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/gliderlabs/ssh"
+	"net/http"
+)
+
+// exportHar builds an archive from the request history of a tunnel context
+func exportHar(ctx ssh.Context) *har.HAR {
+	h := har.New(Version)
+	h.Append(stat.GetHistory(ctx).Items()...)
+	return h
+}
+
+func harFilename(ctx ssh.Context) string {
+	if id, ok := ctx.Value(sshAccessIdKey).(string); ok {
+		return id + ".har"
+	}
+	return "echogy.har"
+}
+
+func (f *debugServer) harHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", harFilename(f.ctx)))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := exportHar(f.ctx).Encode(w); err != nil {
+		logger.Error("export har", err, map[string]interface{}{
+			"server": "debug",
+		})
+	}
+}
+
+// isSameOwner reports whether two connections were authenticated as the same client,
+// by public key fingerprint or by configured alias
+func isSameOwner(a, b ssh.Context) bool {
+	if fa, ok := a.Value(clientPublicKeyFingerprintSha256).(string); ok && "" != fa {
+		if fb, ok := b.Value(clientPublicKeyFingerprintSha256).(string); ok && fa == fb {
+			return true
+		}
+	}
+	if aa, ok := a.Value(clientHttpAlias).(string); ok && "" != aa {
+		if ab, ok := b.Value(clientHttpAlias).(string); ok && aa == ab {
+			return true
+		}
+	}
+	return a == b
+}
+
+// findOwnedForwarder looks up a live tunnel owned by the client of ctx,
+// an empty accessId matches the oldest one
+func findOwnedForwarder(ctx ssh.Context, accessId string) (*forwarder, bool) {
+	for _, fwd := range ownedForwarders(ctx) {
+		if "" == accessId || fwd.accessId == accessId {
+			return fwd, true
+		}
+	}
+	return nil, false
+}
+
+// harCommand writes the archive of a tunnel to the session, `ssh host har [accessId]`
+func harCommand(session ssh.Session, args []string) int {
+	fwd, code := commandForwarder(session, args)
+	if nil == fwd {
+		return code
+	}
+	if err := exportHar(fwd.sshCtx).Encode(session); err != nil {
+		logger.Error("export har", err, map[string]interface{}{
+			"module":   "serve",
+			"accessId": fwd.accessId,
+		})
+		return exitFailed
+	}
+	return exitOK
+}
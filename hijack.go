@@ -3,24 +3,61 @@ package echogy
 import (
 	"bufio"
 	"bytes"
+	"context"
 	q "github.com/echogy-io/echogy/pkg/queue"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/echogy-io/echogy/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
-type Dispatch func(*http.Response, *http.Request, int64)
+// maxCaptureBody limits how much of a request or response body is kept for inspection
+const maxCaptureBody = 64 << 10
+
+var (
+	headerEnd         = []byte("\r\n\r\n")
+	responsePrefix    = []byte("HTTP/")
+	lastChunkTrailing = []byte("0\r\n\r\n")
+)
+
+type Dispatch func(*stat.RequestEntity)
 
 type hijackHttp struct {
 	net.Conn
 	hasFwdReq bool
 	dispatch  Dispatch
 	queue     *q.SyncQueue
+
+	mu sync.Mutex
+	// pending is the response being written, it is dispatched once complete
+	pending *pendingResponse
+
+	// traceCtx carries the facade span, which ends when the connection closes
+	traceCtx context.Context
+	span     trace.Span
+	// unread is the rest of a request head that grew by the trace headers
+	unread []byte
 }
 
 type request struct {
 	*http.Request
-	startTime int64
+	startTime time.Time
+	body      []byte
+	// span covers the request until its response is complete
+	span trace.Span
+}
+
+type pendingResponse struct {
+	entity *stat.RequestEntity
+	span   trace.Span
+	// remaining body bytes, -1 when the length is unknown until the next response or close
+	remaining int64
+	chunked   bool
 }
 
 func newHijackConn(conn net.Conn) *hijackHttp {
@@ -32,44 +69,196 @@ func newHijackConn(conn net.Conn) *hijackHttp {
 	}
 }
 
+// captureBody reads what is available of body without blocking on the network,
+// the underlying reader only holds the bytes of a single read or write.
+func captureBody(body io.ReadCloser) []byte {
+	if nil == body || http.NoBody == body {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(body, maxCaptureBody))
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
 func (h *hijackHttp) Read(b []byte) (n int, err error) {
-	n, err = bufio.NewReader(h.Conn).Read(b)
+	if len(h.unread) > 0 {
+		n = copy(b, h.unread)
+		h.unread = h.unread[n:]
+		return n, nil
+	}
+	n, err = h.Conn.Read(b)
 	if err != nil {
 		return n, err
 	}
 
 	reader := bytes.NewReader(b[:n])
 	if req, err := http.ReadRequest(bufio.NewReader(reader)); err == nil {
+		span := h.traceRequest(req)
+		// the local service continues the trace from the request span
+		if head, ok := setHeaderFields(b[:n], tracing.Inject(trace.ContextWithSpan(context.Background(), span))); ok {
+			n = copy(b, head)
+			h.unread = head[n:]
+		}
 		// add req to queue
 		h.queue.Push(&request{
 			Request:   req,
-			startTime: time.Now().UnixMilli(),
+			startTime: time.Now(),
+			body:      captureBody(req.Body),
+			span:      span,
 		})
 	}
 	return n, nil
 }
 
+// traceRequest starts the span of a request, it continues the trace of the visitor
+// when the request carries one, else it is a child of the facade span
+func (h *hijackHttp) traceRequest(req *http.Request) trace.Span {
+	_, span := tracing.Tracer().Start(tracing.Extract(h.TraceContext(), req.Header), "facade.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.Host),
+			attribute.String("url.path", req.URL.Path),
+		))
+	return span
+}
+
 func (h *hijackHttp) SetDispatch(d Dispatch) {
 	h.dispatch = d
 }
 
+// SetSpan attaches the facade span of the connection
+func (h *hijackHttp) SetSpan(ctx context.Context, span trace.Span) {
+	h.traceCtx = ctx
+	h.span = span
+}
+
+// TraceContext returns the context carrying the facade span
+func (h *hijackHttp) TraceContext() context.Context {
+	if nil == h.traceCtx {
+		return context.Background()
+	}
+	return h.traceCtx
+}
+
 func (h *hijackHttp) Write(b []byte) (n int, err error) {
 	n, err = h.Conn.Write(b)
 	if nil != err {
 		return n, err
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if nil != h.pending && h.pending.remaining >= 0 {
+		h.continueLocked(b, now)
+		return n, nil
+	}
+
+	if !bytes.HasPrefix(b, responsePrefix) {
+		if nil != h.pending {
+			h.continueLocked(b, now)
+		}
+		return n, nil
+	}
+	// a new response also completes a response of unknown length
+	h.finishLocked(now)
 
 	pop := h.queue.Pop()
 	if nil != pop {
 		r := pop.(*request)
 		// pop request from request queue and Try to parse HTTP response from the data
-		if nil != h.dispatch {
-			reader := bytes.NewReader(b)
-			if resp, err := http.ReadResponse(bufio.NewReader(reader), r.Request); nil == err {
-				useTime := time.Now().UnixMilli() - r.startTime
-				h.dispatch(resp, r.Request, useTime)
-			}
+		reader := bytes.NewReader(b)
+		if resp, err := http.ReadResponse(bufio.NewReader(reader), r.Request); nil != h.dispatch && nil == err {
+			h.startLocked(r, resp, b, now)
+		} else {
+			r.span.End()
 		}
 	}
 	return n, nil
 }
+
+func (h *hijackHttp) startLocked(r *request, resp *http.Response, b []byte, now time.Time) {
+	ttfb := now.Sub(r.startTime).Milliseconds()
+	p := &pendingResponse{
+		entity: &stat.RequestEntity{
+			Request:      r.Request,
+			Response:     resp,
+			UseTime:      ttfb,
+			TTFB:         ttfb,
+			StartTime:    r.startTime,
+			RequestBody:  r.body,
+			ResponseBody: captureBody(resp.Body),
+		},
+		span:      r.span,
+		remaining: -1,
+		chunked:   len(resp.TransferEncoding) > 0 && "chunked" == resp.TransferEncoding[0],
+	}
+
+	written := int64(-1)
+	if idx := bytes.Index(b, headerEnd); idx >= 0 {
+		written = int64(len(b) - idx - len(headerEnd))
+	}
+	switch {
+	case http.MethodHead == r.Method || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified || (resp.StatusCode >= 100 && resp.StatusCode < 200):
+		p.remaining = 0
+	case resp.ContentLength >= 0 && written >= 0:
+		p.remaining = max(resp.ContentLength-written, 0)
+	case p.chunked && bytes.HasSuffix(b, lastChunkTrailing):
+		p.remaining = 0
+	}
+
+	h.pending = p
+	if 0 == p.remaining {
+		h.finishLocked(now)
+	}
+}
+
+// continueLocked accounts a body write of the pending response
+func (h *hijackHttp) continueLocked(b []byte, now time.Time) {
+	p := h.pending
+	if !p.chunked && len(p.entity.ResponseBody) < maxCaptureBody {
+		take := min(len(b), maxCaptureBody-len(p.entity.ResponseBody))
+		p.entity.ResponseBody = append(p.entity.ResponseBody, b[:take]...)
+	}
+	switch {
+	case p.remaining > 0:
+		p.remaining = max(p.remaining-int64(len(b)), 0)
+		if 0 == p.remaining {
+			h.finishLocked(now)
+		}
+	case p.chunked && bytes.HasSuffix(b, lastChunkTrailing):
+		h.finishLocked(now)
+	}
+}
+
+func (h *hijackHttp) finishLocked(now time.Time) {
+	p := h.pending
+	if nil == p {
+		return
+	}
+	h.pending = nil
+	p.entity.UseTime = now.Sub(p.entity.StartTime).Milliseconds()
+	p.span.SetAttributes(attribute.Int("http.response.status_code", p.entity.Response.StatusCode))
+	p.span.End()
+	h.dispatch(p.entity)
+}
+
+// Close dispatches a response whose end was only marked by the connection closing
+func (h *hijackHttp) Close() error {
+	h.mu.Lock()
+	h.finishLocked(time.Now())
+	// requests left without response
+	for pop := h.queue.Pop(); nil != pop; pop = h.queue.Pop() {
+		pop.(*request).span.End()
+	}
+	if nil != h.span {
+		h.span.End()
+		h.span = nil
+	}
+	h.mu.Unlock()
+	return h.Conn.Close()
+}
@@ -0,0 +1,111 @@
+package echogy
+
+import (
+	"bytes"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"net"
+	"testing"
+)
+
+// stubConn reads from a fixed buffer and discards writes
+type stubConn struct {
+	net.Conn
+	in *bytes.Buffer
+}
+
+func (s *stubConn) Read(b []byte) (int, error)  { return s.in.Read(b) }
+func (s *stubConn) Write(b []byte) (int, error) { return len(b), nil }
+func (s *stubConn) Close() error                { return nil }
+
+func newTestHijack(t *testing.T, rawReq string) (*hijackHttp, *[]*stat.RequestEntity) {
+	h := newHijackConn(&stubConn{in: bytes.NewBufferString(rawReq)})
+	dispatched := make([]*stat.RequestEntity, 0)
+	h.SetDispatch(func(e *stat.RequestEntity) {
+		dispatched = append(dispatched, e)
+	})
+	buf := make([]byte, 4096)
+	if _, err := h.Read(buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return h, &dispatched
+}
+
+func TestHijackDispatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    string
+		writes     []string
+		close      bool
+		wantBefore int // dispatched before the last write
+		wantBody   string
+	}{
+		{
+			name:     "content length in one write",
+			request:  "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n",
+			writes:   []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"},
+			wantBody: "hello",
+		},
+		{
+			name:    "content length across writes",
+			request: "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n",
+			writes: []string{
+				"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello",
+				"world",
+			},
+			wantBody: "helloworld",
+		},
+		{
+			name:    "chunked",
+			request: "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n",
+			writes: []string{
+				"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n",
+				"0\r\n\r\n",
+			},
+			wantBody: "hello",
+		},
+		{
+			name:     "head has no body",
+			request:  "HEAD / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n",
+			writes:   []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"},
+			wantBody: "",
+		},
+		{
+			name:     "unknown length ends on close",
+			request:  "GET / HTTP/1.0\r\nHost: a.webs.sh\r\n\r\n",
+			writes:   []string{"HTTP/1.0 200 OK\r\n\r\nstream", "ing"},
+			close:    true,
+			wantBody: "streaming",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, dispatched := newTestHijack(t, tt.request)
+			for i, w := range tt.writes {
+				if i == len(tt.writes)-1 && len(*dispatched) != tt.wantBefore {
+					t.Fatalf("dispatched %d before the last write, want %d", len(*dispatched), tt.wantBefore)
+				}
+				if _, err := h.Write([]byte(w)); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if tt.close {
+				if len(*dispatched) != 0 {
+					t.Fatalf("dispatched %d before close, want 0", len(*dispatched))
+				}
+				h.Close()
+				h.Close()
+			}
+			if len(*dispatched) != 1 {
+				t.Fatalf("dispatched %d, want 1", len(*dispatched))
+			}
+			e := (*dispatched)[0]
+			if string(e.ResponseBody) != tt.wantBody {
+				t.Errorf("ResponseBody = %q, want %q", e.ResponseBody, tt.wantBody)
+			}
+			if e.TTFB > e.UseTime {
+				t.Errorf("TTFB = %v greater than UseTime = %v", e.TTFB, e.UseTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package echogy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+)
+
+const (
+	// hostKeysRequest announces the host keys of the server after authentication,
+	// OpenSSH clients add new keys to known_hosts and drop keys no longer listed
+	hostKeysRequest = "hostkeys-00@openssh.com"
+	// hostKeysProveRequest asks the server to sign keys it announced
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+	hostKeysAnnounced    = "hostKeysAnnounced"
+)
+
+// generatedHostKeys are the key files created in hostKeyDir, named as by OpenSSH
+var generatedHostKeys = []struct {
+	file string
+	new  func() (crypto.PrivateKey, error)
+}{
+	{"ssh_host_ed25519_key", func() (crypto.PrivateKey, error) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}},
+	{"ssh_host_ecdsa_key", func() (crypto.PrivateKey, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}},
+}
+
+func (c *Config) hostKeyDir() (string, error) {
+	if "" != c.HostKeyDir {
+		return c.HostKeyDir, nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(executable), nil
+}
+
+// loadOrGenerateHostKeys reads the generated host keys of dir, creating missing ones
+func loadOrGenerateHostKeys(dir string) ([]gossh.Signer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("hostKeyDir: %v", err)
+	}
+	signers := make([]gossh.Signer, 0, len(generatedHostKeys))
+	for _, g := range generatedHostKeys {
+		path := filepath.Join(dir, g.file)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			data, err = generateHostKey(path, g.new)
+			if nil == err {
+				logger.Warn("host key generated", map[string]interface{}{
+					"module": "hostkeys",
+					"path":   path,
+				})
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("hostKeyDir: %v", err)
+		}
+		signer, err := gossh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("hostKeyDir: %s: %v", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func generateHostKey(path string, newKey func() (crypto.PrivateKey, error)) ([]byte, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := gossh.MarshalPrivateKey(key, "echogy")
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(block)
+	// O_EXCL keeps a key written concurrently by another process
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return data, f.Close()
+}
+
+// hostSigners loads privateKey and hostKeys in order. Without any configured key the
+// keys of hostKeyDir are used, generated on first start when generate is set.
+func (c *Config) hostSigners(generate bool) ([]gossh.Signer, error) {
+	var errs []error
+	signers := make([]gossh.Signer, 0, 1+len(c.HostKeys))
+	signer, err := c.hostSigner()
+	if err != nil {
+		errs = append(errs, err)
+	} else if nil != signer {
+		signers = append(signers, signer)
+	}
+	for i, path := range c.HostKeys {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			signer, err = gossh.ParsePrivateKey(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("hostKeys[%d]: %v", i, err))
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if 0 == len(signers) && generate {
+		dir, err := c.hostKeyDir()
+		if err != nil {
+			return nil, fmt.Errorf("hostKeyDir: %v", err)
+		}
+		return loadOrGenerateHostKeys(dir)
+	}
+	return signers, nil
+}
+
+// plainKey returns the key certified by a host certificate
+func plainKey(key gossh.PublicKey) gossh.PublicKey {
+	if cert, ok := key.(*gossh.Certificate); ok {
+		return cert.Key
+	}
+	return key
+}
+
+// errHostKeysChanged refuses a reload that adds, removes or replaces host keys
+var errHostKeysChanged = errors.New("host keys changed")
+
+// hostKeySet is a version of the host keys, replaced when certificates are renewed
+type hostKeySet struct {
+	// handshakeSigners serve handshakes, the first signer of each algorithm
+	handshakeSigners []ssh.Signer
+	// announce is the hostkeys-00 payload, the plain public keys of signers
+	announce []byte
+	// byKey finds the signer of a plain public key blob
+	byKey map[string]gossh.Signer
+}
+
+func newHostKeySet(signers []gossh.Signer, allowed []string) (*hostKeySet, error) {
+	set := &hostKeySet{byKey: make(map[string]gossh.Signer, len(signers))}
+	for _, signer := range signers {
+		blob := plainKey(signer.PublicKey()).Marshal()
+		if _, found := set.byKey[string(blob)]; found {
+			continue
+		}
+		set.byKey[string(blob)] = signer
+		set.announce = append(set.announce, gossh.Marshal(struct{ Key []byte }{blob})...)
+	}
+	seen := make(map[string]bool)
+	for _, signer := range signers {
+		if seen[signer.PublicKey().Type()] {
+			continue
+		}
+		seen[signer.PublicKey().Type()] = true
+		set.handshakeSigners = append(set.handshakeSigners, signer)
+	}
+	restricted, err := restrictHostKeys(set.handshakeSigners, allowed)
+	if err != nil {
+		return nil, err
+	}
+	set.handshakeSigners = restricted
+	return set, nil
+}
+
+// hostKeys are the host keys of the server
+type hostKeys struct {
+	signers []gossh.Signer
+	set     atomic.Pointer[hostKeySet]
+	// slots are handed to the server once and sign with the set in effect
+	slots []ssh.Signer
+}
+
+// liveHostKeys are the host keys of the serving ssh server, updated by Reload
+var liveHostKeys atomic.Pointer[hostKeys]
+
+func newHostKeys(signers []gossh.Signer) *hostKeys {
+	h := &hostKeys{signers: signers}
+	// without restrictions the set cannot fail
+	set, _ := newHostKeySet(signers, nil)
+	h.store(set)
+	return h
+}
+
+func (h *hostKeys) store(set *hostKeySet) {
+	h.set.Store(set)
+	h.slots = make([]ssh.Signer, len(set.handshakeSigners))
+	for i := range set.handshakeSigners {
+		h.slots[i] = &hostKeySlot{keys: h, index: i}
+	}
+}
+
+// handshake returns the first signer of each algorithm, later ones are only announced
+func (h *hostKeys) handshake() []ssh.Signer {
+	return h.slots
+}
+
+// restrict offers the handshake signers with the allowed algorithms only
+func (h *hostKeys) restrict(allowed []string) error {
+	set, err := newHostKeySet(h.signers, allowed)
+	if err != nil {
+		return err
+	}
+	h.store(set)
+	return nil
+}
+
+// update swaps in renewed certificates of the same keys and reports whether any
+// handshake key changed. Other changes fail with errHostKeysChanged and keep the keys.
+func (h *hostKeys) update(signers []gossh.Signer, allowed []string) (bool, error) {
+	next, err := newHostKeySet(signers, allowed)
+	if err != nil {
+		return false, err
+	}
+	current := h.set.Load()
+	if !bytes.Equal(current.announce, next.announce) || len(current.handshakeSigners) != len(next.handshakeSigners) {
+		return false, errHostKeysChanged
+	}
+	changed := false
+	for i, signer := range next.handshakeSigners {
+		old := current.handshakeSigners[i]
+		if old.PublicKey().Type() != signer.PublicKey().Type() ||
+			!slices.Equal(hostKeyAlgorithms(old), hostKeyAlgorithms(signer)) {
+			return false, errHostKeysChanged
+		}
+		changed = changed || !bytes.Equal(old.PublicKey().Marshal(), signer.PublicKey().Marshal())
+	}
+	h.signers = signers
+	h.set.Store(next)
+	return changed, nil
+}
+
+// hostKeyAlgorithms returns the signature algorithms of signer as golang.org/x/crypto/ssh
+// derives them for handshakes
+func hostKeyAlgorithms(signer gossh.Signer) []string {
+	if multi, ok := signer.(gossh.MultiAlgorithmSigner); ok {
+		return multi.Algorithms()
+	}
+	keyType := plainKey(signer.PublicKey()).Type()
+	if _, ok := signer.(gossh.AlgorithmSigner); ok && gossh.KeyAlgoRSA == keyType {
+		return []string{gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// hostKeySlot signs handshakes with the signer at index of the host keys in effect,
+// the server keeps its slots while certificates are renewed
+type hostKeySlot struct {
+	keys  *hostKeys
+	index int
+}
+
+func (s *hostKeySlot) signer() ssh.Signer {
+	return s.keys.set.Load().handshakeSigners[s.index]
+}
+
+func (s *hostKeySlot) PublicKey() gossh.PublicKey {
+	return s.signer().PublicKey()
+}
+
+func (s *hostKeySlot) Sign(rand io.Reader, data []byte) (*gossh.Signature, error) {
+	return s.signer().Sign(rand, data)
+}
+
+func (s *hostKeySlot) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*gossh.Signature, error) {
+	signer := s.signer()
+	if as, ok := signer.(gossh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	if algorithm != plainKey(signer.PublicKey()).Type() {
+		return nil, fmt.Errorf("host key %s cannot sign with %s", signer.PublicKey().Type(), algorithm)
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s *hostKeySlot) Algorithms() []string {
+	return hostKeyAlgorithms(s.signer())
+}
+
+// announceTo sends the host keys to the client of ctx once
+func (h *hostKeys) announceTo(ctx ssh.Context) {
+	ctx.Lock()
+	if nil != ctx.Value(hostKeysAnnounced) {
+		ctx.Unlock()
+		return
+	}
+	ctx.SetValue(hostKeysAnnounced, true)
+	ctx.Unlock()
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return
+	}
+	if _, _, err := conn.SendRequest(hostKeysRequest, false, h.set.Load().announce); err != nil {
+		logger.Debug("announce host keys failed", map[string]interface{}{
+			"module": "hostkeys",
+			"error":  err.Error(),
+		})
+	}
+}
+
+// splitStrings parses a sequence of SSH strings
+func splitStrings(payload []byte) ([][]byte, error) {
+	result := make([][]byte, 0)
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, errors.New("short string length")
+		}
+		n := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if uint64(n) > uint64(len(payload)) {
+			return nil, errors.New("string exceeds payload")
+		}
+		result = append(result, payload[:n])
+		payload = payload[n:]
+	}
+	return result, nil
+}
+
+// hostKeysProveData is signed to prove possession of an announced key
+func hostKeysProveData(sessionID, blob []byte) []byte {
+	return gossh.Marshal(struct {
+		Request   string
+		SessionID []byte
+		Key       []byte
+	}{hostKeysProveRequest, sessionID, blob})
+}
+
+// proveHandler answers hostkeys-prove-00 with a signature for each requested key
+func (h *hostKeys) proveHandler(ctx ssh.Context, _ *ssh.Server, req *gossh.Request) (bool, []byte) {
+	blobs, err := splitStrings(req.Payload)
+	if err != nil {
+		return false, nil
+	}
+	sessionID, err := hex.DecodeString(ctx.SessionID())
+	if err != nil {
+		return false, nil
+	}
+	set := h.set.Load()
+	var reply bytes.Buffer
+	for _, blob := range blobs {
+		signer, found := set.byKey[string(blob)]
+		if !found {
+			logger.Debug("prove unknown host key", map[string]interface{}{
+				"module": "hostkeys",
+			})
+			return false, nil
+		}
+		var sig *gossh.Signature
+		data := hostKeysProveData(sessionID, blob)
+		// RSA keys sign with SHA-512 as OpenSSH does
+		if as, ok := signer.(gossh.AlgorithmSigner); ok && gossh.KeyAlgoRSA == plainKey(signer.PublicKey()).Type() {
+			sig, err = as.SignWithAlgorithm(rand.Reader, data, gossh.KeyAlgoRSASHA512)
+		} else {
+			sig, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return false, nil
+		}
+		reply.Write(gossh.Marshal(struct{ Sig []byte }{gossh.Marshal(sig)}))
+	}
+	return true, reply.Bytes()
+}
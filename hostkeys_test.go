@@ -0,0 +1,216 @@
+package echogy
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestHostSignersGenerate(t *testing.T) {
+	c := &Config{HostKeyDir: filepath.Join(t.TempDir(), "keys")}
+	if signers, err := c.hostSigners(false); err != nil || 0 != len(signers) {
+		t.Fatalf("hostSigners(false) = %d keys, %v, want none", len(signers), err)
+	}
+	first, err := c.hostSigners(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if 2 != len(first) || gossh.KeyAlgoED25519 != first[0].PublicKey().Type() || gossh.KeyAlgoECDSA256 != first[1].PublicKey().Type() {
+		t.Fatalf("hostSigners(true) = %v", first)
+	}
+	info, err := os.Stat(filepath.Join(c.HostKeyDir, "ssh_host_ed25519_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if 0600 != info.Mode().Perm() {
+		t.Errorf("host key mode = %v, want 0600", info.Mode().Perm())
+	}
+	// a restart loads the persisted keys
+	second, err := c.hostSigners(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range first {
+		if string(first[i].PublicKey().Marshal()) != string(second[i].PublicKey().Marshal()) {
+			t.Errorf("key %d changed across restarts", i)
+		}
+	}
+}
+
+func newTestSigner(t *testing.T, ecdsaKey bool) gossh.Signer {
+	var key interface{}
+	var err error
+	if ecdsaKey {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestHostKeysAnnounceAndProve(t *testing.T) {
+	current := newTestSigner(t, false)
+	ecdsaKey := newTestSigner(t, true)
+	// a second ed25519 key is only announced, ready to replace current
+	next := newTestSigner(t, false)
+	keys := newHostKeys([]gossh.Signer{current, ecdsaKey, next})
+	if 2 != len(keys.handshake()) {
+		t.Fatalf("handshake() = %d keys, want 2", len(keys.handshake()))
+	}
+
+	server := newSessionServer("", "webs.sh", keys, 80, nil, newSSHLimits(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+	allowAccess(t, AccessConfig{Register: true})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handshakeKey gossh.PublicKey
+	client, _, reqs, err := gossh.NewClientConn(conn, ln.Addr().String(), &gossh.ClientConfig{
+		User:              "register",
+		Auth:              []gossh.AuthMethod{gossh.PublicKeys(newTestSigner(t, false))},
+		HostKeyAlgorithms: []string{gossh.KeyAlgoED25519},
+		HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+			handshakeKey = key
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if string(current.PublicKey().Marshal()) != string(handshakeKey.Marshal()) {
+		t.Errorf("handshake used %s, want the first ed25519 key", gossh.FingerprintSHA256(handshakeKey))
+	}
+
+	if _, _, err = client.SendRequest(sshSessionTypeForward, true, gossh.Marshal(&remoteForwardRequest{BindPort: 0})); err != nil {
+		t.Fatal(err)
+	}
+	var announced *gossh.Request
+	select {
+	case announced = <-reqs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no host keys announced")
+	}
+	if hostKeysRequest != announced.Type {
+		t.Fatalf("request %s, want %s", announced.Type, hostKeysRequest)
+	}
+	blobs, err := splitStrings(announced.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if 3 != len(blobs) {
+		t.Fatalf("announced %d keys, want 3", len(blobs))
+	}
+
+	ok, reply, err := client.SendRequest(hostKeysProveRequest, true, announced.Payload)
+	if err != nil || !ok {
+		t.Fatalf("prove = %v, %v", ok, err)
+	}
+	sigs, err := splitStrings(reply)
+	if err != nil || len(sigs) != len(blobs) {
+		t.Fatalf("prove returned %d signatures, %v", len(sigs), err)
+	}
+	for i, blob := range blobs {
+		key, err := gossh.ParsePublicKey(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sig gossh.Signature
+		if err = gossh.Unmarshal(sigs[i], &sig); err != nil {
+			t.Fatal(err)
+		}
+		if err = key.Verify(hostKeysProveData(client.SessionID(), blob), &sig); err != nil {
+			t.Errorf("signature %d: %v", i, err)
+		}
+	}
+
+	unknown := gossh.Marshal(struct{ Key []byte }{newTestSigner(t, false).PublicKey().Marshal()})
+	if ok, _, _ = client.SendRequest(hostKeysProveRequest, true, unknown); ok {
+		t.Error("proved a key the server does not have")
+	}
+}
+
+func TestHostKeysRenewCertificate(t *testing.T) {
+	key, ca := newTestSigner(t, false), newTestSigner(t, false)
+	certSigner := func(serial uint64) gossh.Signer {
+		cert := &gossh.Certificate{
+			Key:         key.PublicKey(),
+			Serial:      serial,
+			CertType:    gossh.HostCert,
+			ValidBefore: gossh.CertTimeInfinity,
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		signer, err := gossh.NewCertSigner(cert, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+	keys := newHostKeys([]gossh.Signer{certSigner(1)})
+	server := newSessionServer("", "webs.sh", keys, 80, nil, newSSHLimits(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	// handshakeSerial returns the serial of the certificate a new connection is offered
+	handshakeSerial := func() uint64 {
+		var serial uint64
+		gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User:              "register",
+			HostKeyAlgorithms: []string{gossh.CertAlgoED25519v01},
+			HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+				if cert, ok := key.(*gossh.Certificate); ok {
+					serial = cert.Serial
+				}
+				return nil
+			},
+			Timeout: 5 * time.Second,
+		})
+		return serial
+	}
+	if got := handshakeSerial(); 1 != got {
+		t.Fatalf("serial = %d, want 1", got)
+	}
+
+	if renewed, err := keys.update([]gossh.Signer{certSigner(2)}, nil); err != nil || !renewed {
+		t.Fatalf("update() = %v, %v, want renewed", renewed, err)
+	}
+	if got := handshakeSerial(); 2 != got {
+		t.Errorf("serial = %d after renewal, want 2", got)
+	}
+
+	if _, err = keys.update([]gossh.Signer{newTestSigner(t, false)}, nil); !errors.Is(err, errHostKeysChanged) {
+		t.Errorf("update() with another key = %v, want %v", err, errHostKeysChanged)
+	}
+	if got := handshakeSerial(); 2 != got {
+		t.Errorf("serial = %d after a refused update, want 2", got)
+	}
+}
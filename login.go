@@ -0,0 +1,123 @@
+package echogy
+
+import (
+	"github.com/echogy-io/echogy/pkg/auth"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const registerUser = "register"
+
+// AccessConfig decides who may log in besides the clients configured in auth
+type AccessConfig struct {
+	// Anonymous lets clients log in with an empty password and a random subdomain,
+	// except as a user that has a password
+	Anonymous bool `json:"anonymous"`
+	// Register lets unknown public keys log in as the register user
+	Register bool `json:"register"`
+	// AnonymousLimits apply to the tunnels of clients without a configured alias
+	AnonymousLimits *TunnelLimits `json:"anonymousLimits"`
+}
+
+// currentAccess returns the access policy in effect, nothing is allowed without one
+func currentAccess() AccessConfig {
+	if c := liveConfig.Load(); nil != c && nil != c.Access {
+		return *c.Access
+	}
+	return AccessConfig{}
+}
+
+// warnNoAccess points out that a config without an access section, which allowed
+// anonymous and register logins before it existed, now refuses them
+func warnNoAccess(config *Config) {
+	if nil != config.Access {
+		return
+	}
+	logger.Warn("no access section, anonymous and register logins are refused", map[string]interface{}{
+		"module": "serve",
+		"hint":   `add "access": {"anonymous": true, "register": true} to allow them as before`,
+	})
+}
+
+func isRegister(ctx ssh.Context) bool {
+	user, _ := ctx.Value(ssh.ContextKeyUser).(string)
+	return registerUser == user && nil == ctx.Value(clientHttpAlias)
+}
+
+func hasPassword(authenticator auth.Auth, user string) bool {
+	p, ok := authenticator.(auth.PasswordUsers)
+	return ok && p.HasPassword(user)
+}
+
+// anonymousLogin tells whether user may log in anonymously, which neither the register
+// user nor users with a password may
+func anonymousLogin(access AccessConfig, user string, authenticator auth.Auth) bool {
+	return access.Anonymous && registerUser != user && (nil == authenticator || !hasPassword(authenticator, user))
+}
+
+// publicKeyLogin accepts configured keys, unknown keys of the register user when
+// registration is enabled and other unknown keys when anonymous access is, except
+// for users with a password. Refused clients fall back to keyboard-interactive.
+func publicKeyLogin(ctx ssh.Context, key ssh.PublicKey, authenticator auth.Auth) bool {
+	if loginBanned(ctx, false) {
+		return false
+	}
+	sha256 := fingerprintSHA256(key)
+	if nil != authenticator {
+		if alias, found := authenticator.PubKey(key); found {
+			ctx.SetValue(clientPublicKeyFingerprintSha256, sha256)
+			ctx.SetValue(clientHttpAlias, alias)
+			if a, ok := authenticator.(auth.AdminAuth); ok && a.IsAdmin(key) {
+				ctx.SetValue(clientAdmin, true)
+			}
+			return true
+		}
+	}
+	access := currentAccess()
+	user, _ := ctx.Value(ssh.ContextKeyUser).(string)
+	if isRegister(ctx) && access.Register || anonymousLogin(access, user, authenticator) {
+		ctx.SetValue(clientPublicKeyFingerprintSha256, sha256)
+		return true
+	}
+	metrics.AuthFailures.WithLabelValues("publickey").Inc()
+	ctx.SetValue(publicKeyRejected, true)
+	return false
+}
+
+// passwordLogin asks for a password. A configured password logs in with its alias, an
+// empty one logs in anonymously under the rules of publicKeyLogin.
+func passwordLogin(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge, authenticator auth.Auth) bool {
+	if loginBanned(ctx, true) {
+		return false
+	}
+	user, _ := ctx.Value(ssh.ContextKeyUser).(string)
+	access := currentAccess()
+	prompt := "Login to Echogy.io\nEnter Password: "
+	if access.Anonymous {
+		prompt = "Login to Echogy.io\nEnter Password (empty for anonymous access): "
+	}
+	answers, err := challenger("", "", []string{prompt}, []bool{false})
+	if nil != err || 1 != len(answers) {
+		metrics.AuthFailures.WithLabelValues("keyboard-interactive").Inc()
+		return false
+	}
+	password := answers[0]
+	if "" == password {
+		if anonymousLogin(access, user, authenticator) {
+			return true
+		}
+		metrics.AuthFailures.WithLabelValues("keyboard-interactive").Inc()
+		return false
+	}
+	if nil != authenticator {
+		if alias, found := authenticator.Password(user, password); found {
+			ctx.SetValue(clientHttpAlias, alias)
+			return true
+		}
+	}
+	metrics.AuthFailures.WithLabelValues("keyboard-interactive").Inc()
+	loginFailed(ctx, "password")
+	return false
+}
@@ -0,0 +1,127 @@
+package echogy
+
+import (
+	"errors"
+	"github.com/echogy-io/echogy/pkg/auth"
+	gossh "golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+// allowAccess serves with access until the test ends
+func allowAccess(t *testing.T, access AccessConfig) {
+	liveConfig.Store(&Config{Domain: "webs.sh", Access: &access})
+	t.Cleanup(func() {
+		liveConfig.Store(nil)
+	})
+}
+
+func TestLogin(t *testing.T) {
+	known := newTestSigner(t, false)
+	unknown := newTestSigner(t, false)
+	pubKey := string(gossh.MarshalAuthorizedKey(known.PublicKey()))
+	authenticator := auth.New(
+		[]*auth.PubKeyAuth{{PubKey: pubKey, Alias: "alice"}},
+		[]*auth.PasswordAuth{{Username: "bob", Password: "secret", Alias: "bob"}},
+	)
+	server := newSessionServer("", "webs.sh", newHostKeys([]gossh.Signer{newTestSigner(t, false)}), 80, authenticator, newSSHLimits(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	password := func(answers ...string) gossh.AuthMethod {
+		return gossh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+			return answers, nil
+		})
+	}
+	refuse := gossh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+		return nil, errors.New("no answer")
+	})
+
+	tests := []struct {
+		name   string
+		access AccessConfig
+		user   string
+		auth   []gossh.AuthMethod
+		want   bool
+	}{
+		{"configured key", AccessConfig{}, "alice", []gossh.AuthMethod{gossh.PublicKeys(known)}, true},
+		{"unknown key", AccessConfig{}, "alice", []gossh.AuthMethod{gossh.PublicKeys(unknown)}, false},
+		{"password", AccessConfig{}, "bob", []gossh.AuthMethod{password("secret")}, true},
+		{"wrong password", AccessConfig{Anonymous: true}, "bob", []gossh.AuthMethod{password("guess")}, false},
+		{"password of another user", AccessConfig{Anonymous: true}, "carol", []gossh.AuthMethod{password("secret")}, false},
+		{"empty password of a password user", AccessConfig{Anonymous: true}, "bob", []gossh.AuthMethod{password("")}, false},
+		{"anonymous disabled", AccessConfig{}, "carol", []gossh.AuthMethod{password("")}, false},
+		{"anonymous", AccessConfig{Anonymous: true}, "carol", []gossh.AuthMethod{password("")}, true},
+		{"anonymous key", AccessConfig{Anonymous: true}, "carol", []gossh.AuthMethod{gossh.PublicKeys(unknown)}, true},
+		{"unknown key of a password user", AccessConfig{Anonymous: true}, "bob", []gossh.AuthMethod{gossh.PublicKeys(unknown)}, false},
+		{"password after unknown key", AccessConfig{Anonymous: true}, "bob", []gossh.AuthMethod{gossh.PublicKeys(unknown), password("secret")}, true},
+		{"no answers", AccessConfig{Anonymous: true}, "carol", []gossh.AuthMethod{password()}, false},
+		{"too many answers", AccessConfig{Anonymous: true}, "bob", []gossh.AuthMethod{password("secret", "secret")}, false},
+		{"challenge refused", AccessConfig{Anonymous: true}, "carol", []gossh.AuthMethod{refuse}, false},
+		{"register disabled", AccessConfig{Anonymous: true}, "register", []gossh.AuthMethod{gossh.PublicKeys(unknown)}, false},
+		{"register disabled by password", AccessConfig{Anonymous: true}, "register", []gossh.AuthMethod{password("")}, false},
+		{"register", AccessConfig{Register: true}, "register", []gossh.AuthMethod{gossh.PublicKeys(unknown)}, true},
+		{"register by password", AccessConfig{Register: true}, "register", []gossh.AuthMethod{password("")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowAccess(t, tt.access)
+			client, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+				User:            tt.user,
+				Auth:            tt.auth,
+				HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+				Timeout:         5 * time.Second,
+			})
+			if nil == err {
+				client.Close()
+			}
+			if got := nil == err; got != tt.want {
+				t.Errorf("login = %v, want %v: %v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoginWithoutAuth(t *testing.T) {
+	allowAccess(t, AccessConfig{Anonymous: true})
+	server := newSessionServer("", "webs.sh", newHostKeys([]gossh.Signer{newTestSigner(t, false)}), 80, nil, newSSHLimits(nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	tests := []struct {
+		user   string
+		answer string
+		want   bool
+	}{
+		{"carol", "", true},
+		{"carol", "secret", false},
+		{registerUser, "", false},
+	}
+	for _, tt := range tests {
+		client, err := gossh.Dial("tcp", ln.Addr().String(), &gossh.ClientConfig{
+			User: tt.user,
+			Auth: []gossh.AuthMethod{gossh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+				return []string{tt.answer}, nil
+			})},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+		if nil == err {
+			client.Close()
+		}
+		if got := nil == err; got != tt.want {
+			t.Errorf("login of %s with %q = %v, want %v: %v", tt.user, tt.answer, got, tt.want, err)
+		}
+	}
+}
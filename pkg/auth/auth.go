@@ -3,10 +3,29 @@ package auth
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	gossh "golang.org/x/crypto/ssh"
+	"regexp"
+	"sync/atomic"
 )
 
+var (
+	aliasPattern     = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	requestedPattern = regexp.MustCompile(`^[a-z0-9]{6,20}$`)
+)
+
+// ValidAlias reports whether alias can be used as a DNS label of the tunnel domain
+func ValidAlias(alias string) bool {
+	return aliasPattern.MatchString(alias)
+}
+
+// ValidRequested reports whether a client may ask for alias itself, the stricter
+// rule keeps short and hyphenated names for configured aliases
+func ValidRequested(alias string) bool {
+	return requestedPattern.MatchString(alias)
+}
+
 type Auth interface {
 	PubKey(gossh.PublicKey) (string, bool)
 	Password(user, password string) (string, bool)
@@ -15,6 +34,7 @@ type Auth interface {
 type PubKeyAuth struct {
 	PubKey string `json:"pubKey"`
 	Alias  string `json:"alias"`
+	Admin  bool   `json:"admin"` // may open the admin dashboard
 }
 
 type PasswordAuth struct {
@@ -23,14 +43,59 @@ type PasswordAuth struct {
 	Alias    string `json:"alias"`
 }
 
+func (p *PubKeyAuth) Validate() error {
+	var errs []error
+	if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(p.PubKey)); nil != err {
+		errs = append(errs, fmt.Errorf("pubKey: not an authorized_keys line: %v", err))
+	}
+	if !ValidAlias(p.Alias) {
+		errs = append(errs, fmt.Errorf("alias: %q is not a lowercase DNS label", p.Alias))
+	}
+	return errors.Join(errs...)
+}
+
+func (p *PasswordAuth) Validate() error {
+	var errs []error
+	if "" == p.Username {
+		errs = append(errs, errors.New("username: required"))
+	}
+	if "" == p.Password {
+		errs = append(errs, errors.New("password: required"))
+	}
+	if !ValidAlias(p.Alias) {
+		errs = append(errs, fmt.Errorf("alias: %q is not a lowercase DNS label", p.Alias))
+	}
+	return errors.Join(errs...)
+}
+
+// AdminAuth is implemented by an Auth that marks keys as administrators
+type AdminAuth interface {
+	IsAdmin(gossh.PublicKey) bool
+}
+
+// PasswordUsers is implemented by an Auth that knows which usernames log in with a password
+type PasswordUsers interface {
+	HasPassword(user string) bool
+}
+
+// AliasOwners is implemented by an Auth that knows which aliases are configured
+type AliasOwners interface {
+	HasAlias(alias string) bool
+}
+
 type DefaultAuth struct {
 	pubKeyMap   map[string]string
 	passwordMap map[string]string
+	adminKeys   map[string]bool
+	users       map[string]bool
+	aliases     map[string]bool
 }
 
 func New(keys []*PubKeyAuth, pwd []*PasswordAuth) *DefaultAuth {
 	a := &DefaultAuth{}
 	a.pubKeyMap = make(map[string]string)
+	a.adminKeys = make(map[string]bool)
+	a.aliases = make(map[string]bool)
 	for _, item := range keys {
 		out, _, _, _, err := gossh.ParseAuthorizedKey([]byte(item.PubKey))
 		if nil != err {
@@ -39,11 +104,18 @@ func New(keys []*PubKeyAuth, pwd []*PasswordAuth) *DefaultAuth {
 		hash := sha256.Sum256(out.Marshal())
 		k := hex.EncodeToString(hash[:])
 		a.pubKeyMap[k] = item.Alias
+		a.aliases[item.Alias] = true
+		if item.Admin {
+			a.adminKeys[k] = true
+		}
 	}
 
 	a.passwordMap = make(map[string]string)
+	a.users = make(map[string]bool)
 	for _, item := range pwd {
 		a.passwordMap[fmt.Sprintf("%s:%s", item.Username, item.Password)] = item.Alias
+		a.users[item.Username] = true
+		a.aliases[item.Alias] = true
 	}
 	return a
 }
@@ -55,8 +127,91 @@ func (d *DefaultAuth) PubKey(key gossh.PublicKey) (string, bool) {
 	return a, found
 }
 
+func (d *DefaultAuth) IsAdmin(key gossh.PublicKey) bool {
+	hash := sha256.Sum256(key.Marshal())
+	return d.adminKeys[hex.EncodeToString(hash[:])]
+}
+
 func (d *DefaultAuth) Password(user, password string) (string, bool) {
 	key := fmt.Sprintf("%s:%s", user, password)
 	a, found := d.passwordMap[key]
 	return a, found
 }
+
+func (d *DefaultAuth) HasPassword(user string) bool {
+	return d.users[user]
+}
+
+func (d *DefaultAuth) HasAlias(alias string) bool {
+	return d.aliases[alias]
+}
+
+// Reloader is implemented by an Auth whose credentials can be reloaded at runtime
+type Reloader interface {
+	Reload() error
+}
+
+// Loader builds the credentials of a Dynamic auth
+type Loader func() (Auth, error)
+
+type holder struct {
+	Auth
+}
+
+// Dynamic is an Auth whose credentials are replaced on Reload
+type Dynamic struct {
+	loader  Loader
+	current atomic.Pointer[holder]
+}
+
+func NewDynamic(loader Loader) (*Dynamic, error) {
+	d := &Dynamic{loader: loader}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload replaces the credentials, the current ones are kept when loading fails
+func (d *Dynamic) Reload() error {
+	a, err := d.loader()
+	if err != nil {
+		return err
+	}
+	d.Set(a)
+	return nil
+}
+
+// Set replaces the credentials with a, such as ones built from an already validated config
+func (d *Dynamic) Set(a Auth) {
+	d.current.Store(&holder{a})
+}
+
+func (d *Dynamic) PubKey(key gossh.PublicKey) (string, bool) {
+	return d.current.Load().PubKey(key)
+}
+
+func (d *Dynamic) Password(user, password string) (string, bool) {
+	return d.current.Load().Password(user, password)
+}
+
+func (d *Dynamic) HasPassword(user string) bool {
+	if p, ok := d.current.Load().Auth.(PasswordUsers); ok {
+		return p.HasPassword(user)
+	}
+	return false
+}
+
+func (d *Dynamic) HasAlias(alias string) bool {
+	if a, ok := d.current.Load().Auth.(AliasOwners); ok {
+		return a.HasAlias(alias)
+	}
+	return false
+}
+
+func (d *Dynamic) IsAdmin(key gossh.PublicKey) bool {
+	if a, ok := d.current.Load().Auth.(AdminAuth); ok {
+		return a.IsAdmin(key)
+	}
+	return false
+}
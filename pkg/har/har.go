@@ -0,0 +1,287 @@
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	Version     = "1.2"
+	CreatorName = "Echogy"
+)
+
+// HAR is the root object of an HTTP Archive, see http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log *Log `json:"log"`
+}
+
+type Log struct {
+	Version string   `json:"version"`
+	Creator *Creator `json:"creator"`
+	Pages   []*Page  `json:"pages,omitempty"`
+	Entries []*Entry `json:"entries"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Page struct {
+	StartedDateTime string `json:"startedDateTime"`
+	Id              string `json:"id"`
+	Title           string `json:"title"`
+}
+
+type Entry struct {
+	Pageref         string    `json:"pageref,omitempty"`
+	StartedDateTime string    `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         *Request  `json:"request"`
+	Response        *Response `json:"response"`
+	Cache           *Cache    `json:"cache"`
+	Timings         *Timings  `json:"timings"`
+	ServerIPAddress string    `json:"serverIPAddress,omitempty"`
+	Connection      string    `json:"connection,omitempty"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Request struct {
+	Method      string       `json:"method"`
+	Url         string       `json:"url"`
+	HttpVersion string       `json:"httpVersion"`
+	Cookies     []*Cookie    `json:"cookies"`
+	Headers     []*NameValue `json:"headers"`
+	QueryString []*NameValue `json:"queryString"`
+	PostData    *PostData    `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HttpVersion string       `json:"httpVersion"`
+	Cookies     []*Cookie    `json:"cookies"`
+	Headers     []*NameValue `json:"headers"`
+	Content     *Content     `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type PostData struct {
+	MimeType string       `json:"mimeType"`
+	Params   []*NameValue `json:"params"`
+	Text     string       `json:"text"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type Cache struct{}
+
+// Timings are in milliseconds, -1 means the phase does not apply
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// New creates an empty archive
+func New(creatorVersion string) *HAR {
+	return &HAR{
+		Log: &Log{
+			Version: Version,
+			Creator: &Creator{
+				Name:    CreatorName,
+				Version: creatorVersion,
+			},
+			Entries: make([]*Entry, 0),
+		},
+	}
+}
+
+// Append converts captured request entities into archive entries
+func (h *HAR) Append(entities ...*stat.RequestEntity) {
+	for _, e := range entities {
+		if nil == e || nil == e.Request || nil == e.Response {
+			continue
+		}
+		h.Log.Entries = append(h.Log.Entries, newEntry(e))
+	}
+}
+
+// Encode writes the archive as indented JSON
+func (h *HAR) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(h)
+}
+
+func newEntry(e *stat.RequestEntity) *Entry {
+	return &Entry{
+		StartedDateTime: e.StartTime.Format(time.RFC3339Nano),
+		Time:            float64(e.UseTime),
+		Request:         newRequest(e.Request, e.RequestBody),
+		Response:        newResponse(e.Response, e.ResponseBody),
+		Cache:           &Cache{},
+		Timings: &Timings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			Send:    0,
+			Wait:    float64(e.TTFB),
+			Receive: float64(max(e.UseTime-e.TTFB, 0)),
+			SSL:     -1,
+		},
+	}
+}
+
+func headers(h http.Header) []*NameValue {
+	nv := make([]*NameValue, 0, len(h))
+	for k, values := range h {
+		for _, v := range values {
+			nv = append(nv, &NameValue{Name: k, Value: v})
+		}
+	}
+	return nv
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if proto := r.Header.Get("X-Forwarded-Proto"); "" != proto {
+		scheme = proto
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   r.Host,
+	}
+	if nil != r.URL {
+		u.Path = r.URL.Path
+		u.RawPath = r.URL.RawPath
+		u.RawQuery = r.URL.RawQuery
+	}
+	return u.String()
+}
+
+func newRequest(r *http.Request, body []byte) *Request {
+	req := &Request{
+		Method:      r.Method,
+		Url:         requestURL(r),
+		HttpVersion: r.Proto,
+		Cookies:     make([]*Cookie, 0),
+		Headers:     headers(r.Header),
+		QueryString: make([]*NameValue, 0),
+		HeadersSize: -1,
+		BodySize:    r.ContentLength,
+	}
+	req.Headers = append(req.Headers, &NameValue{Name: "Host", Value: r.Host})
+	for _, c := range r.Cookies() {
+		req.Cookies = append(req.Cookies, &Cookie{Name: c.Name, Value: c.Value})
+	}
+	if nil != r.URL {
+		for k, values := range r.URL.Query() {
+			for _, v := range values {
+				req.QueryString = append(req.QueryString, &NameValue{Name: k, Value: v})
+			}
+		}
+	}
+	if len(body) > 0 {
+		req.PostData = &PostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Params:   make([]*NameValue, 0),
+			Text:     string(body),
+		}
+	}
+	return req
+}
+
+func newResponse(w *http.Response, body []byte) *Response {
+	resp := &Response{
+		Status:      w.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(w.Status, fmt.Sprint(w.StatusCode))),
+		HttpVersion: w.Proto,
+		Cookies:     make([]*Cookie, 0),
+		Headers:     headers(w.Header),
+		RedirectURL: w.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    w.ContentLength,
+		Content: &Content{
+			Size:     int64(len(body)),
+			MimeType: w.Header.Get("Content-Type"),
+		},
+	}
+	if "" == resp.StatusText {
+		resp.StatusText = http.StatusText(w.StatusCode)
+	}
+	if w.ContentLength >= 0 {
+		resp.Content.Size = w.ContentLength
+	}
+	for _, c := range w.Cookies() {
+		resp.Cookies = append(resp.Cookies, &Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HttpOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		})
+	}
+	if len(body) > 0 {
+		if isText(resp.Content.MimeType) && utf8.Valid(body) {
+			resp.Content.Text = string(body)
+		} else {
+			resp.Content.Text = base64.StdEncoding.EncodeToString(body)
+			resp.Content.Encoding = "base64"
+		}
+	}
+	return resp
+}
+
+func isText(contentType string) bool {
+	if "" == contentType {
+		return true
+	}
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediatype, "text/") ||
+		strings.Contains(mediatype, "json") ||
+		strings.Contains(mediatype, "xml") ||
+		strings.Contains(mediatype, "javascript") ||
+		mediatype == "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,107 @@
+package har
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEntity(t *testing.T, rawReq, rawResp string, reqBody, respBody []byte) *stat.RequestEntity {
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(rawReq)))
+	if err != nil {
+		t.Fatalf("ReadRequest() error = %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(rawResp)), req)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	return &stat.RequestEntity{
+		Request:      req,
+		Response:     resp,
+		UseTime:      42,
+		TTFB:         30,
+		StartTime:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		RequestBody:  reqBody,
+		ResponseBody: respBody,
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	e := newEntity(t,
+		"POST /api/users?page=2 HTTP/1.1\r\nHost: demo.webs.sh\r\nContent-Type: application/json\r\nCookie: sid=abc\r\nContent-Length: 13\r\n\r\n{\"name\":\"x\"}\n",
+		"HTTP/1.1 201 Created\r\nContent-Type: application/json\r\nContent-Length: 8\r\n\r\n{\"id\":1}",
+		[]byte("{\"name\":\"x\"}\n"), []byte("{\"id\":1}"))
+
+	h := New("test")
+	h.Append(e, nil)
+
+	var buf bytes.Buffer
+	if err := h.Encode(&buf); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var decoded HAR
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if decoded.Log.Version != Version {
+		t.Errorf("version = %v, want %v", decoded.Log.Version, Version)
+	}
+	if len(decoded.Log.Entries) != 1 {
+		t.Fatalf("entries = %v, want 1", len(decoded.Log.Entries))
+	}
+
+	entry := decoded.Log.Entries[0]
+	if entry.StartedDateTime != "2024-01-02T03:04:05Z" {
+		t.Errorf("startedDateTime = %v", entry.StartedDateTime)
+	}
+	if entry.Time != 42 || entry.Timings.Wait != 30 || entry.Timings.Receive != 12 {
+		t.Errorf("time = %v, wait = %v, receive = %v", entry.Time, entry.Timings.Wait, entry.Timings.Receive)
+	}
+	if entry.Request.Url != "http://demo.webs.sh/api/users?page=2" {
+		t.Errorf("url = %v", entry.Request.Url)
+	}
+	if len(entry.Request.QueryString) != 1 || entry.Request.QueryString[0].Value != "2" {
+		t.Errorf("queryString = %v", entry.Request.QueryString)
+	}
+	if len(entry.Request.Cookies) != 1 || entry.Request.Cookies[0].Name != "sid" {
+		t.Errorf("cookies = %v", entry.Request.Cookies)
+	}
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != "{\"name\":\"x\"}\n" {
+		t.Errorf("postData = %v", entry.Request.PostData)
+	}
+	if entry.Response.Status != 201 || entry.Response.StatusText != "Created" {
+		t.Errorf("status = %v %v", entry.Response.Status, entry.Response.StatusText)
+	}
+	if entry.Response.Content.Text != "{\"id\":1}" || entry.Response.Content.Encoding != "" {
+		t.Errorf("content = %+v", entry.Response.Content)
+	}
+}
+
+func TestExportBinaryBody(t *testing.T) {
+	body := []byte{0x89, 'P', 'N', 'G', 0xff}
+	e := newEntity(t,
+		"GET /logo.png HTTP/1.1\r\nHost: demo.webs.sh\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: 5\r\n\r\n"+string(body),
+		nil, body)
+
+	h := New("test")
+	h.Append(e)
+
+	content := h.Log.Entries[0].Response.Content
+	if content.Encoding != "base64" {
+		t.Fatalf("encoding = %v, want base64", content.Encoding)
+	}
+	if content.Text != base64.StdEncoding.EncodeToString(body) {
+		t.Errorf("text = %v", content.Text)
+	}
+	if h.Log.Entries[0].Request.PostData != nil {
+		t.Errorf("postData = %v, want nil", h.Log.Entries[0].Request.PostData)
+	}
+}
@@ -0,0 +1,215 @@
+// Package limit bounds how often and how many times a key, such as a client IP
+// or public key fingerprint, may use a resource.
+package limit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// sweepEvery is the number of Allow calls between removals of idle buckets
+const sweepEvery = 1024
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Rate is a token bucket per key, refilled at perSecond up to burst tokens
+type Rate struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*bucket
+	calls     int
+	now       func() time.Time
+}
+
+// NewRate returns a limiter allowing burst events at once and perSecond on average,
+// a nil limiter allows everything
+func NewRate(perSecond float64, burst int) *Rate {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Rate{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+// SetRate changes the refill rate and burst, buckets keep their tokens up to burst
+func (r *Rate) SetRate(perSecond float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for _, b := range r.buckets {
+		r.refill(b, now)
+		b.tokens = min(b.tokens, float64(burst))
+	}
+	r.perSecond = perSecond
+	r.burst = float64(burst)
+}
+
+func (r *Rate) refill(b *bucket, now time.Time) {
+	b.tokens = min(r.burst, b.tokens+now.Sub(b.at).Seconds()*r.perSecond)
+	b.at = now
+}
+
+// Allow takes a token of key and reports whether one was left
+func (r *Rate) Allow(key string) bool {
+	if nil == r {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.calls++
+	if r.calls >= sweepEvery {
+		r.calls = 0
+		r.sweep(now)
+	}
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: r.burst, at: now}
+		r.buckets[key] = b
+	}
+	r.refill(b, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets buckets that refilled completely, they behave like new ones
+func (r *Rate) sweep(now time.Time) {
+	for key, b := range r.buckets {
+		r.refill(b, now)
+		if b.tokens >= r.burst {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// Concurrent counts the holders of each key up to max
+type Concurrent struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+// NewConcurrent returns a counter admitting max holders per key,
+// a nil counter admits everyone
+func NewConcurrent(max int) *Concurrent {
+	return &Concurrent{max: max, counts: make(map[string]int)}
+}
+
+// SetMax changes the holders admitted per key, current holders are kept
+func (c *Concurrent) SetMax(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+}
+
+// Acquire adds a holder of key unless it already has max, the caller must Release it
+func (c *Concurrent) Acquire(key string) bool {
+	if nil == c {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[key] >= c.max {
+		return false
+	}
+	c.counts[key]++
+	return true
+}
+
+// Release removes a holder added by Acquire
+func (c *Concurrent) Release(key string) {
+	if nil == c {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[key] <= 1 {
+		delete(c.counts, key)
+		return
+	}
+	c.counts[key]--
+}
+
+// Count returns the holders of key
+func (c *Concurrent) Count(key string) int {
+	if nil == c {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[key]
+}
+
+// Bandwidth paces byte streams to perSecond on average, shared by all its readers
+type Bandwidth struct {
+	mu        sync.Mutex
+	perSecond float64
+	b         bucket
+	now       func() time.Time
+	sleep     func(time.Duration)
+}
+
+// NewBandwidth returns a pacer allowing a second worth of bytes at once,
+// a nil pacer does not slow anything down
+func NewBandwidth(perSecond int64) *Bandwidth {
+	return &Bandwidth{
+		perSecond: float64(perSecond),
+		b:         bucket{tokens: float64(perSecond), at: time.Now()},
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}
+}
+
+// take spends n bytes and returns how long to wait until they are earned
+func (b *Bandwidth) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.b.tokens = min(b.perSecond, b.b.tokens+now.Sub(b.b.at).Seconds()*b.perSecond)
+	b.b.at = now
+	b.b.tokens -= float64(n)
+	if b.b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.b.tokens / b.perSecond * float64(time.Second))
+}
+
+// Reader paces reads of r
+func (b *Bandwidth) Reader(r io.Reader) io.Reader {
+	if nil == b {
+		return r
+	}
+	return &pacedReader{r: r, b: b}
+}
+
+type pacedReader struct {
+	r io.Reader
+	b *Bandwidth
+}
+
+func (p *pacedReader) Read(buf []byte) (int, error) {
+	// reading at most a second worth of bytes keeps the pace smooth
+	if limit := max(int(p.b.perSecond), 1); len(buf) > limit {
+		buf = buf[:limit]
+	}
+	n, err := p.r.Read(buf)
+	if wait := p.b.take(n); wait > 0 {
+		p.b.sleep(wait)
+	}
+	return n, err
+}
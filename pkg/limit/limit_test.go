@@ -0,0 +1,133 @@
+package limit
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewRate(0.5, 2)
+	r.now = func() time.Time { return now }
+
+	tests := []struct {
+		advance time.Duration
+		key     string
+		want    bool
+	}{
+		{0, "a", true},
+		{0, "a", true},
+		{0, "a", false},
+		{0, "b", true},
+		{time.Second, "a", false},
+		{time.Second, "a", true},
+		{0, "a", false},
+		{time.Minute, "a", true},
+		{0, "a", true},
+		{0, "a", false},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		if got := r.Allow(tt.key); got != tt.want {
+			t.Errorf("step %d: Allow(%q) = %v, want %v", i, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRateSetRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewRate(1, 3)
+	r.now = func() time.Time { return now }
+	r.Allow("a")
+
+	r.SetRate(0.5, 1)
+	if !r.Allow("a") {
+		t.Fatal("Allow() = false, the bucket keeps its tokens up to the new burst")
+	}
+	if r.Allow("a") || r.Allow("b") && r.Allow("b") {
+		t.Error("Allow() beyond the new burst = true")
+	}
+	now = now.Add(time.Second)
+	if r.Allow("a") {
+		t.Error("Allow() = true before the new rate refilled a token")
+	}
+	now = now.Add(time.Second)
+	if !r.Allow("a") {
+		t.Error("Allow() = false after the new rate refilled a token")
+	}
+}
+
+func TestRateSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewRate(1, 1)
+	r.now = func() time.Time { return now }
+	for i := 0; i < sweepEvery-1; i++ {
+		r.Allow(strconv.Itoa(i))
+	}
+	now = now.Add(time.Second)
+	r.Allow("last")
+	if 1 != len(r.buckets) {
+		t.Errorf("%d buckets after sweep, want 1", len(r.buckets))
+	}
+}
+
+func TestNilLimiters(t *testing.T) {
+	var r *Rate
+	var c *Concurrent
+	if !r.Allow("a") || !c.Acquire("a") {
+		t.Error("nil limiters must allow everything")
+	}
+	c.Release("a")
+}
+
+func TestConcurrent(t *testing.T) {
+	c := NewConcurrent(2)
+	if !c.Acquire("a") || !c.Acquire("a") {
+		t.Fatal("Acquire below max failed")
+	}
+	if c.Acquire("a") {
+		t.Error("Acquire above max succeeded")
+	}
+	if !c.Acquire("b") {
+		t.Error("keys must be counted separately")
+	}
+	c.Release("a")
+	if !c.Acquire("a") {
+		t.Error("Acquire after Release failed")
+	}
+	c.Release("a")
+	c.Release("a")
+	if 0 != c.Count("a") || 0 != len(c.counts)-1 {
+		t.Errorf("Count(a) = %d, %d keys left", c.Count("a"), len(c.counts))
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	b := NewBandwidth(100)
+	b.b.at = now
+	b.now = func() time.Time { return now }
+	b.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	data := bytes.Repeat([]byte("x"), 450)
+	n, err := io.Copy(io.Discard, b.Reader(bytes.NewReader(data)))
+	if err != nil || int64(len(data)) != n {
+		t.Fatalf("copied %d bytes: %v", n, err)
+	}
+	// the first 100 bytes are free, the other 350 take 3.5s
+	if want := 3500 * time.Millisecond; slept != want {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+
+	var unpaced *Bandwidth
+	if r := bytes.NewReader(data); unpaced.Reader(r) != r {
+		t.Error("nil Bandwidth must not wrap readers")
+	}
+}
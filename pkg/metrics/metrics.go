@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	namespace = "echogy"
+	// OtherAlias replaces aliases beyond the cardinality limit
+	OtherAlias = "_other"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	ForwardsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forwards_active",
+		Help:      "Forwarded channels currently open between the facade and SSH clients.",
+	})
+
+	ForwardsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwards_total",
+		Help:      "Forwarded channels opened to SSH clients.",
+	})
+
+	FacadeConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "facade_connections_total",
+		Help:      "Connections accepted by the facade listener by outcome.",
+	}, []string{"result"})
+
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests proxied through tunnels by response status class.",
+	}, []string{"alias", "class"})
+
+	TunnelBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_bytes_total",
+		Help:      "Bytes copied through tunnels, in is visitor to client and out is client to visitor.",
+	}, []string{"alias", "direction"})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected SSH authentication attempts by method.",
+	}, []string{"method"})
+
+	SSHRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_rejected_total",
+		Help:      "SSH connections refused by the connection limits and bans by reason.",
+	}, []string{"reason"})
+
+	KeepaliveFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keepalive_failures_total",
+		Help:      "Keepalive requests to SSH clients that failed.",
+	})
+
+	HandshakeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ssh_handshake_duration_seconds",
+		Help:      "Time from accepting an SSH connection to its first request or channel.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ForwardsActive,
+		ForwardsTotal,
+		FacadeConnections,
+		Requests,
+		TunnelBytes,
+		AuthFailures,
+		SSHRejected,
+		KeepaliveFailures,
+		HandshakeDuration,
+	)
+}
+
+// RegisterSessions exposes the number of live SSH sessions as reported by count
+func RegisterSessions(count func() int) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ssh_sessions",
+		Help:      "Live SSH sessions serving a tunnel.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+var aliases = struct {
+	sync.Mutex
+	enabled bool
+	limit   int
+	seen    map[string]struct{}
+}{seen: make(map[string]struct{})}
+
+// SetAliasLabel enables the alias label for at most limit distinct aliases
+func SetAliasLabel(enabled bool, limit int) {
+	aliases.Lock()
+	defer aliases.Unlock()
+	aliases.enabled = enabled
+	aliases.limit = limit
+}
+
+// Alias returns the label value for alias, empty when the label is disabled
+// and OtherAlias once the cardinality limit is reached
+func Alias(alias string) string {
+	aliases.Lock()
+	defer aliases.Unlock()
+	if !aliases.enabled {
+		return ""
+	}
+	if _, ok := aliases.seen[alias]; ok {
+		return alias
+	}
+	if len(aliases.seen) >= aliases.limit {
+		return OtherAlias
+	}
+	aliases.seen[alias] = struct{}{}
+	return alias
+}
+
+// StatusClass returns the label value of a status code such as "2xx"
+func StatusClass(status int) string {
+	if status < 100 || status >= 600 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	SetAliasLabel(false, 2)
+	if got := Alias("a"); got != "" {
+		t.Errorf("Alias() disabled = %q, want empty", got)
+	}
+
+	SetAliasLabel(true, 2)
+	tests := []struct {
+		alias string
+		want  string
+	}{
+		{alias: "a", want: "a"},
+		{alias: "b", want: "b"},
+		{alias: "c", want: OtherAlias},
+		{alias: "a", want: "a"},
+	}
+	for _, tt := range tests {
+		if got := Alias(tt.alias); got != tt.want {
+			t.Errorf("Alias(%q) = %q, want %q", tt.alias, got, tt.want)
+		}
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{status: 200, want: "2xx"},
+		{status: 404, want: "4xx"},
+		{status: 503, want: "5xx"},
+		{status: 0, want: "other"},
+		{status: 600, want: "other"},
+	}
+	for _, tt := range tests {
+		if got := StatusClass(tt.status); got != tt.want {
+			t.Errorf("StatusClass(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	RegisterSessions(func() int { return 3 })
+	ForwardsTotal.Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{"echogy_ssh_sessions 3", "echogy_forwards_total 1"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
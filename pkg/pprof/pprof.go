@@ -2,20 +2,24 @@ package pprof
 
 import (
 	"github.com/echogy-io/echogy/pkg/logger"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 )
 
-const addr = "localhost:9191"
+// Addr is where the pprof server listens
+const Addr = "localhost:9191"
 
 var fields = map[string]interface{}{
 	"module": "pprof",
-	"listen": addr,
+	"listen": Addr,
 }
 
-func Serve() {
+// Serve serves the profiling handlers on ln, which is handed over on upgrade like
+// the other listeners
+func Serve(ln net.Listener) {
 	logger.Warn("starting pprof server", fields)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := http.Serve(ln, nil); err != nil {
 		logger.Error("pprof server failed", err, fields)
 	}
 }
@@ -0,0 +1,423 @@
+package stat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const historyFileExt = ".jsonl"
+
+// HistoryOptions bounds the request history kept for each session
+type HistoryOptions struct {
+	MaxEntries    int           // entries kept per session
+	MaxBytes      int64         // approximate bytes kept per session, 0 means unlimited
+	MaxAge        time.Duration // entries older than this are dropped, 0 means unlimited
+	MaxTotalBytes int64         // approximate bytes kept across all sessions, oldest entries go first, 0 means unlimited
+	Dir           string        // directory for per alias persistence, empty disables it
+}
+
+var (
+	historyOptions atomic.Pointer[HistoryOptions]
+	// totalBytes tracks the retained bytes of all live histories
+	totalBytes atomic.Int64
+	// histories holds the live histories with entries, trimmed together to MaxTotalBytes
+	histories sync.Map
+	// trimTotalMu serializes evictions across histories
+	trimTotalMu sync.Mutex
+)
+
+func init() {
+	SetHistoryOptions(HistoryOptions{MaxEntries: 52})
+}
+
+// SetHistoryOptions replaces the retention policy, it applies on the next push
+func SetHistoryOptions(o HistoryOptions) {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = 52
+	}
+	historyOptions.Store(&o)
+}
+
+func getHistoryOptions() *HistoryOptions {
+	return historyOptions.Load()
+}
+
+// History is the request history of a session, oldest entry first
+type History struct {
+	mu    sync.Mutex
+	items []*RequestEntity
+	bytes int64
+	path  string
+	file  *os.File
+	// fileLines counts the records in file, retained or not
+	fileLines int
+	closed    bool
+}
+
+func newHistory() *History {
+	return &History{}
+}
+
+// Size approximates the memory held by the entity
+func (e *RequestEntity) Size() int64 {
+	size := int64(len(e.RequestBody) + len(e.ResponseBody))
+	if nil != e.Request {
+		size += int64(len(e.Request.RequestURI) + len(e.Request.Host))
+		size += headerSize(e.Request.Header)
+	}
+	if nil != e.Response {
+		size += headerSize(e.Response.Header)
+	}
+	return size
+}
+
+func headerSize(h http.Header) int64 {
+	var size int64
+	for k, values := range h {
+		for _, v := range values {
+			size += int64(len(k) + len(v))
+		}
+	}
+	return size
+}
+
+// Push appends e, evicting old entries beyond the retention policy
+func (h *History) Push(e *RequestEntity) {
+	h.push(e)
+	trimTotal()
+}
+
+func (h *History) push(e *RequestEntity) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// a closed history is gone, its entries would never be released
+	if h.closed {
+		return
+	}
+	h.items = append(h.items, e)
+	size := e.Size()
+	h.bytes += size
+	totalBytes.Add(size)
+	h.trimLocked(time.Now())
+	if nil != h.file {
+		h.appendLocked(e)
+	}
+	h.registerLocked()
+}
+
+// registerLocked adds the history to those trimmed to MaxTotalBytes
+func (h *History) registerLocked() {
+	if !h.closed {
+		histories.Store(h, struct{}{})
+	}
+}
+
+func (h *History) evictLocked() {
+	size := h.items[0].Size()
+	h.items[0] = nil
+	h.items = h.items[1:]
+	h.bytes -= size
+	totalBytes.Add(-size)
+}
+
+func (h *History) trimLocked(now time.Time) {
+	o := getHistoryOptions()
+	for len(h.items) > 0 {
+		switch {
+		case len(h.items) > o.MaxEntries:
+		case o.MaxBytes > 0 && h.bytes > o.MaxBytes:
+		case o.MaxAge > 0 && now.Sub(h.items[0].StartTime) > o.MaxAge:
+		default:
+			return
+		}
+		h.evictLocked()
+	}
+}
+
+// trimTotal evicts the oldest entries across all histories until they fit
+// MaxTotalBytes, so idle sessions give way to busy ones. Each history keeps its
+// newest entry even when the budget is exhausted.
+func trimTotal() {
+	maxTotal := getHistoryOptions().MaxTotalBytes
+	if maxTotal <= 0 {
+		return
+	}
+	trimTotalMu.Lock()
+	defer trimTotalMu.Unlock()
+	for totalBytes.Load() > maxTotal {
+		oldest := oldestHistory()
+		if nil == oldest {
+			return
+		}
+		oldest.mu.Lock()
+		if len(oldest.items) > 1 {
+			oldest.evictLocked()
+		}
+		oldest.mu.Unlock()
+	}
+}
+
+// oldestHistory returns the history whose oldest evictable entry started first,
+// nil when no history has one
+func oldestHistory() *History {
+	var oldest *History
+	var start time.Time
+	histories.Range(func(key, _ interface{}) bool {
+		h := key.(*History)
+		h.mu.Lock()
+		if len(h.items) > 1 && (nil == oldest || h.items[0].StartTime.Before(start)) {
+			oldest, start = h, h.items[0].StartTime
+		}
+		h.mu.Unlock()
+		return true
+	})
+	return oldest
+}
+
+// Items returns the retained entries in chronological order (oldest to newest)
+func (h *History) Items() []*RequestEntity {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trimLocked(time.Now())
+	result := make([]*RequestEntity, len(h.items))
+	copy(result, h.items)
+	return result
+}
+
+// ReversedItems returns the retained entries in reverse chronological order (newest to oldest)
+func (h *History) ReversedItems() []*RequestEntity {
+	items := h.Items()
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+// Len returns the number of retained entries
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.items)
+}
+
+// Close releases the retained entries, a persisted history is compacted first
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var err error
+	if nil != h.file {
+		h.file.Close()
+		h.file = nil
+		h.trimLocked(time.Now())
+		err = h.writeLocked()
+	}
+	totalBytes.Add(-h.bytes)
+	h.items = nil
+	h.bytes = 0
+	h.closed = true
+	histories.Delete(h)
+	return err
+}
+
+// historyPath returns the persistence file of alias, empty when persistence is disabled
+func historyPath(alias string) string {
+	dir := getHistoryOptions().Dir
+	if "" == dir || "" == alias {
+		return ""
+	}
+	return filepath.Join(dir, filepath.Base(alias)+historyFileExt)
+}
+
+// persist loads the history of path and keeps appending new entries to it
+func (h *History) persist(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if nil != h.file {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	h.path = path
+	loaded, err := readRecords(path)
+	if err != nil {
+		return err
+	}
+	// restored entries are older than anything captured in this session
+	h.items = append(loaded, h.items...)
+	for _, e := range loaded {
+		size := e.Size()
+		h.bytes += size
+		totalBytes.Add(size)
+	}
+	h.trimLocked(time.Now())
+	h.registerLocked()
+	// compact the file so it only holds retained entries
+	if err = h.writeLocked(); err != nil {
+		return err
+	}
+	h.fileLines = len(h.items)
+	h.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+func (h *History) appendLocked(e *RequestEntity) {
+	data, err := json.Marshal(newRecord(e))
+	if err != nil {
+		return
+	}
+	h.file.Write(append(data, '\n'))
+	h.fileLines++
+	// evicted entries stay in the file, rewrite it once they outnumber the retained ones
+	if h.fileLines > 2*len(h.items) {
+		h.compactLocked()
+	}
+}
+
+// compactLocked rewrites the file with the retained entries, on failure it keeps
+// appending to the old file
+func (h *History) compactLocked() {
+	h.file.Close()
+	if nil == h.writeLocked() {
+		h.fileLines = len(h.items)
+	}
+	h.file, _ = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0600)
+}
+
+func (h *History) writeLocked() error {
+	tmp := h.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range h.items {
+		if err = enc.Encode(newRecord(e)); err != nil {
+			break
+		}
+	}
+	if nil == err {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); nil == err {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+func readRecords(path string) ([]*RequestEntity, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := make([]*RequestEntity, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var r record
+		// skip lines torn by a crash
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		items = append(items, r.entity())
+	}
+	return items, scanner.Err()
+}
+
+// record is the on-disk form of a RequestEntity
+type record struct {
+	StartTime      time.Time   `json:"startTime"`
+	UseTime        int64       `json:"useTime"`
+	TTFB           int64       `json:"ttfb"`
+	Method         string      `json:"method"`
+	URI            string      `json:"uri"`
+	Host           string      `json:"host"`
+	Proto          string      `json:"proto"`
+	RequestHeader  http.Header `json:"requestHeader"`
+	RequestLength  int64       `json:"requestLength"`
+	RequestBody    []byte      `json:"requestBody,omitempty"`
+	Status         int         `json:"status"`
+	StatusText     string      `json:"statusText"`
+	ResponseProto  string      `json:"responseProto"`
+	ResponseHeader http.Header `json:"responseHeader"`
+	ResponseLength int64       `json:"responseLength"`
+	ResponseBody   []byte      `json:"responseBody,omitempty"`
+}
+
+func newRecord(e *RequestEntity) *record {
+	return &record{
+		StartTime:      e.StartTime,
+		UseTime:        e.UseTime,
+		TTFB:           e.TTFB,
+		Method:         e.Request.Method,
+		URI:            e.Request.RequestURI,
+		Host:           e.Request.Host,
+		Proto:          e.Request.Proto,
+		RequestHeader:  e.Request.Header,
+		RequestLength:  e.Request.ContentLength,
+		RequestBody:    e.RequestBody,
+		Status:         e.Response.StatusCode,
+		StatusText:     e.Response.Status,
+		ResponseProto:  e.Response.Proto,
+		ResponseHeader: e.Response.Header,
+		ResponseLength: e.Response.ContentLength,
+		ResponseBody:   e.ResponseBody,
+	}
+}
+
+func (r *record) entity() *RequestEntity {
+	u, err := url.ParseRequestURI(r.URI)
+	if err != nil {
+		u = &url.URL{Path: r.URI}
+	}
+	req := &http.Request{
+		Method:        r.Method,
+		URL:           u,
+		RequestURI:    r.URI,
+		Host:          r.Host,
+		Proto:         r.Proto,
+		Header:        r.RequestHeader,
+		ContentLength: r.RequestLength,
+	}
+	if nil == req.Header {
+		req.Header = http.Header{}
+	}
+	resp := &http.Response{
+		Status:        r.StatusText,
+		StatusCode:    r.Status,
+		Proto:         r.ResponseProto,
+		Header:        r.ResponseHeader,
+		ContentLength: r.ResponseLength,
+		Request:       req,
+	}
+	if nil == resp.Header {
+		resp.Header = http.Header{}
+	}
+	return &RequestEntity{
+		Request:      req,
+		Response:     resp,
+		UseTime:      r.UseTime,
+		TTFB:         r.TTFB,
+		StartTime:    r.StartTime,
+		RequestBody:  r.RequestBody,
+		ResponseBody: r.ResponseBody,
+	}
+}
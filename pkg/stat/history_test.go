@@ -0,0 +1,243 @@
+package stat
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEntity(uri string, start time.Time, body string) *RequestEntity {
+	req := &http.Request{
+		Method:     "GET",
+		RequestURI: uri,
+		Host:       "demo.webs.sh",
+		Header:     http.Header{},
+	}
+	return &RequestEntity{
+		Request: req,
+		Response: &http.Response{
+			StatusCode: 200,
+			Status:     "200 OK",
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Request:    req,
+		},
+		StartTime:    start,
+		UseTime:      7,
+		ResponseBody: []byte(body),
+	}
+}
+
+func uris(items []*RequestEntity) []string {
+	result := make([]string, len(items))
+	for i, e := range items {
+		result[i] = e.RequestURI
+	}
+	return result
+}
+
+func withOptions(t *testing.T, o HistoryOptions) {
+	old := *getHistoryOptions()
+	SetHistoryOptions(o)
+	t.Cleanup(func() {
+		SetHistoryOptions(old)
+	})
+}
+
+func TestHistoryRetention(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		options HistoryOptions
+		entries []*RequestEntity
+		want    []string
+	}{
+		{
+			name:    "max entries",
+			options: HistoryOptions{MaxEntries: 2},
+			entries: []*RequestEntity{
+				newTestEntity("/1", now, ""),
+				newTestEntity("/2", now, ""),
+				newTestEntity("/3", now, ""),
+			},
+			want: []string{"/2", "/3"},
+		},
+		{
+			name:    "max bytes",
+			options: HistoryOptions{MaxEntries: 10, MaxBytes: 150},
+			entries: []*RequestEntity{
+				newTestEntity("/1", now, string(make([]byte, 100))),
+				newTestEntity("/2", now, string(make([]byte, 100))),
+			},
+			want: []string{"/2"},
+		},
+		{
+			name:    "max age",
+			options: HistoryOptions{MaxEntries: 10, MaxAge: time.Minute},
+			entries: []*RequestEntity{
+				newTestEntity("/1", now.Add(-time.Hour), ""),
+				newTestEntity("/2", now, ""),
+			},
+			want: []string{"/2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withOptions(t, tt.options)
+			h := newHistory()
+			defer h.Close()
+			for _, e := range tt.entries {
+				h.Push(e)
+			}
+			got := uris(h.Items())
+			if len(got) != len(tt.want) {
+				t.Fatalf("Items() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Items() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryTotalBytes(t *testing.T) {
+	withOptions(t, HistoryOptions{MaxEntries: 10, MaxTotalBytes: 250})
+	a := newHistory()
+	b := newHistory()
+	defer a.Close()
+	defer b.Close()
+
+	a.Push(newTestEntity("/a1", time.Now(), string(make([]byte, 100))))
+	a.Push(newTestEntity("/a2", time.Now(), string(make([]byte, 100))))
+	b.Push(newTestEntity("/b1", time.Now(), string(make([]byte, 100))))
+	b.Push(newTestEntity("/b2", time.Now(), string(make([]byte, 100))))
+
+	if got := b.Len(); got != 1 {
+		t.Errorf("b.Len() = %v, want 1", got)
+	}
+	a.Close()
+	if got := totalBytes.Load(); got != b.bytes {
+		t.Errorf("totalBytes = %v, want %v after close", got, b.bytes)
+	}
+}
+
+func TestHistoryPushAfterClose(t *testing.T) {
+	withOptions(t, HistoryOptions{MaxEntries: 10})
+	h := newHistory()
+	h.Push(newTestEntity("/a", time.Now(), "body"))
+	before := totalBytes.Load()
+	h.Close()
+	closed := totalBytes.Load()
+	if closed >= before {
+		t.Fatalf("totalBytes = %v after close, want below %v", closed, before)
+	}
+
+	// a dispatch racing the end of the session
+	h.Push(newTestEntity("/late", time.Now(), "body"))
+	if got := totalBytes.Load(); got != closed {
+		t.Errorf("totalBytes = %v after a late push, want %v", got, closed)
+	}
+	if got := h.Len(); 0 != got {
+		t.Errorf("Len() = %v after a late push, want 0", got)
+	}
+	if _, found := histories.Load(h); found {
+		t.Error("closed history registered again")
+	}
+}
+
+func TestHistoryTotalBytesAcrossSessions(t *testing.T) {
+	withOptions(t, HistoryOptions{MaxEntries: 10, MaxTotalBytes: 600})
+	idle := newHistory()
+	busy := newHistory()
+	defer idle.Close()
+	defer busy.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for i, uri := range []string{"/i1", "/i2", "/i3", "/i4"} {
+		idle.Push(newTestEntity(uri, start.Add(time.Duration(i)*time.Second), string(make([]byte, 100))))
+	}
+	for _, uri := range []string{"/b1", "/b2", "/b3"} {
+		busy.Push(newTestEntity(uri, time.Now(), string(make([]byte, 100))))
+	}
+
+	if got := uris(busy.Items()); 3 != len(got) {
+		t.Errorf("busy items = %v, want all three", got)
+	}
+	if got := uris(idle.Items()); 1 != len(got) || "/i4" != got[0] {
+		t.Errorf("idle items = %v, want only the newest", got)
+	}
+	if got := totalBytes.Load(); got > 600 {
+		t.Errorf("totalBytes = %v, want at most 600", got)
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	withOptions(t, HistoryOptions{MaxEntries: 2, Dir: t.TempDir()})
+	path := historyPath("myalias")
+	if filepath.Base(path) != "myalias.jsonl" {
+		t.Fatalf("historyPath() = %v", path)
+	}
+
+	first := newHistory()
+	if err := first.persist(path); err != nil {
+		t.Fatalf("persist() error = %v", err)
+	}
+	first.Push(newTestEntity("/1?q=1", time.Now(), "one"))
+	first.Push(newTestEntity("/2", time.Now(), "two"))
+	first.Push(newTestEntity("/3", time.Now(), "three"))
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	second := newHistory()
+	defer second.Close()
+	if err := second.persist(path); err != nil {
+		t.Fatalf("persist() error = %v", err)
+	}
+	items := second.ReversedItems()
+	if got := uris(items); len(got) != 2 || got[0] != "/3" || got[1] != "/2" {
+		t.Fatalf("ReversedItems() = %v, want [/3 /2]", got)
+	}
+	e := items[0]
+	if e.StatusCode != 200 || string(e.ResponseBody) != "three" || e.UseTime != 7 {
+		t.Errorf("restored entity = %+v", e)
+	}
+	if e.Response.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("restored header = %v", e.Response.Header)
+	}
+}
+
+func TestHistoryPersistenceCompacts(t *testing.T) {
+	withOptions(t, HistoryOptions{MaxEntries: 2, Dir: t.TempDir()})
+	path := historyPath("busy")
+	h := newHistory()
+	defer h.Close()
+	if err := h.persist(path); err != nil {
+		t.Fatalf("persist() error = %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		h.Push(newTestEntity(fmt.Sprintf("/%d", i), time.Now(), "body"))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the file holds at most twice the retained entries
+		if lines := strings.Count(string(data), "\n"); lines > 4 {
+			t.Fatalf("file has %d records after %d pushes, want at most 4", lines, i+1)
+		}
+	}
+
+	restored, err := readRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := uris(restored); "/49" != got[len(got)-1] {
+		t.Errorf("records = %v, want the newest last", got)
+	}
+}
@@ -0,0 +1,104 @@
+package stat
+
+import (
+	"sync"
+)
+
+// latencyBuckets are the upper bounds in milliseconds of the histogram buckets,
+// the last bucket is unbounded
+var latencyBuckets = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000}
+
+// Histogram counts millisecond observations in fixed exponential buckets, safe for concurrent use
+type Histogram struct {
+	mu     sync.Mutex
+	counts [16]uint64
+	count  uint64
+	sum    int64
+	max    int64
+}
+
+// HistogramSnapshot summarises a Histogram, percentiles are in milliseconds
+type HistogramSnapshot struct {
+	Count uint64
+	Sum   int64
+	Max   int64
+	P50   int64
+	P90   int64
+	P99   int64
+}
+
+func bucketOf(ms int64) int {
+	for i, bound := range latencyBuckets {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+// Observe records a duration in milliseconds
+func (h *Histogram) Observe(ms int64) {
+	if ms < 0 {
+		ms = 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[bucketOf(ms)]++
+	h.count++
+	h.sum += ms
+	if ms > h.max {
+		h.max = ms
+	}
+}
+
+// quantileLocked interpolates linearly inside the bucket holding the q-th observation
+func (h *Histogram) quantileLocked(q float64) int64 {
+	if 0 == h.count {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var seen float64
+	for i, c := range h.counts {
+		if 0 == c {
+			continue
+		}
+		if seen+float64(c) >= rank {
+			var lower, upper int64
+			if i > 0 {
+				lower = latencyBuckets[i-1]
+			}
+			if i < len(latencyBuckets) {
+				upper = latencyBuckets[i]
+			} else {
+				upper = h.max
+			}
+			upper = min(upper, h.max)
+			lower = min(lower, upper)
+			return lower + int64(float64(upper-lower)*(rank-seen)/float64(c))
+		}
+		seen += float64(c)
+	}
+	return h.max
+}
+
+// Snapshot copies the histogram summary
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HistogramSnapshot{
+		Count: h.count,
+		Sum:   h.sum,
+		Max:   h.max,
+		P50:   h.quantileLocked(0.5),
+		P90:   h.quantileLocked(0.9),
+		P99:   h.quantileLocked(0.99),
+	}
+}
+
+// StatusClass maps a status code to its class index, 0 for 1xx up to 4 for 5xx, -1 otherwise
+func StatusClass(status int) int {
+	if status < 100 || status >= 600 {
+		return -1
+	}
+	return status/100 - 1
+}
@@ -0,0 +1,66 @@
+package stat
+
+import (
+	"testing"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := &Histogram{}
+	for i := int64(1); i <= 100; i++ {
+		h.Observe(i)
+	}
+	s := h.Snapshot()
+	if s.Count != 100 || s.Sum != 5050 || s.Max != 100 {
+		t.Fatalf("Snapshot() = %+v", s)
+	}
+	tests := []struct {
+		name string
+		got  int64
+		low  int64
+		high int64
+	}{
+		{name: "p50", got: s.P50, low: 45, high: 55},
+		{name: "p90", got: s.P90, low: 85, high: 100},
+		{name: "p99", got: s.P99, low: 95, high: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got < tt.low || tt.got > tt.high {
+				t.Errorf("%s = %v, want within [%v, %v]", tt.name, tt.got, tt.low, tt.high)
+			}
+		})
+	}
+}
+
+func TestHistogramEmptyAndOverflow(t *testing.T) {
+	h := &Histogram{}
+	if s := h.Snapshot(); s.P50 != 0 || s.P99 != 0 {
+		t.Errorf("empty Snapshot() = %+v", s)
+	}
+	h.Observe(-5)
+	h.Observe(120000)
+	s := h.Snapshot()
+	if s.Max != 120000 || s.P99 > s.Max {
+		t.Errorf("Snapshot() = %+v", s)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   int
+	}{
+		{status: 101, want: 0},
+		{status: 200, want: 1},
+		{status: 304, want: 2},
+		{status: 404, want: 3},
+		{status: 503, want: 4},
+		{status: 99, want: -1},
+		{status: 600, want: -1},
+	}
+	for _, tt := range tests {
+		if got := StatusClass(tt.status); got != tt.want {
+			t.Errorf("StatusClass(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
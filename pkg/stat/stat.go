@@ -1,9 +1,11 @@
 package stat
 
 import (
-	"github.com/echogy-io/echogy/pkg/queue"
 	"github.com/gliderlabs/ssh"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -11,33 +13,118 @@ const (
 	requestStat = "requestStat"
 )
 
+// mu serialises the lazy creation of per session values
+var mu sync.Mutex
+
+// Stat holds the counters of a session, safe for concurrent use
 type Stat struct {
+	receive   atomic.Int64
+	send      atomic.Int64
+	request   atomic.Int64
+	response  atomic.Int64
+	connCount atomic.Int64
+	totalConn atomic.Int64
+	status    [5]atomic.Int64
+	ttfb      Histogram
+	total     Histogram
+}
+
+// Snapshot is a point in time copy of a Stat
+type Snapshot struct {
 	Receive   int64
 	Send      int64
-	Request   int
-	Response  int
-	ConnCount int
-	TotalConn int
+	Request   int64
+	Response  int64
+	ConnCount int64
+	TotalConn int64
+	Status    [5]int64 // responses per status class, 1xx to 5xx
+	TTFB      HistogramSnapshot
+	Total     HistogramSnapshot
+}
+
+// ConnOpened records a new forwarded connection
+func (s *Stat) ConnOpened() {
+	s.connCount.Add(1)
+	s.totalConn.Add(1)
+}
+
+// ConnClosed records the end of a forwarded connection
+func (s *Stat) ConnClosed() {
+	s.connCount.Add(-1)
+}
+
+func (s *Stat) record(e *RequestEntity) {
+	if e.Response.ContentLength > 0 {
+		s.send.Add(e.Response.ContentLength)
+	}
+	if e.Request.ContentLength > 0 {
+		s.receive.Add(e.Request.ContentLength)
+	}
+	s.request.Add(1)
+	s.response.Add(1)
+	if c := StatusClass(e.Response.StatusCode); c >= 0 {
+		s.status[c].Add(1)
+	}
+	s.ttfb.Observe(e.TTFB)
+	s.total.Observe(e.UseTime)
+}
+
+// Snapshot copies the counters, each counter is read atomically
+func (s *Stat) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Receive:   s.receive.Load(),
+		Send:      s.send.Load(),
+		Request:   s.request.Load(),
+		Response:  s.response.Load(),
+		ConnCount: s.connCount.Load(),
+		TotalConn: s.totalConn.Load(),
+		TTFB:      s.ttfb.Snapshot(),
+		Total:     s.total.Snapshot(),
+	}
+	for i := range s.status {
+		snapshot.Status[i] = s.status[i].Load()
+	}
+	return snapshot
 }
 
 type RequestEntity struct {
 	*http.Response
 	*http.Request
-	UseTime int64
+	UseTime      int64 // milliseconds until the response was fully written
+	TTFB         int64 // milliseconds until the first response byte
+	StartTime    time.Time
+	RequestBody  []byte
+	ResponseBody []byte
 }
 
-func GetQueue(ctx ssh.Context) *queue.FixedQueue {
+func GetHistory(ctx ssh.Context) *History {
+	mu.Lock()
+	defer mu.Unlock()
 	q := ctx.Value(requests)
 	if nil != q {
-		return q.(*queue.FixedQueue)
+		return q.(*History)
 	} else {
-		fq := queue.NewFixedQueue(52)
-		ctx.SetValue(requests, fq)
-		return fq
+		h := newHistory()
+		ctx.SetValue(requests, h)
+		return h
 	}
 }
 
+// Restore loads the persisted history of alias into the session and keeps it in sync,
+// it is a no-op when persistence is disabled
+func Restore(ctx ssh.Context, alias string) error {
+	path := historyPath(alias)
+	if "" == path {
+		return nil
+	}
+	err := GetHistory(ctx).persist(path)
+	trimTotal()
+	return err
+}
+
 func GetStat(ctx ssh.Context) *Stat {
+	mu.Lock()
+	defer mu.Unlock()
 	q := ctx.Value(requestStat)
 	if nil != q {
 		return q.(*Stat)
@@ -48,19 +135,7 @@ func GetStat(ctx ssh.Context) *Stat {
 	}
 }
 
-func Put(ctx ssh.Context, w *http.Response, r *http.Request, t int64) {
-	q := GetQueue(ctx)
-	s := GetStat(ctx)
-
-	s.Send += w.ContentLength
-	s.Receive += r.ContentLength
-
-	s.Request += 1
-	s.Response += 1
-
-	q.Push(&RequestEntity{
-		Request:  r,
-		Response: w,
-		UseTime:  t,
-	})
+func Put(ctx ssh.Context, e *RequestEntity) {
+	GetStat(ctx).record(e)
+	GetHistory(ctx).Push(e)
 }
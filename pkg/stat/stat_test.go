@@ -0,0 +1,153 @@
+package stat
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// testContext is a minimal ssh.Context backed by a locked map
+type testContext struct {
+	context.Context
+	sync.Mutex
+	valuesMu sync.Mutex
+	values   map[interface{}]interface{}
+}
+
+func newTestContext() *testContext {
+	return &testContext{
+		Context: context.Background(),
+		values:  make(map[interface{}]interface{}),
+	}
+}
+
+func (c *testContext) Value(key interface{}) interface{} {
+	c.valuesMu.Lock()
+	defer c.valuesMu.Unlock()
+	if v, ok := c.values[key]; ok {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+func (c *testContext) SetValue(key, value interface{}) {
+	c.valuesMu.Lock()
+	defer c.valuesMu.Unlock()
+	c.values[key] = value
+}
+
+func (c *testContext) User() string                  { return "test" }
+func (c *testContext) SessionID() string             { return "test" }
+func (c *testContext) ClientVersion() string         { return "" }
+func (c *testContext) ServerVersion() string         { return "" }
+func (c *testContext) RemoteAddr() net.Addr          { return &net.TCPAddr{} }
+func (c *testContext) LocalAddr() net.Addr           { return &net.TCPAddr{} }
+func (c *testContext) Permissions() *ssh.Permissions { return nil }
+
+func TestGetStatConcurrentCreate(t *testing.T) {
+	ctx := newTestContext()
+	got := make([]*Stat, 16)
+	wg := sync.WaitGroup{}
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = GetStat(ctx)
+		}(i)
+	}
+	wg.Wait()
+	for i := range got {
+		if got[i] != got[0] {
+			t.Fatalf("GetStat() returned different instances")
+		}
+	}
+}
+
+func TestConcurrentProducersAndReaders(t *testing.T) {
+	withOptions(t, HistoryOptions{MaxEntries: 10})
+	ctx := newTestContext()
+	defer GetHistory(ctx).Close()
+
+	const producers = 8
+	const perProducer = 200
+
+	stop := make(chan struct{})
+	readers := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s := GetStat(ctx).Snapshot()
+				if s.ConnCount < 0 || s.Request > producers*perProducer {
+					t.Errorf("inconsistent snapshot %+v", s)
+					return
+				}
+				for _, e := range GetHistory(ctx).ReversedItems() {
+					_ = e.StatusCode
+				}
+			}
+		}()
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perProducer; j++ {
+				s := GetStat(ctx)
+				s.ConnOpened()
+				e := newTestEntity("/", time.Now(), "")
+				e.Request.ContentLength = 2
+				e.Response.ContentLength = 3
+				Put(ctx, e)
+				s.ConnClosed()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	want := Snapshot{
+		Receive:   2 * producers * perProducer,
+		Send:      3 * producers * perProducer,
+		Request:   producers * perProducer,
+		Response:  producers * perProducer,
+		ConnCount: 0,
+		TotalConn: producers * perProducer,
+		Status:    [5]int64{0, producers * perProducer, 0, 0, 0},
+	}
+	got := GetStat(ctx).Snapshot()
+	if got.Total.Count != producers*perProducer || got.TTFB.Count != producers*perProducer {
+		t.Errorf("latency counts = %v, %v", got.Total.Count, got.TTFB.Count)
+	}
+	got.TTFB, got.Total = HistogramSnapshot{}, HistogramSnapshot{}
+	if got != want {
+		t.Errorf("Snapshot() = %+v, want %+v", got, want)
+	}
+	if got := GetHistory(ctx).Len(); got != 10 {
+		t.Errorf("history Len() = %v, want 10", got)
+	}
+}
+
+func TestUnknownContentLengthIgnored(t *testing.T) {
+	s := &Stat{}
+	e := newTestEntity("/", time.Now(), "")
+	e.Request.ContentLength = -1
+	e.Response.ContentLength = -1
+	s.record(e)
+	if got := s.Snapshot(); got.Receive != 0 || got.Send != 0 || got.Request != 1 {
+		t.Errorf("Snapshot() = %+v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const instrumentationName = "github.com/echogy-io/echogy"
+
+// Options configures span export to an OTLP/HTTP collector
+type Options struct {
+	Endpoint    string  // collector host:port
+	URLPath     string  // defaults to /v1/traces
+	Insecure    bool    // use plain http
+	SampleRatio float64 // fraction of new traces sampled, 0 means all
+	ServiceName string
+	Headers     map[string]string
+}
+
+// Setup installs a global tracer provider exporting to the configured collector,
+// the returned function flushes and stops the exporter
+func Setup(ctx context.Context, o Options) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(o.Endpoint)}
+	if "" != o.URLPath {
+		opts = append(opts, otlptracehttp.WithURLPath(o.URLPath))
+	}
+	if o.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(o.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(o.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tp := NewProvider(o, sdktrace.WithBatcher(exporter))
+	Install(tp)
+	return tp.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider for the service, extra options add span processors
+func NewProvider(o Options, extra ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	serviceName := o.ServiceName
+	if "" == serviceName {
+		serviceName = "echogy"
+	}
+	sampler := sdktrace.AlwaysSample()
+	if o.SampleRatio > 0 && o.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(o.SampleRatio)
+	}
+	opts := append([]sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	}, extra...)
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Install makes tp the global tracer provider and enables W3C trace context propagation
+func Install(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Tracer returns the tracer used across echogy
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Extract returns ctx with the remote span context carried by header, if any
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject returns the propagation headers of the span in ctx, empty when tracing is disabled
+func Inject(ctx context.Context) http.Header {
+	header := http.Header{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	return header
+}
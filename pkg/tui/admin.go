@@ -0,0 +1,306 @@
+package tui
+
+import (
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const adminRefreshInterval = time.Second
+
+var (
+	adminHelpStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.AdaptiveColor{Light: "#94A3B8", Dark: "#4A5568"}).
+			PaddingTop(1)
+
+	adminStatusStyle = lipgloss.NewStyle().
+				Bold(true).
+				Foreground(lipgloss.AdaptiveColor{Light: "#DD6B20", Dark: "#FBD38D"})
+)
+
+// TunnelSummary describes a live tunnel in the admin dashboard
+type TunnelSummary struct {
+	AccessId   string
+	Tunnel     string
+	User       string
+	RemoteAddr string
+	StartedAt  time.Time
+	Stat       *stat.Stat
+	History    *stat.History
+}
+
+// AdminSource provides the live tunnels of the server to the admin dashboard
+type AdminSource interface {
+	Tunnels() []*TunnelSummary
+	Disconnect(accessId string) bool
+}
+
+type adminTickMsg time.Time
+
+// throughput is the byte rate of a tunnel since the previous refresh
+type throughput struct {
+	at       time.Time
+	recv     int64
+	sent     int64
+	recvRate float64
+	sentRate float64
+}
+
+// AdminDashboard lists all live tunnels for operators
+type AdminDashboard struct {
+	width   int
+	height  int
+	source  AdminSource
+	tunnels []*TunnelSummary
+	rates   map[string]*throughput
+	table   *RequestTable
+	// detail shows the requests of the selected tunnel
+	detail   *RequestTable
+	selected *TunnelSummary
+	// confirm is the access id waiting for a disconnect confirmation
+	confirm string
+	status  string
+}
+
+func newAdminDashboard(source AdminSource, width, height int) *AdminDashboard {
+	columns := []TableColumn{
+		{Title: "Tunnel", Weight: 0.22},
+		{Title: "User", Weight: 0.1},
+		{Title: "Remote", Weight: 0.18},
+		{Title: "Uptime", Weight: 0.09},
+		{Title: "↓/s", Weight: 0.09},
+		{Title: "↑/s", Weight: 0.09},
+		{Title: "Req", Weight: 0.08},
+		{Title: "Err", Weight: 0.07},
+		{Title: "Conns", Weight: 0.08},
+	}
+	d := &AdminDashboard{
+		width:  width,
+		height: height,
+		source: source,
+		rates:  make(map[string]*throughput),
+		table:  newTable(columns, width),
+		detail: newRequestTable(width),
+	}
+	d.resize()
+	d.refresh(time.Now())
+	return d
+}
+
+func adminTick() tea.Cmd {
+	return tea.Tick(adminRefreshInterval, func(t time.Time) tea.Msg {
+		return adminTickMsg(t)
+	})
+}
+
+// Init implements tea.Model
+func (d *AdminDashboard) Init() tea.Cmd {
+	return adminTick()
+}
+
+func (d *AdminDashboard) resize() {
+	d.table.setWidth(d.width - 4)
+	d.detail.setWidth(d.width - 4)
+	d.table.SetHeight(max(d.height-10, 3))
+	d.detail.SetHeight(max(d.height-12, 3))
+}
+
+// errorRate returns the share of 4xx and 5xx responses in percent
+func errorRate(s stat.Snapshot) float64 {
+	var total int64
+	for _, n := range s.Status {
+		total += n
+	}
+	if 0 == total {
+		return 0
+	}
+	return float64(s.Status[3]+s.Status[4]) * 100 / float64(total)
+}
+
+func (d *AdminDashboard) refresh(now time.Time) {
+	d.tunnels = d.source.Tunnels()
+	sort.Slice(d.tunnels, func(i, j int) bool {
+		return d.tunnels[i].StartedAt.Before(d.tunnels[j].StartedAt)
+	})
+
+	rates := make(map[string]*throughput, len(d.tunnels))
+	rows := make([]table.Row, len(d.tunnels))
+	for i, t := range d.tunnels {
+		s := t.Stat.Snapshot()
+		rate := &throughput{at: now, recv: s.Receive, sent: s.Send}
+		if prev, ok := d.rates[t.AccessId]; ok {
+			if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+				rate.recvRate = float64(s.Receive-prev.recv) / elapsed
+				rate.sentRate = float64(s.Send-prev.sent) / elapsed
+			}
+		}
+		rates[t.AccessId] = rate
+		rows[i] = table.Row{
+			t.Tunnel,
+			t.User,
+			t.RemoteAddr,
+			humanMillis(now.Sub(t.StartedAt).Milliseconds()),
+			humanBytes(int64(rate.recvRate)),
+			humanBytes(int64(rate.sentRate)),
+			strconv.FormatInt(s.Request, 10),
+			fmt.Sprintf("%.1f%%", errorRate(s)),
+			strconv.FormatInt(s.ConnCount, 10),
+		}
+	}
+	d.rates = rates
+	d.table.SetRows(rows)
+
+	if nil != d.selected {
+		if _, live := rates[d.selected.AccessId]; !live {
+			d.status = fmt.Sprintf("%s closed", d.selected.Tunnel)
+			d.selected = nil
+			return
+		}
+		d.detail.SetRows(historyRows(d.selected.History))
+	}
+}
+
+// cursorTunnel returns the tunnel under the cursor of the tunnel table
+func (d *AdminDashboard) cursorTunnel() *TunnelSummary {
+	i := d.table.Cursor()
+	if i < 0 || i >= len(d.tunnels) {
+		return nil
+	}
+	return d.tunnels[i]
+}
+
+func (d *AdminDashboard) disconnect(accessId string) {
+	if d.source.Disconnect(accessId) {
+		d.status = fmt.Sprintf("disconnected %s", accessId)
+	} else {
+		d.status = fmt.Sprintf("%s is already gone", accessId)
+	}
+	if nil != d.selected && d.selected.AccessId == accessId {
+		d.selected = nil
+	}
+	d.refresh(time.Now())
+}
+
+func (d *AdminDashboard) handleKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	if "" != d.confirm {
+		accessId := d.confirm
+		d.confirm = ""
+		if "y" == msg.String() {
+			d.disconnect(accessId)
+		} else {
+			d.status = ""
+		}
+		return nil, true
+	}
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return tea.Quit, true
+	case "esc", "backspace":
+		d.selected = nil
+		return nil, true
+	case "enter":
+		if nil == d.selected {
+			if t := d.cursorTunnel(); nil != t {
+				d.selected = t
+				d.status = ""
+				d.detail.SetRows(historyRows(t.History))
+				d.detail.SetCursor(0)
+			}
+		}
+		return nil, true
+	case "d":
+		t := d.selected
+		if nil == t {
+			t = d.cursorTunnel()
+		}
+		if nil != t {
+			d.confirm = t.AccessId
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+// Update implements tea.Model
+func (d *AdminDashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case adminTickMsg:
+		d.refresh(time.Time(msg))
+		return d, adminTick()
+	case tea.WindowSizeMsg:
+		d.width = msg.Width
+		d.height = msg.Height
+		d.resize()
+	case tea.KeyMsg:
+		if cmd, handled := d.handleKey(msg); handled {
+			return d, cmd
+		}
+	}
+
+	// navigation keys go to the visible table
+	var m table.Model
+	var cmd tea.Cmd
+	if nil != d.selected {
+		m, cmd = d.detail.Model.Update(msg)
+		d.detail.Model = &m
+	} else {
+		m, cmd = d.table.Model.Update(msg)
+		d.table.Model = &m
+	}
+	return d, cmd
+}
+
+func (d *AdminDashboard) renderHeader() string {
+	var recv, sent float64
+	for _, rate := range d.rates {
+		recv += rate.recvRate
+		sent += rate.sentRate
+	}
+	title := fmt.Sprintf("Echogy admin · %d tunnels", len(d.tunnels))
+	if nil != d.selected {
+		title = fmt.Sprintf("%s · %s@%s", d.selected.Tunnel, d.selected.User, d.selected.RemoteAddr)
+	}
+	rates := fmt.Sprintf("↓ %s/s  ↑ %s/s", humanBytes(int64(recv)), humanBytes(int64(sent)))
+	return lipgloss.NewStyle().Inherit(headerStyle).Width(d.width - 2).Render(
+		lipgloss.JoinHorizontal(
+			lipgloss.Left,
+			urlStyle.Render(title),
+			statsStyle.Width(max(d.width-2-urlStyle.GetWidth(), 0)).Render(rates),
+		),
+	)
+}
+
+func (d *AdminDashboard) renderFooter() string {
+	switch {
+	case "" != d.confirm:
+		return adminStatusStyle.PaddingTop(1).Render(fmt.Sprintf("Disconnect %s? (y/n)", d.confirm))
+	case nil != d.selected:
+		return adminHelpStyle.Render("↑/↓ select • esc back • d disconnect • q quit  " + adminStatusStyle.Render(d.status))
+	default:
+		return adminHelpStyle.Render("↑/↓ select • enter requests • d disconnect • q quit  " + adminStatusStyle.Render(d.status))
+	}
+}
+
+// View implements tea.Model
+func (d *AdminDashboard) View() string {
+	var content string
+	if nil != d.selected {
+		content = lipgloss.JoinVertical(lipgloss.Left,
+			latencyStyle.Render(latencySummary(d.selected.Stat.Snapshot())),
+			d.detail.View())
+	} else {
+		content = d.table.View()
+	}
+	return dashStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			d.renderHeader(),
+			content,
+			d.renderFooter()),
+	)
+}
@@ -0,0 +1,96 @@
+package tui
+
+import (
+	"github.com/echogy-io/echogy/pkg/stat"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type fakeSource struct {
+	tunnels      []*TunnelSummary
+	disconnected []string
+}
+
+func (f *fakeSource) Tunnels() []*TunnelSummary {
+	return f.tunnels
+}
+
+func (f *fakeSource) Disconnect(accessId string) bool {
+	for i, t := range f.tunnels {
+		if t.AccessId == accessId {
+			f.tunnels = append(f.tunnels[:i], f.tunnels[i+1:]...)
+			f.disconnected = append(f.disconnected, accessId)
+			return true
+		}
+	}
+	return false
+}
+
+func key(s string) tea.KeyMsg {
+	switch s {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestAdminDashboardKeys(t *testing.T) {
+	source := &fakeSource{tunnels: []*TunnelSummary{
+		{AccessId: "a", Tunnel: "a.webs.sh", StartedAt: time.Now().Add(-time.Minute), Stat: &stat.Stat{}, History: &stat.History{}},
+		{AccessId: "b", Tunnel: "b.webs.sh", StartedAt: time.Now(), Stat: &stat.Stat{}, History: &stat.History{}},
+	}}
+	d := newAdminDashboard(source, 120, 40)
+
+	d.Update(key("enter"))
+	if nil == d.selected || d.selected.AccessId != "a" {
+		t.Fatalf("selected = %v, want a", d.selected)
+	}
+	d.Update(key("esc"))
+	if nil != d.selected {
+		t.Fatalf("selected = %v after esc, want nil", d.selected)
+	}
+
+	d.Update(key("d"))
+	if d.confirm != "a" {
+		t.Fatalf("confirm = %q, want a", d.confirm)
+	}
+	d.Update(key("n"))
+	if len(source.disconnected) != 0 || d.confirm != "" {
+		t.Fatalf("disconnected = %v after declining", source.disconnected)
+	}
+
+	d.Update(key("d"))
+	d.Update(key("y"))
+	if len(source.disconnected) != 1 || source.disconnected[0] != "a" {
+		t.Fatalf("disconnected = %v, want [a]", source.disconnected)
+	}
+	if len(d.tunnels) != 1 {
+		t.Errorf("tunnels = %d after disconnect, want 1", len(d.tunnels))
+	}
+	if d.View() == "" {
+		t.Errorf("View() is empty")
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		status [5]int64
+		want   float64
+	}{
+		{name: "no responses", want: 0},
+		{name: "all ok", status: [5]int64{0, 10, 0, 0, 0}, want: 0},
+		{name: "client and server errors", status: [5]int64{0, 6, 0, 2, 2}, want: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorRate(stat.Snapshot{Status: tt.status}); got != tt.want {
+				t.Errorf("errorRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
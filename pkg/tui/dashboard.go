@@ -9,15 +9,17 @@ import (
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	q "github.com/echogy-io/echogy/pkg/queue"
 )
 
 // Constants for layout and styling
 const (
 	defaultTableHeight = 10
 	minHeaderWidth     = 80
+	sparklineWidth     = 24
 )
 
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
 // Style definitions
 var (
 	dashStyle = lipgloss.NewStyle().Padding(1)
@@ -57,6 +59,18 @@ var (
 			Bold(false).
 			Foreground(lipgloss.AdaptiveColor{Light: "#475569", Dark: "#94A3B8"})
 
+	latencyStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.AdaptiveColor{Light: "#475569", Dark: "#94A3B8"}).
+			PaddingBottom(1)
+
+	sparkStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.AdaptiveColor{Light: "#2563EB", Dark: "#60A5FA"})
+
+	shutdownStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.AdaptiveColor{Light: "#E53E3E", Dark: "#FC8181"}).
+			PaddingBottom(1)
+
 	qrStyle = lipgloss.NewStyle().
 		Align(lipgloss.Top).
 		Foreground(lipgloss.AdaptiveColor{Light: "#0F172A", Dark: "#F8FAFC"}).
@@ -114,6 +128,11 @@ func newRequestTable(width int) *RequestTable {
 		{Title: colUseTimeHeaderStyle.Render("UseTime"), Weight: 0.1}, // 10% of available width
 	}
 
+	return newTable(columns, width)
+}
+
+// newTable creates a focused table whose columns share width by weight
+func newTable(columns []TableColumn, width int) *RequestTable {
 	cols := make([]table.Column, len(columns))
 	for i, col := range columns {
 		cols[i] = table.Column{
@@ -143,31 +162,57 @@ type Dashboard struct {
 	tunnelInfo TunnelInfo
 	table      *RequestTable
 	stat       *stat.Stat
-	requests   *q.FixedQueue
+	requests   *stat.History
+	// shutdownAt is when the server closes the tunnel, zero while running
+	shutdownAt time.Time
+	// expiresAt is when the tunnel reaches its lifetime, zero when unlimited
+	expiresAt time.Time
+	ticking   bool
+}
+
+// ShutdownMsg tells the dashboard the server is going down
+type ShutdownMsg struct {
+	Deadline time.Time
+}
+
+type countdownTickMsg struct{}
+
+func countdownTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return countdownTickMsg{}
+	})
+}
+
+// startCountdown redraws every second while a countdown is shown
+func (d *Dashboard) startCountdown() tea.Cmd {
+	if d.ticking {
+		return nil
+	}
+	d.ticking = true
+	return countdownTick()
 }
 
 // TunnelInfo holds information about the tunnel connection
 type TunnelInfo struct {
 	URL       string
-	ExpiresIn time.Duration
 	BytesRecv int64
 	BytesSent int64
-	ReqCount  int
-	ResCount  int
+	ReqCount  int64
+	ResCount  int64
 }
 
 // newDashboard creates a new dashboard instance
-func newDashboard(queue *q.FixedQueue, stat *stat.Stat, tunnelAddr string, width, height int) *Dashboard {
+func newDashboard(history *stat.History, stat *stat.Stat, tunnelAddr string, expiresAt time.Time, width, height int) *Dashboard {
 	return &Dashboard{
 		tunnelInfo: TunnelInfo{
-			URL:       tunnelAddr,
-			ExpiresIn: 10 * time.Minute,
+			URL: tunnelAddr,
 		},
-		width:    width,
-		height:   height,
-		table:    newRequestTable(width),
-		stat:     stat,
-		requests: queue,
+		width:     width,
+		height:    height,
+		table:     newRequestTable(width),
+		stat:      stat,
+		requests:  history,
+		expiresAt: expiresAt,
 	}
 }
 
@@ -182,29 +227,40 @@ func (d *Dashboard) updateTableWidth() {
 	}
 
 	// Calculate available width (accounting for margins)
-	aw := d.availableWidth()
+	d.table.setWidth(d.availableWidth())
+}
 
-	// Apply new column widths
-	tableColumns := make([]table.Column, len(d.table.columns))
-	for i, col := range d.table.columns {
+// setWidth applies column widths for the available width
+func (r *RequestTable) setWidth(width int) {
+	tableColumns := make([]table.Column, len(r.columns))
+	for i, col := range r.columns {
 		tableColumns[i] = table.Column{
 			Title: col.Title, // Keep original styled title
-			Width: int(float64(aw) * col.Weight),
+			Width: int(float64(width) * col.Weight),
 		}
 	}
 
-	d.table.SetColumns(tableColumns)
+	r.SetColumns(tableColumns)
 }
 
 // Init implements tea.Model
 func (d *Dashboard) Init() tea.Cmd {
-	return nil
+	if d.expiresAt.IsZero() {
+		return nil
+	}
+	return d.startCountdown()
 }
 
 // Update implements tea.Model
 func (d *Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
+	case ShutdownMsg:
+		d.shutdownAt = msg.Deadline
+		return d, d.startCountdown()
+	case countdownTickMsg:
+		// keep the countdown moving
+		return d, countdownTick()
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c":
@@ -213,7 +269,7 @@ func (d *Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		d.width = msg.Width
 		d.height = msg.Height
-		d.table.SetHeight(msg.Height - 8)
+		d.table.SetHeight(msg.Height - 10)
 		d.updateTableWidth()
 	}
 
@@ -234,6 +290,10 @@ func (d *Dashboard) renderHeader() string {
 		fmt.Sprintf("HTTPS: %s", d.tunnelInfo.URL),
 	}
 
+	if !d.expiresAt.IsZero() {
+		urls = append(urls, fmt.Sprintf("Expires in %s", max(time.Until(d.expiresAt).Round(time.Second), 0)))
+	}
+
 	// Stats section
 	stats := []string{
 		fmt.Sprintf("↓ %s", humanBytes(d.tunnelInfo.BytesRecv)),
@@ -247,11 +307,7 @@ func (d *Dashboard) renderHeader() string {
 
 	if d.width < minHeaderWidth {
 		// 小屏幕：垂直布局
-		leftURLS := lipgloss.JoinVertical(
-			lipgloss.Left,
-			urlStyle.Render(urls[0]),
-			urlStyle.Render(urls[1]),
-		)
+		leftURLS := renderURLs(urls)
 
 		statsInfo := lipgloss.JoinHorizontal(
 			lipgloss.Left,
@@ -277,11 +333,7 @@ func (d *Dashboard) renderHeader() string {
 	}
 
 	// 大屏幕：水平布局
-	leftURLS := lipgloss.JoinVertical(
-		lipgloss.Left,
-		urlStyle.Render(urls[0]),
-		urlStyle.Render(urls[1]),
-	)
+	leftURLS := renderURLs(urls)
 
 	rightStats := lipgloss.JoinVertical(
 		lipgloss.Right,
@@ -306,6 +358,60 @@ func (d *Dashboard) renderHeader() string {
 	)
 }
 
+// renderURLs stacks the tunnel URLs and its expiry
+func renderURLs(urls []string) string {
+	lines := make([]string, len(urls))
+	for i, url := range urls {
+		lines[i] = urlStyle.Render(url)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
+// sparkline renders values as block characters scaled to the largest value
+func sparkline(values []int64) string {
+	var peak int64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	runes := make([]rune, len(values))
+	for i, v := range values {
+		idx := 0
+		if peak > 0 {
+			idx = int(v * int64(len(sparkBlocks)-1) / peak)
+		}
+		runes[i] = sparkBlocks[idx]
+	}
+	return string(runes)
+}
+
+// latencySummary formats latency percentiles and status class counts on one line
+func latencySummary(s stat.Snapshot) string {
+	return fmt.Sprintf("TTFB p50 %s p90 %s p99 %s │ Total p50 %s p90 %s p99 %s │ 2xx %d 3xx %d 4xx %d 5xx %d",
+		humanMillis(s.TTFB.P50), humanMillis(s.TTFB.P90), humanMillis(s.TTFB.P99),
+		humanMillis(s.Total.P50), humanMillis(s.Total.P90), humanMillis(s.Total.P99),
+		s.Status[1], s.Status[2], s.Status[3], s.Status[4])
+}
+
+// renderLatency renders latency percentiles, status classes and a sparkline of recent requests
+func (d *Dashboard) renderLatency() string {
+	s := d.stat.Snapshot()
+
+	items := d.requests.ReversedItems()
+	n := min(len(items), sparklineWidth)
+	recent := make([]int64, n)
+	for i := 0; i < n; i++ {
+		// oldest on the left
+		recent[n-1-i] = items[i].UseTime
+	}
+
+	summary := latencySummary(s)
+
+	if d.width < minHeaderWidth+sparklineWidth {
+		return latencyStyle.Render(summary)
+	}
+	return latencyStyle.Render(summary + "  " + sparkStyle.Render(sparkline(recent)))
+}
+
 // renderProjectInfo renders the project information section
 func (d *Dashboard) renderProjectInfo() string {
 	return lipgloss.JoinVertical(
@@ -321,9 +427,18 @@ func (d *Dashboard) renderProjectInfo() string {
 	)
 }
 
+// renderShutdown renders the countdown until the server closes the tunnel
+func (d *Dashboard) renderShutdown() string {
+	left := max(time.Until(d.shutdownAt).Round(time.Second), 0)
+	return shutdownStyle.Render(fmt.Sprintf("⚠ Server is shutting down, open requests may finish, this tunnel closes in %s", left))
+}
+
 // View implements tea.Model
 func (d *Dashboard) View() string {
 	head := d.renderHeader()
+	if !d.shutdownAt.IsZero() {
+		head = lipgloss.JoinVertical(lipgloss.Left, d.renderShutdown(), head)
+	}
 
 	var content string
 	if d.requests.Len() == 0 {
@@ -342,7 +457,9 @@ func (d *Dashboard) View() string {
 			)
 		}
 	} else {
-		content = d.table.View()
+		content = lipgloss.JoinVertical(lipgloss.Left,
+			d.renderLatency(),
+			d.table.View())
 	}
 
 	return dashStyle.Render(
@@ -388,20 +505,27 @@ func renderMethod(method string) string {
 }
 
 func (d *Dashboard) preUpdate() {
-	d.tunnelInfo.BytesRecv = d.stat.Receive
-	d.tunnelInfo.BytesSent = d.stat.Send
+	s := d.stat.Snapshot()
 
-	d.tunnelInfo.ReqCount = d.stat.Request
-	d.tunnelInfo.ResCount = d.stat.Response
+	d.tunnelInfo.BytesRecv = s.Receive
+	d.tunnelInfo.BytesSent = s.Send
 
-	l := min(d.requests.Len(), 32)
+	d.tunnelInfo.ReqCount = s.Request
+	d.tunnelInfo.ResCount = s.Response
 
-	items := d.requests.ReversedItems()
+	d.table.SetRows(historyRows(d.requests))
+}
+
+// historyRows renders the requests of history as table rows, newest first
+func historyRows(history *stat.History) []table.Row {
+	items := history.ReversedItems()
+
+	l := len(items)
 
 	rows := make([]table.Row, l)
 	// Update table rows
 	for i := 0; i < l; i++ {
-		r := items[i].(*stat.RequestEntity)
+		r := items[i]
 		path := colPathStyle.Render(r.RequestURI)
 		t := colUseTimeStyle.Render(humanMillis(r.UseTime))
 
@@ -413,7 +537,7 @@ func (d *Dashboard) preUpdate() {
 			t,
 		}
 	}
-	d.table.SetRows(rows)
+	return rows
 }
 
 // UpdateStats updates the tunnel information statistics
@@ -0,0 +1,34 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardExpiry(t *testing.T) {
+	tests := []struct {
+		expiresAt time.Time
+		want      string
+	}{
+		{time.Time{}, ""},
+		{time.Now().Add(90 * time.Second), "Expires in 1m30s"},
+		{time.Now().Add(-time.Second), "Expires in 0s"},
+	}
+	for _, tt := range tests {
+		d := newDashboard(nil, nil, "demo.webs.sh", tt.expiresAt, 120, 40)
+		header := d.renderHeader()
+		if "" == tt.want {
+			if strings.Contains(header, "Expires") || nil != d.Init() {
+				t.Errorf("unlimited tunnel shows an expiry:\n%s", header)
+			}
+			continue
+		}
+		if !strings.Contains(header, tt.want) {
+			t.Errorf("header misses %q:\n%s", tt.want, header)
+		}
+		if nil == d.Init() {
+			t.Error("expiring tunnel starts no countdown")
+		}
+	}
+}
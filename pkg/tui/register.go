@@ -5,7 +5,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"regexp"
+	"github.com/echogy-io/echogy/pkg/auth"
 )
 
 // Style definitions
@@ -50,7 +50,7 @@ func (r *Register) Init() tea.Cmd {
 	return textinput.Blink
 }
 
-var isAlphabetic = regexp.MustCompile(`^[a-z0-9]{6,20}$`).MatchString
+var isAlphabetic = auth.ValidRequested
 
 // Update implements tea.Model
 func (r *Register) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -10,6 +10,7 @@ import (
 	"github.com/gliderlabs/ssh"
 	"io"
 	"strings"
+	"time"
 )
 
 type HttpReversProxyPty struct {
@@ -21,6 +22,17 @@ func (t *HttpReversProxyPty) Update() {
 	t.Program.Send(tea.ShowCursor())
 }
 
+// Stop quits the terminal UI and waits until it has restored the terminal
+func (t *HttpReversProxyPty) Stop() {
+	t.Program.Quit()
+	t.Program.Wait()
+}
+
+// Shutdown shows the client a countdown until the server closes the tunnel
+func (t *HttpReversProxyPty) Shutdown(deadline time.Time) {
+	t.Program.Send(ShutdownMsg{Deadline: deadline})
+}
+
 func (t *HttpReversProxyPty) Start() error {
 	_, err := t.Run()
 	t.cancel()
@@ -72,8 +84,9 @@ func setupProgram(ctx context.Context, rw io.ReadWriter, term string, env []stri
 	return p
 }
 
-// NewHttpReverseProxyPty creates a new terminal UI instance
-func NewHttpReverseProxyPty(sess ssh.Session, addr string) (*HttpReversProxyPty, error) {
+// NewHttpReverseProxyPty creates a new terminal UI instance, counting down to
+// expiresAt unless it is zero
+func NewHttpReverseProxyPty(sess ssh.Session, addr string, expiresAt time.Time) (*HttpReversProxyPty, error) {
 	pty, windowCh, hasPty := sess.Pty()
 	if !hasPty {
 		return nil, errors.New("no pty")
@@ -84,11 +97,11 @@ func NewHttpReverseProxyPty(sess ssh.Session, addr string) (*HttpReversProxyPty,
 	// Setup terminal environment
 	stdCtx, cancelFunc := context.WithCancel(ctx)
 
-	queue := stat.GetQueue(ctx)
+	history := stat.GetHistory(ctx)
 
 	s := stat.GetStat(ctx)
 
-	m := newDashboard(queue, s, addr, pty.Window.Width, pty.Window.Height)
+	m := newDashboard(history, s, addr, expiresAt, pty.Window.Width, pty.Window.Height)
 
 	program := setupProgram(ctx, sess, pty.Term, sess.Environ(), m)
 
@@ -166,3 +179,54 @@ func NewRegisterPty(sess ssh.Session, doReg doReg) (*RegisterPty, error) {
 		Program: program,
 		cancel:  cancelFunc}, nil
 }
+
+type AdminPty struct {
+	*tea.Program
+	cancel context.CancelFunc
+}
+
+func (a *AdminPty) Start() error {
+	_, err := a.Program.Run()
+	a.cancel()
+	return err
+}
+
+// NewAdminPty creates the operator dashboard listing every live tunnel
+func NewAdminPty(sess ssh.Session, source AdminSource) (*AdminPty, error) {
+	pty, windowCh, hasPty := sess.Pty()
+	if !hasPty {
+		return nil, errors.New("no pty")
+	}
+
+	ctx := sess.Context()
+
+	stdCtx, cancelFunc := context.WithCancel(ctx)
+
+	m := newAdminDashboard(source, min(pty.Window.Width, maxWidth), min(pty.Window.Height, maxHeight))
+
+	program := setupProgram(ctx, sess, pty.Term, sess.Environ(), m)
+
+	// Start window size monitoring
+	go func() {
+		for {
+			select {
+			case <-stdCtx.Done():
+				program.Quit()
+				return
+			case newSize := <-windowCh:
+				if newSize.Height == 0 || newSize.Width == 0 {
+					continue
+				}
+				program.Send(tea.WindowSizeMsg{
+					Width:  min(newSize.Width, maxWidth),
+					Height: min(newSize.Height, maxHeight),
+				})
+			}
+		}
+	}()
+
+	return &AdminPty{
+		Program: program,
+		cancel:  cancelFunc,
+	}, nil
+}
@@ -0,0 +1,248 @@
+// Package upgrade hands listening sockets to a newly executed binary so a
+// deploy does not refuse connections while the old process drains.
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvListeners lists the inherited listeners as name:fd pairs separated by commas
+const EnvListeners = "ECHOGY_LISTENERS"
+
+// EnvPrevious is the address where the previous process keeps serving what it still
+// holds while it drains
+const EnvPrevious = "ECHOGY_PREVIOUS"
+
+// firstExtraFd is the descriptor of the first os/exec ExtraFiles entry in the child
+const firstExtraFd = 3
+
+var (
+	mu        sync.Mutex
+	listeners = make(map[string]net.Listener)
+	inherited map[string]uintptr
+	pidFile   string
+	handoff   func() (string, error)
+	upgraded  bool
+)
+
+func parseInherited() (map[string]uintptr, error) {
+	fds := make(map[string]uintptr)
+	value := os.Getenv(EnvListeners)
+	if "" == value {
+		return fds, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, fd, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid %s entry %q", EnvListeners, pair)
+		}
+		n, err := strconv.ParseUint(fd, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %v", EnvListeners, pair, err)
+		}
+		fds[name] = uintptr(n)
+	}
+	return fds, nil
+}
+
+// Listen returns the listener inherited under name, or a new one on addr
+func Listen(name, network, addr string) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if nil == inherited {
+		fds, err := parseInherited()
+		if err != nil {
+			return nil, err
+		}
+		inherited = fds
+	}
+
+	var ln net.Listener
+	var err error
+	if fd, ok := inherited[name]; ok {
+		delete(inherited, name)
+		f := os.NewFile(fd, name)
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherit listener %s: %v", name, err)
+		}
+	} else {
+		ln, err = net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listeners[name] = ln
+	return ln, nil
+}
+
+// SetHandoff registers open, which Upgrade calls for the address passed to the new
+// process as EnvPrevious
+func SetHandoff(open func() (string, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	handoff = open
+}
+
+// Previous returns the address of the previous process, empty without one
+func Previous() string {
+	return os.Getenv(EnvPrevious)
+}
+
+// Upgraded reports whether a new process took over from this one
+func Upgraded() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return upgraded
+}
+
+// Inherited reports whether this process was started by Upgrade
+func Inherited() bool {
+	return "" != os.Getenv(EnvListeners)
+}
+
+// SetPidFile sets the pid file written by Ready and watched by Upgrade
+func SetPidFile(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	pidFile = path
+}
+
+// Ready records this process in the pid file, telling a parent waiting in Upgrade to drain
+func Ready() error {
+	mu.Lock()
+	path := pidFile
+	mu.Unlock()
+	if "" == path {
+		return nil
+	}
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d", filepath.Base(path), os.Getpid()))
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readPid(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// RemovePidFile removes the pid file unless a newer process has taken it over
+func RemovePidFile() {
+	mu.Lock()
+	path := pidFile
+	mu.Unlock()
+	if "" != path && readPid(path) == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+// Upgrade starts the current executable with the same arguments, handing it the
+// listeners opened by Listen, and waits until it calls Ready or gives up after timeout.
+// The caller keeps serving on failure and starts draining on success.
+func Upgrade(timeout time.Duration) (*os.Process, error) {
+	mu.Lock()
+	path := pidFile
+	names := make([]string, 0, len(listeners))
+	files := make([]*os.File, 0, len(listeners))
+	for name, ln := range listeners {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			mu.Unlock()
+			closeFiles(files)
+			return nil, fmt.Errorf("dup listener %s: %v", name, err)
+		}
+		names = append(names, fmt.Sprintf("%s:%d", name, firstExtraFd+len(files)))
+		files = append(files, f)
+	}
+	open := handoff
+	mu.Unlock()
+	defer closeFiles(files)
+
+	if "" == path {
+		return nil, errors.New("upgrade needs a pid file")
+	}
+	env := append(withoutListeners(os.Environ()), EnvListeners+"="+strings.Join(names, ","))
+	if nil != open {
+		addr, err := open()
+		if err != nil {
+			return nil, fmt.Errorf("open handoff: %v", err)
+		}
+		env = append(env, EnvPrevious+"="+addr)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = env
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err = <-exited:
+			return nil, fmt.Errorf("new process exited before it was ready: %v", err)
+		case <-deadline.C:
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("new process not ready after %s", timeout)
+		case <-ticker.C:
+			if readPid(path) == cmd.Process.Pid {
+				mu.Lock()
+				upgraded = true
+				mu.Unlock()
+				return cmd.Process, nil
+			}
+		}
+	}
+}
+
+func withoutListeners(environ []string) []string {
+	result := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvListeners+"=") && !strings.HasPrefix(kv, EnvPrevious+"=") {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package upgrade
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	listeners = make(map[string]net.Listener)
+	inherited = nil
+	pidFile = ""
+	handoff = nil
+	upgraded = false
+}
+
+func TestListenInherited(t *testing.T) {
+	reset()
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer parent.Close()
+	f, err := parent.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+	t.Setenv(EnvListeners, fmt.Sprintf("facade:%d", f.Fd()))
+
+	ln, err := Listen("facade", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != parent.Addr().String() {
+		t.Errorf("inherited addr = %v, want %v", ln.Addr(), parent.Addr())
+	}
+
+	// names without an inherited descriptor bind a new socket
+	other, err := Listen("ssh", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer other.Close()
+	if other.Addr().String() == parent.Addr().String() {
+		t.Errorf("ssh listener reused the facade socket")
+	}
+}
+
+func TestListenInvalidEnv(t *testing.T) {
+	reset()
+	t.Setenv(EnvListeners, "facade")
+	if _, err := Listen("facade", "tcp", "127.0.0.1:0"); err == nil {
+		t.Errorf("Listen() error = nil, want invalid entry error")
+	}
+}
+
+func TestPidFile(t *testing.T) {
+	reset()
+	path := filepath.Join(t.TempDir(), "echogy.pid")
+	SetPidFile(path)
+	if err := Ready(); err != nil {
+		t.Fatalf("Ready() error = %v", err)
+	}
+	if readPid(path) != os.Getpid() {
+		t.Fatalf("pid file = %v, want %v", readPid(path), os.Getpid())
+	}
+
+	// a newer process owns the file, it must survive our exit
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getpid()+1)), 0644)
+	RemovePidFile()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("pid file of the new process removed: %v", err)
+	}
+
+	Ready()
+	RemovePidFile()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file kept after exit: %v", err)
+	}
+}
+
+func TestWithoutListeners(t *testing.T) {
+	environ := []string{
+		"HOME=/root",
+		EnvListeners + "=facade:3",
+		EnvPrevious + "=127.0.0.1:4000",
+		"ECHOGY_LISTENERS_OLD=x",
+	}
+	want := []string{"HOME=/root", "ECHOGY_LISTENERS_OLD=x"}
+	if got := withoutListeners(environ); !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutListeners() = %v, want %v", got, want)
+	}
+}
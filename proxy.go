@@ -0,0 +1,334 @@
+package echogy
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/limit"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// headerEdits changes the fields of a header: removes first, then sets, then adds
+type headerEdits struct {
+	remove []string
+	set    http.Header
+	add    http.Header
+}
+
+func (e *headerEdits) empty() bool {
+	return 0 == len(e.remove) && 0 == len(e.set) && 0 == len(e.add)
+}
+
+func (e *headerEdits) apply(h http.Header) {
+	for _, name := range e.remove {
+		h.Del(name)
+	}
+	for name, values := range e.set {
+		h[name] = append([]string(nil), values...)
+	}
+	for name, values := range e.add {
+		h[name] = append(h[name], values...)
+	}
+}
+
+// headerRules rewrite the requests forwarded to the client and the responses sent back
+type headerRules struct {
+	host      string // replaces the Host of requests, empty keeps the visitor's
+	forwarded bool   // adds the visitor to X-Forwarded-* and Forwarded headers
+	request   headerEdits
+	response  headerEdits
+}
+
+// framingHeaders delimit messages on the connection, rules must not change them
+var framingHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+func isTokenChar(r rune) bool {
+	return r < 0x7f && (r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+}
+
+func validHeaderName(name string) error {
+	if "" == name || -1 != strings.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if framingHeaders[http.CanonicalHeaderKey(name)] {
+		return fmt.Errorf("header %s can not be changed", http.CanonicalHeaderKey(name))
+	}
+	return nil
+}
+
+// headerFieldFlag collects repeated "Name: value" flags into a header
+type headerFieldFlag struct {
+	header *http.Header
+}
+
+func (f headerFieldFlag) String() string { return "" }
+
+func (f headerFieldFlag) Set(field string) error {
+	name, value, found := strings.Cut(field, ":")
+	if !found {
+		return fmt.Errorf("%q is not Name: value", field)
+	}
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if err := validHeaderName(name); err != nil {
+		return err
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid value of header %s", name)
+	}
+	if nil == *f.header {
+		*f.header = make(http.Header)
+	}
+	f.header.Add(name, value)
+	return nil
+}
+
+// headerNameFlag collects repeated header names
+type headerNameFlag struct {
+	names *[]string
+}
+
+func (f headerNameFlag) String() string { return "" }
+
+func (f headerNameFlag) Set(name string) error {
+	if err := validHeaderName(name); err != nil {
+		return err
+	}
+	*f.names = append(*f.names, http.CanonicalHeaderKey(name))
+	return nil
+}
+
+// tunnelOptions are given as session command, `ssh -R 80:localhost:3000 host [json] [flags]`
+type tunnelOptions struct {
+	json          bool
+	rules         *headerRules // nil without rules, the tunnel then pipes bytes
+	proxyProtocol int          // PROXY protocol version written ahead of each conn, 0 for none
+}
+
+func tunnelFlags(options *tunnelOptions, output io.Writer) *flag.FlagSet {
+	rules := options.rules
+	flags := flag.NewFlagSet("tunnel", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Var(proxyProtocolFlag{&options.proxyProtocol}, "proxy-protocol", "write a PROXY protocol `v1` or v2 header ahead of each conn")
+	flags.BoolVar(&rules.forwarded, "forwarded-headers", false, "add the visitor to X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto and Forwarded")
+	flags.StringVar(&rules.host, "host-header", "", "replace the Host of requests, such as `localhost:3000`")
+	flags.Var(headerFieldFlag{&rules.request.set}, "set-header", "set a request header, `Name: value`")
+	flags.Var(headerFieldFlag{&rules.request.add}, "add-header", "add a request header, `Name: value`")
+	flags.Var(headerNameFlag{&rules.request.remove}, "remove-header", "remove a request header")
+	flags.Var(headerFieldFlag{&rules.response.set}, "set-response-header", "set a response header, `Name: value`")
+	flags.Var(headerFieldFlag{&rules.response.add}, "add-response-header", "add a response header, `Name: value`")
+	flags.Var(headerNameFlag{&rules.response.remove}, "remove-response-header", "remove a response header")
+	return flags
+}
+
+// parseTunnelOptions parses the command of a tunnel session, errors and help are written to output
+func parseTunnelOptions(cmd []string, output io.Writer) (*tunnelOptions, error) {
+	rules := &headerRules{}
+	options := &tunnelOptions{rules: rules}
+	if len(cmd) > 0 && "json" == cmd[0] {
+		options.json = true
+		cmd = cmd[1:]
+	}
+	flags := tunnelFlags(options, output)
+	if err := flags.Parse(cmd); err != nil {
+		return nil, err
+	}
+	var err error
+	switch {
+	case flags.NArg() > 0:
+		err = fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	case strings.ContainsAny(rules.host, " \t\r\n/"):
+		err = fmt.Errorf("invalid host %q", rules.host)
+	}
+	if err != nil {
+		fmt.Fprintln(output, err)
+		return nil, err
+	}
+	if "" == rules.host && !rules.forwarded && rules.request.empty() && rules.response.empty() {
+		options.rules = nil
+	}
+	return options, nil
+}
+
+// setForwardedHeaders appends the visitor to the forwarded headers it came with
+func setForwardedHeaders(pr *httputil.ProxyRequest) {
+	ip, _, err := net.SplitHostPort(pr.In.RemoteAddr)
+	if err != nil {
+		ip = pr.In.RemoteAddr
+	}
+	proto := pr.In.Header.Get("X-Forwarded-Proto")
+	if "" == proto {
+		proto = "http"
+	}
+	forwardedFor := ip
+	if prior := pr.In.Header["X-Forwarded-For"]; len(prior) > 0 {
+		forwardedFor = strings.Join(prior, ", ") + ", " + ip
+	}
+	pr.Out.Header.Set("X-Forwarded-For", forwardedFor)
+	pr.Out.Header.Set("X-Forwarded-Host", pr.In.Host)
+	pr.Out.Header.Set("X-Forwarded-Proto", proto)
+
+	node := ip
+	if strings.Contains(ip, ":") {
+		node = `"[` + ip + `]"`
+	}
+	forwarded := fmt.Sprintf("for=%s;host=%q;proto=%s", node, pr.In.Host, proto)
+	if prior := pr.In.Header["Forwarded"]; len(prior) > 0 {
+		forwarded = strings.Join(prior, ", ") + ", " + forwarded
+	}
+	pr.Out.Header.Set("Forwarded", forwarded)
+}
+
+// proxyListener hands a single facade conn to an http.Server, Accept fails once the conn is done
+type proxyListener struct {
+	conn net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	// Serve calls Accept from one goroutine
+	if conn := l.conn; nil != conn {
+		l.conn = nil
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *proxyListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *proxyListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// meteredConn counts the bytes of a conn, reads are paced by the bandwidth of the tunnel
+type meteredConn struct {
+	net.Conn
+	reader  io.Reader
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func newMeteredConn(conn net.Conn, bandwidth *limit.Bandwidth) *meteredConn {
+	return &meteredConn{Conn: conn, reader: bandwidth.Reader(conn)}
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// proxy serves the requests of facadeConn with a reverse proxy applying the header rules,
+// each connection to the client is a forwarded-tcpip channel
+func (fwd *forwarder) proxy(traceCtx context.Context, facadeConn net.Conn, rules *headerRules) (bytesIn, bytesOut int64) {
+	visitor := newMeteredConn(facadeConn, fwd.bandwidth)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ch, err := fwd.openChannel(traceCtx, facadeConn)
+			if err != nil {
+				return nil, err
+			}
+			svrConn := fwd.sshCtx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+			return newMeteredConn(wrapChannelConn(svrConn, ch), fwd.bandwidth), nil
+		},
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: 1,
+	}
+	defer transport.CloseIdleConnections()
+
+	dest := fwd.getForwardDest()
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = net.JoinHostPort(dest.BindAddr, fmt.Sprint(dest.BindPort))
+			pr.Out.Host = pr.In.Host
+			// forwarded headers of the visitor pass as they do without rules
+			for _, name := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+				if values, found := pr.In.Header[name]; found {
+					pr.Out.Header[name] = values
+				}
+			}
+			if rules.forwarded {
+				setForwardedHeaders(pr)
+			}
+			if "" != rules.host {
+				pr.Out.Host = rules.host
+			}
+			rules.request.apply(pr.Out.Header)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rules.response.apply(resp.Header)
+			return nil
+		},
+		Transport: transport,
+		ErrorLog:  log.New(io.Discard, "", 0),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if !errors.Is(err, context.Canceled) {
+				logger.Warn("proxy request failed", map[string]interface{}{
+					"module":   "conn",
+					"accessId": fwd.accessId,
+					"error":    err.Error(),
+				})
+			}
+			http.Error(w, fmt.Sprintf("Tunnel %s: the local service is not reachable", fwd.accessId), http.StatusBadGateway)
+		},
+	}
+
+	ln := &proxyListener{conn: visitor, done: make(chan struct{})}
+	var hijacked atomic.Bool
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxy.ServeHTTP(w, r)
+			// an upgraded conn, such as a WebSocket, is closed once the proxy returns
+			if hijacked.Load() {
+				ln.Close()
+			}
+		}),
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateHijacked:
+				hijacked.Store(true)
+			case http.StateClosed:
+				ln.Close()
+			}
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	server.Serve(ln)
+	facadeConn.Close()
+	return visitor.read.Load(), visitor.written.Load()
+}
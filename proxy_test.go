@@ -0,0 +1,302 @@
+package echogy
+
+import (
+	"bufio"
+	"context"
+	gossh "golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTunnelOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		json    bool
+		rules   *headerRules
+		proxy   int
+		invalid bool
+	}{
+		{"none", "", false, nil, 0, false},
+		{"json", "json", true, nil, 0, false},
+		{"host", "--host-header localhost:3000", false, &headerRules{host: "localhost:3000"}, 0, false},
+		{"json and headers", "json --set-header X-Env:dev --add-header X-Tag:a --add-header X-Tag:b --remove-header cookie",
+			true, &headerRules{request: headerEdits{
+				remove: []string{"Cookie"},
+				set:    http.Header{"X-Env": {"dev"}},
+				add:    http.Header{"X-Tag": {"a", "b"}},
+			}}, 0, false},
+		{"response headers", "--set-response-header Cache-Control:no-store --remove-response-header Server",
+			false, &headerRules{response: headerEdits{
+				remove: []string{"Server"},
+				set:    http.Header{"Cache-Control": {"no-store"}},
+			}}, 0, false},
+		{"framing header", "--remove-header Content-Length", false, nil, 0, true},
+		{"host as header", "--set-header Host:evil", false, nil, 0, true},
+		{"bad name", "--set-header X(1):a", false, nil, 0, true},
+		{"no value", "--set-header X-Env", false, nil, 0, true},
+		{"bad host", "--host-header a/b", false, nil, 0, true},
+		{"forwarded headers", "--forwarded-headers", false, &headerRules{forwarded: true}, 0, false},
+		{"proxy protocol", "json --proxy-protocol v2", true, nil, proxyProtocolV2, false},
+		{"proxy protocol and rules", "--proxy-protocol v1 --forwarded-headers", false, &headerRules{forwarded: true}, proxyProtocolV1, false},
+		{"proxy protocol version", "--proxy-protocol v3", false, nil, 0, true},
+		{"argument", "status", false, nil, 0, true},
+		{"unknown flag", "--rewrite a", false, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := parseTunnelOptions(strings.Fields(tt.cmd), io.Discard)
+			if tt.invalid {
+				if nil == err {
+					t.Errorf("parseTunnelOptions(%q) succeeded", tt.cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if options.json != tt.json || !reflect.DeepEqual(options.rules, tt.rules) || options.proxyProtocol != tt.proxy {
+				t.Errorf("parseTunnelOptions(%q) = %v %+v %d, want %v %+v %d", tt.cmd,
+					options.json, options.rules, options.proxyProtocol, tt.json, tt.rules, tt.proxy)
+			}
+		})
+	}
+}
+
+func TestHeaderEditsApply(t *testing.T) {
+	edits := &headerEdits{
+		remove: []string{"Cookie", "X-Env"},
+		set:    http.Header{"X-Env": {"dev"}},
+		add:    http.Header{"Via": {"echogy"}},
+	}
+	h := http.Header{"Cookie": {"a=1"}, "X-Env": {"prod", "test"}, "Via": {"cdn"}, "Accept": {"*/*"}}
+	edits.apply(h)
+	want := http.Header{"X-Env": {"dev"}, "Via": {"cdn", "echogy"}, "Accept": {"*/*"}}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("apply = %v, want %v", h, want)
+	}
+}
+
+// serveForwards connects the forwarded-tcpip channels of client to addr
+func serveForwards(channels <-chan gossh.NewChannel, addr string) {
+	for newChan := range channels {
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go gossh.DiscardRequests(reqs)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			ch.Close()
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			defer conn.Close()
+			go io.Copy(conn, ch)
+			io.Copy(ch, conn)
+		}()
+	}
+}
+
+// startLocalService serves handler as the local service of a tunnel and returns its address
+func startLocalService(t *testing.T, handler http.HandlerFunc) string {
+	local := httptest.NewServer(handler)
+	t.Cleanup(local.Close)
+	return local.Listener.Addr().String()
+}
+
+// startProxyTunnel opens a tunnel with the options cmd to the local service at addr,
+// it returns the tunnel host, the facade address and the session output after the URLs
+func startProxyTunnel(t *testing.T, addr string, cmd string) (string, string, *bufio.Reader) {
+	facadeLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go facadeServe(ctx, facadeLn, func(facadeId string, req *hijackHttp) bool {
+		if fwd, found := lookupForwarder(facadeId); found {
+			fwd.dispatchRemoteForward(req)
+			return true
+		}
+		return false
+	})
+
+	client, err := dialTestServer(startTestServer(t, nil), newTestSigner(t, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+	})
+	go serveForwards(client.HandleChannelOpen("forwarded-tcpip"), addr)
+	if !forward(client) {
+		t.Fatal("forward refused")
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Start(cmd); err != nil {
+		t.Fatal(err)
+	}
+	out := bufio.NewReader(stdout)
+	line, err := out.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.ReadString('\n')
+	return strings.TrimSpace(strings.TrimPrefix(line, "HTTP:  http://")), facadeLn.Addr().String(), out
+}
+
+func TestProxyHeaderRules(t *testing.T) {
+	received := make(chan *http.Request, 4)
+	host, facadeAddr, out := startProxyTunnel(t, startLocalService(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		w.Header().Set("Server", "dev-server")
+		w.Write([]byte("hello"))
+	}), "--host-header localhost:3000 --set-header X-Env:dev --remove-header Cookie "+
+		"--set-response-header Cache-Control:no-store --remove-response-header Server")
+
+	// both requests of a keep-alive connection are rewritten
+	visitor := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1}}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+facadeAddr+"/", nil)
+		req.Host = host
+		req.Header.Set("Cookie", "session=1")
+		resp, err := visitor.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if "hello" != string(body) || "no-store" != resp.Header.Get("Cache-Control") || "" != resp.Header.Get("Server") {
+			t.Errorf("response %d = %q %v", i, body, resp.Header)
+		}
+		r := <-received
+		if "localhost:3000" != r.Host || "dev" != r.Header.Get("X-Env") || "" != r.Header.Get("Cookie") {
+			t.Errorf("request %d host %q header %v", i, r.Host, r.Header)
+		}
+	}
+	// requests are still recorded on the visitor side
+	line, err := out.ReadString('\n')
+	if err != nil || !strings.Contains(line, "GET / 200") {
+		t.Errorf("request line = %q, %v", line, err)
+	}
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	host, facadeAddr, _ := startProxyTunnel(t, startLocalService(t, func(w http.ResponseWriter, r *http.Request) {
+		if "dev" != r.Header.Get("X-Env") {
+			http.Error(w, "missing header", http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}), "--set-header X-Env:dev")
+
+	conn, err := net.Dial("tcp", facadeAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: " + host + "\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if http.StatusSwitchingProtocols != resp.StatusCode {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	conn.Write([]byte("ping\n"))
+	if line, err := reader.ReadString('\n'); err != nil || "ping\n" != line {
+		t.Errorf("echo = %q, %v", line, err)
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	host, facadeAddr, _ := startProxyTunnel(t, startLocalService(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}), "--forwarded-headers")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+facadeAddr+"/", nil)
+	req.Host = host
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	r := <-received
+	want := map[string]string{
+		"X-Forwarded-For":   "192.0.2.1, 127.0.0.1",
+		"X-Forwarded-Host":  host,
+		"X-Forwarded-Proto": "https",
+		"Forwarded":         `for=127.0.0.1;host="` + host + `";proto=https`,
+	}
+	for name, value := range want {
+		if got := r.Header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestProxyProtocolTunnel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	headers := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		header, _ := reader.ReadString('\n')
+		headers <- header
+		if _, err = http.ReadRequest(reader); err == nil {
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok"))
+		}
+	}()
+	host, facadeAddr, _ := startProxyTunnel(t, ln.Addr().String(), "--proxy-protocol v1")
+
+	conn, err := net.Dial("tcp", facadeAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	_, visitorPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+	_, facadePort, _ := net.SplitHostPort(facadeAddr)
+	want := "PROXY TCP4 127.0.0.1 127.0.0.1 " + visitorPort + " " + facadePort + "\r\n"
+	if header := <-headers; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+}
@@ -0,0 +1,105 @@
+package echogy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Versions of the PROXY protocol header written ahead of each forwarded conn
+const (
+	proxyProtocolNone = 0
+	proxyProtocolV1   = 1
+	proxyProtocolV2   = 2
+)
+
+// proxyProtocolSignature starts every version 2 header
+var proxyProtocolSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolFlag parses `v1` or `v2`
+type proxyProtocolFlag struct {
+	version *int
+}
+
+func (f proxyProtocolFlag) String() string { return "" }
+
+func (f proxyProtocolFlag) Set(value string) error {
+	switch value {
+	case "v1":
+		*f.version = proxyProtocolV1
+	case "v2":
+		*f.version = proxyProtocolV2
+	default:
+		return fmt.Errorf("unknown PROXY protocol version %q, use v1 or v2", value)
+	}
+	return nil
+}
+
+// tcpAddrs returns both addresses as TCP addresses of the same family,
+// the IPv4 family when both are IPv4, nil when either is not TCP
+func tcpAddrs(src, dst net.Addr) (*net.TCPAddr, *net.TCPAddr, bool) {
+	s, ok := src.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false
+	}
+	d, ok := dst.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false
+	}
+	return s, d, nil != s.IP.To4() && nil != d.IP.To4()
+}
+
+// proxyProtocolHeader tells the client's local service that the conn came from src to dst
+func proxyProtocolHeader(version int, src, dst net.Addr) []byte {
+	if proxyProtocolV1 == version {
+		return proxyProtocolV1Header(src, dst)
+	}
+	return proxyProtocolV2Header(src, dst)
+}
+
+func proxyProtocolV1Header(src, dst net.Addr) []byte {
+	s, d, ipv4 := tcpAddrs(src, dst)
+	if nil == s {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family, srcIP, dstIP := "TCP4", s.IP.To4().String(), d.IP.To4().String()
+	if !ipv4 {
+		family, srcIP, dstIP = "TCP6", ipv6String(s.IP), ipv6String(d.IP)
+	}
+	return []byte("PROXY " + family + " " + srcIP + " " + dstIP + " " +
+		strconv.Itoa(s.Port) + " " + strconv.Itoa(d.Port) + "\r\n")
+}
+
+// ipv6String formats ip in the IPv6 family, IPv4 addresses as mapped ones
+func ipv6String(ip net.IP) string {
+	if v4 := ip.To4(); nil != v4 {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
+
+func proxyProtocolV2Header(src, dst net.Addr) []byte {
+	header := append([]byte(nil), proxyProtocolSignature...)
+	// version 2, PROXY command
+	header = append(header, 0x21)
+	s, d, ipv4 := tcpAddrs(src, dst)
+	var addrs []byte
+	switch {
+	case nil == s:
+		// unspecified family, the receiver keeps the addresses of the conn
+		header = append(header, 0x00)
+	case ipv4:
+		header = append(header, 0x11)
+		addrs = append(append(addrs, s.IP.To4()...), d.IP.To4()...)
+	default:
+		header = append(header, 0x21)
+		addrs = append(append(addrs, s.IP.To16()...), d.IP.To16()...)
+	}
+	if nil != s {
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(s.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(d.Port))
+	}
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
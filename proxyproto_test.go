@@ -0,0 +1,41 @@
+package echogy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 80}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51234}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	unix := &net.UnixAddr{Name: "/tmp/s", Net: "unix"}
+	signature := string(proxyProtocolSignature)
+	tests := []struct {
+		name    string
+		version int
+		src     net.Addr
+		dst     net.Addr
+		want    string
+	}{
+		{"v1 tcp4", proxyProtocolV1, v4src, v4dst, "PROXY TCP4 192.0.2.1 198.51.100.2 51234 80\r\n"},
+		{"v1 tcp6", proxyProtocolV1, v6src, v6dst, "PROXY TCP6 2001:db8::1 2001:db8::2 51234 443\r\n"},
+		{"v1 mixed", proxyProtocolV1, v4src, v6dst, "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 51234 443\r\n"},
+		{"v1 unknown", proxyProtocolV1, unix, v4dst, "PROXY UNKNOWN\r\n"},
+		{"v2 tcp4", proxyProtocolV2, v4src, v4dst, signature + "\x21\x11\x00\x0c" +
+			"\xc0\x00\x02\x01" + "\xc6\x33\x64\x02" + "\xc8\x22" + "\x00\x50"},
+		{"v2 tcp6", proxyProtocolV2, v6src, v6dst, signature + "\x21\x21\x00\x24" +
+			"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+			"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" + "\xc8\x22" + "\x01\xbb"},
+		{"v2 unknown", proxyProtocolV2, v4src, unix, signature + "\x21\x00\x00\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxyProtocolHeader(tt.version, tt.src, tt.dst); !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("proxyProtocolHeader = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package echogy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// liveConfig is the configuration in effect, replaced by Reload
+var liveConfig atomic.Pointer[Config]
+
+// redactedKeys are never written to the log, nor is anything under redactedPrefixes
+var (
+	redactedKeys = map[string]bool{
+		"privateKey":  true,
+		"admin.token": true,
+	}
+	// tracing headers usually carry the API key of the collector
+	redactedPrefixes = []string{"tracing.headers."}
+)
+
+func redacted(key string) bool {
+	if redactedKeys[key] {
+		return true
+	}
+	for _, prefix := range redactedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigChange is a setting that differs between two configurations
+type ConfigChange struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+func (c ConfigChange) String() string {
+	if redacted(c.Key) {
+		return fmt.Sprintf("%s: changed", c.Key)
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.Key, c.Old, c.New)
+}
+
+func flatten(prefix string, value interface{}, into map[string]interface{}) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		into[prefix] = value
+		return
+	}
+	for k, v := range m {
+		key := k
+		if "" != prefix {
+			key = prefix + "." + k
+		}
+		flatten(key, v, into)
+	}
+}
+
+func flatConfig(c *Config) map[string]interface{} {
+	result := make(map[string]interface{})
+	data, err := json.Marshal(c)
+	if err != nil {
+		return result
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return result
+	}
+	flatten("", m, result)
+	return result
+}
+
+// DiffConfig lists the settings that differ between old and new, sorted by key
+func DiffConfig(old, new *Config) []ConfigChange {
+	before, after := flatConfig(old), flatConfig(new)
+	keys := make(map[string]bool)
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	changes := make([]ConfigChange, 0)
+	for k := range keys {
+		if !reflect.DeepEqual(before[k], after[k]) {
+			changes = append(changes, ConfigChange{Key: k, Old: before[k], New: after[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// restartOnly reports whether key belongs to a setting bound at startup
+func restartOnly(key string) bool {
+	for _, prefix := range []string{"httpAddr", "SSHAddr", "privateKey", "privateKeyFile", "hostKeys", "hostKeyDir", "ban.file", "ban.logFile", "subdomains", "admin.addr", "admin.token", "tracing"} {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// hostKeySetting reports whether key changes the host keys offered in handshakes
+func hostKeySetting(key string) bool {
+	return "hostCertificate" == key || "hostCertificateFile" == key || "ssh.hostKeyAlgorithms" == key
+}
+
+// keepBound copies the settings that need a restart from old into next
+func keepBound(old, next *Config) {
+	next.HttpAddr = old.HttpAddr
+	next.SSHAddr = old.SSHAddr
+	next.PrivateKey = old.PrivateKey
+	next.PrivateKeyFile = old.PrivateKeyFile
+	next.HostKeys = old.HostKeys
+	next.HostKeyDir = old.HostKeyDir
+	next.Subdomains = old.Subdomains
+	next.Tracing = old.Tracing
+	if nil != next.Ban || nil != old.Ban {
+		ban := BanConfig{}
+		if nil != next.Ban {
+			ban = *next.Ban
+		}
+		ban.File, ban.LogFile = "", ""
+		if nil != old.Ban {
+			ban.File, ban.LogFile = old.Ban.File, old.Ban.LogFile
+		}
+		next.Ban = &ban
+	}
+	if nil == old.Admin {
+		next.Admin = nil
+	} else {
+		admin := AdminConfig{}
+		if nil != next.Admin {
+			admin = *next.Admin
+		}
+		admin.Addr = old.Admin.Addr
+		admin.Token = old.Admin.Token
+		next.Admin = &admin
+	}
+}
+
+// keepHostKeys copies the host key settings from old into next
+func keepHostKeys(old, next *Config) {
+	next.HostCertificate = old.HostCertificate
+	next.HostCertificateFile = old.HostCertificateFile
+	if nil != next.SSH {
+		ssh := *next.SSH
+		ssh.HostKeyAlgorithms = old.SSH.hostKeyAlgorithms()
+		next.SSH = &ssh
+	}
+}
+
+// reloadHostKeys reads the host keys of next again, so renewed certificates are
+// offered to new connections. It reports false when the keys themselves changed.
+func reloadHostKeys(keys *hostKeys, next *Config) (bool, error) {
+	signers, err := next.hostSigners(true)
+	if err != nil {
+		return false, fmt.Errorf("load host keys: %v", err)
+	}
+	renewed, err := keys.update(signers, next.SSH.hostKeyAlgorithms())
+	if errors.Is(err, errHostKeysChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("host keys: %v", err)
+	}
+	if renewed {
+		logger.Warn("host certificate renewed", map[string]interface{}{
+			"module": "reload",
+		})
+	}
+	return true, nil
+}
+
+func applyConfig(config *Config) {
+	stat.SetHistoryOptions(config.History.options())
+	if nil != config.Access && nil != config.Access.AnonymousLimits {
+		anonymousTunnels.SetMax(config.Access.AnonymousLimits.MaxTunnelsPerIP)
+	}
+	if nil != config.Admin {
+		maxAliases := config.Admin.MaxAliases
+		if maxAliases <= 0 {
+			maxAliases = 100
+		}
+		metrics.SetAliasLabel(config.Admin.AliasLabel, maxAliases)
+	}
+	if err := bans.setPolicy(config.Ban); err != nil {
+		logger.Error("apply ban policy", err, map[string]interface{}{
+			"module": "reload",
+		})
+	}
+	if limits := liveSSHLimits.Load(); nil != limits {
+		limits.set(config.SSH)
+	}
+}
+
+// Reload applies the settings of config that can change while serving: the domain
+// of new tunnels, history retention, metric labels, alias reservations, the access
+// policy, the ssh and ban policies, host certificates and the shutdown timeout.
+// Listener addresses, host key material, the ban and subdomain files, the admin token
+// and tracing keep their running values until restart. It returns the changes in effect.
+func Reload(config *Config) ([]ConfigChange, error) {
+	old := liveConfig.Load()
+	if nil == old {
+		return nil, fmt.Errorf("echogy is not serving")
+	}
+	next := *config
+	keepBound(old, &next)
+	// everything that can fail comes before anything is applied
+	var reserved map[string]*Reservation
+	if nil != next.Admin && (nil == old.Admin || old.Admin.ReservationsFile != next.Admin.ReservationsFile) {
+		var err error
+		if reserved, err = readReservations(next.Admin.ReservationsFile); err != nil {
+			return nil, fmt.Errorf("load alias reservations: %v", err)
+		}
+	}
+	hostKeysLive := true
+	if keys := liveHostKeys.Load(); nil != keys {
+		var err error
+		if hostKeysLive, err = reloadHostKeys(keys, &next); err != nil {
+			return nil, err
+		}
+	}
+	if nil != reserved {
+		reservations.replace(next.Admin.ReservationsFile, reserved)
+	}
+	changes := DiffConfig(old, config)
+	applied := make([]ConfigChange, 0, len(changes))
+	for _, change := range changes {
+		if restartOnly(change.Key) || !hostKeysLive && hostKeySetting(change.Key) {
+			logger.Warn("config change needs a restart, ignored", map[string]interface{}{
+				"module": "reload",
+				"change": change.String(),
+			})
+			continue
+		}
+		applied = append(applied, change)
+	}
+	if !hostKeysLive {
+		keepHostKeys(old, &next)
+	}
+	applyConfig(&next)
+	liveConfig.Store(&next)
+	warnNoAccess(&next)
+	for _, change := range applied {
+		logger.Warn("config changed", map[string]interface{}{
+			"module": "reload",
+			"change": change.String(),
+		})
+	}
+	return applied, nil
+}
@@ -0,0 +1,159 @@
+package echogy
+
+import (
+	"github.com/echogy-io/echogy/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := &Config{
+		HttpAddr:   ":80",
+		Domain:     "webs.sh",
+		PrivateKey: "old-key",
+		History:    &HistoryConfig{MaxEntries: 52},
+		Admin:      &AdminConfig{Addr: ":9090", Token: "old"},
+		Tracing:    &TracingConfig{Headers: map[string]string{"Authorization": "Bearer old"}},
+	}
+	next := &Config{
+		HttpAddr:   ":80",
+		Domain:     "echogy.io",
+		PrivateKey: "new-key",
+		History:    &HistoryConfig{MaxEntries: 100},
+		Admin:      &AdminConfig{Addr: ":9090", Token: "new"},
+		Tracing:    &TracingConfig{Headers: map[string]string{"Authorization": "Bearer new", "X-Api-Key": "key"}},
+	}
+
+	got := make([]string, 0)
+	for _, change := range DiffConfig(old, next) {
+		got = append(got, change.String())
+	}
+	want := []string{
+		"admin.token: changed",
+		"domain: webs.sh -> echogy.io",
+		"history.maxEntries: 52 -> 100",
+		"privateKey: changed",
+		"tracing.headers.Authorization: changed",
+		"tracing.headers.X-Api-Key: changed",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DiffConfig() = %q, want %q", got, want)
+	}
+}
+
+func TestReload(t *testing.T) {
+	old := &Config{
+		HttpAddr:        ":80",
+		SSHAddr:         ":22",
+		Domain:          "webs.sh",
+		ShutdownTimeout: Duration(time.Second),
+		Admin:           &AdminConfig{Addr: ":9090", Token: "secret"},
+	}
+	liveConfig.Store(old)
+	defer liveConfig.Store(nil)
+
+	changes, err := Reload(&Config{
+		HttpAddr:        ":8080",
+		SSHAddr:         ":22",
+		Domain:          "echogy.io",
+		ShutdownTimeout: Duration(time.Minute),
+		Admin:           &AdminConfig{Addr: ":9191", Token: "other", AliasLabel: true},
+	})
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	keys := make([]string, 0)
+	for _, change := range changes {
+		keys = append(keys, change.Key)
+	}
+	if strings.Join(keys, ",") != "admin.aliasLabel,domain,shutdownTimeout" {
+		t.Errorf("applied = %v", keys)
+	}
+
+	live := liveConfig.Load()
+	if live.Domain != "echogy.io" || live.shutdownTimeout() != time.Minute || !live.Admin.AliasLabel {
+		t.Errorf("live config = %+v, want reloaded settings", live)
+	}
+	if live.HttpAddr != ":80" || live.Admin.Addr != ":9090" || live.Admin.Token != "secret" {
+		t.Errorf("live config = %+v, bound settings changed", live)
+	}
+}
+
+func TestReloadRedactsSecrets(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "echogy.log")
+	if err := logger.AddFileOutput(logFile); err != nil {
+		t.Fatal(err)
+	}
+	liveConfig.Store(&Config{
+		PrivateKey: "old-key",
+		Admin:      &AdminConfig{Token: "old-token"},
+		Tracing:    &TracingConfig{Endpoint: "otel:4318", Headers: map[string]string{"Authorization": "Bearer old-secret"}},
+	})
+	defer liveConfig.Store(nil)
+
+	if _, err := Reload(&Config{
+		PrivateKey: "new-key",
+		Admin:      &AdminConfig{Token: "new-token"},
+		Tracing:    &TracingConfig{Endpoint: "otel:4318", Headers: map[string]string{"Authorization": "Bearer new-secret"}},
+	}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "tracing.headers.Authorization: changed") {
+		t.Errorf("log misses the header change:\n%s", data)
+	}
+	for _, secret := range []string{"old-key", "new-key", "old-token", "new-token", "old-secret", "new-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("log contains %q:\n%s", secret, data)
+		}
+	}
+}
+
+func TestReloadPolicies(t *testing.T) {
+	liveConfig.Store(&Config{
+		SSH: &SSHConfig{MaxSessionsPerKey: 1},
+		Ban: &BanConfig{MaxFailures: 5, File: "bans.json"},
+	})
+	limits := newSSHLimits(liveConfig.Load().SSH)
+	liveSSHLimits.Store(limits)
+	defer func() {
+		liveConfig.Store(nil)
+		liveSSHLimits.Store(nil)
+		bans.configure(nil)
+	}()
+
+	changes, err := Reload(&Config{
+		SSH: &SSHConfig{MaxSessionsPerKey: 2, HandshakesPerMinute: 60, IdleTimeout: Duration(time.Minute)},
+		Ban: &BanConfig{MaxFailures: 2, File: "other.json"},
+	})
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	keys := make([]string, 0)
+	for _, change := range changes {
+		keys = append(keys, change.Key)
+	}
+	if strings.Join(keys, ",") != "ban.maxFailures,ssh.handshakesPerMinute,ssh.idleTimeout,ssh.maxSessionsPerKey" {
+		t.Errorf("applied = %v", keys)
+	}
+
+	if nil == limits.handshakeLimiter() || limits.current().idleTimeout() != time.Minute {
+		t.Errorf("ssh policy = %+v, want the reloaded one", limits.current())
+	}
+	session := limits.sessionLimiter()
+	if !session.Acquire("key") || !session.Acquire("key") || session.Acquire("key") {
+		t.Error("sessions per key are not the reloaded limit")
+	}
+	if 2 != bans.maxFailures {
+		t.Errorf("ban maxFailures = %d, want 2", bans.maxFailures)
+	}
+	if live := liveConfig.Load(); "bans.json" != live.Ban.File {
+		t.Errorf("ban file = %q, want the bound one", live.Ban.File)
+	}
+}
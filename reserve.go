@@ -0,0 +1,144 @@
+package echogy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reservation keeps an alias for its owner, no other session is given the alias
+type Reservation struct {
+	Alias string `json:"alias"`
+	// Owner is the SHA256 key fingerprint allowed to use the alias, empty means
+	// only clients configured with the alias in auth
+	Owner     string    `json:"owner,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type reservationStore struct {
+	mu    sync.RWMutex
+	items map[string]*Reservation
+	path  string
+}
+
+var reservations = &reservationStore{items: make(map[string]*Reservation)}
+
+// load replaces the reservations with the ones persisted at path, empty path keeps new ones in memory only
+func (s *reservationStore) load(path string) error {
+	items, err := readReservations(path)
+	if err != nil {
+		return err
+	}
+	s.replace(path, items)
+	return nil
+}
+
+// readReservations reads the reservations persisted at path, a missing file has none
+func readReservations(path string) (map[string]*Reservation, error) {
+	items := make(map[string]*Reservation)
+	if "" == path {
+		return items, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Reservation, 0)
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		items[r.Alias] = r
+	}
+	return items, nil
+}
+
+func (s *reservationStore) replace(path string, items map[string]*Reservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.items = items
+}
+
+func (s *reservationStore) saveLocked() error {
+	if "" == s.path {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *reservationStore) listLocked() []*Reservation {
+	items := make([]*Reservation, 0, len(s.items))
+	for _, r := range s.items {
+		items = append(items, r)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Alias < items[j].Alias
+	})
+	return items
+}
+
+func (s *reservationStore) List() []*Reservation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked()
+}
+
+func (s *reservationStore) Get(alias string) (*Reservation, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, found := s.items[alias]
+	return r, found
+}
+
+// Reserve adds or replaces the reservation of r.Alias
+func (s *reservationStore) Reserve(r *Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	s.items[r.Alias] = r
+	return s.saveLocked()
+}
+
+// Release removes the reservation of alias, it reports whether one existed
+func (s *reservationStore) Release(alias string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.items[alias]; !found {
+		return false, nil
+	}
+	delete(s.items, alias)
+	return true, s.saveLocked()
+}
+
+// Allowed reports whether a client with the configured alias and key fingerprint may use alias
+func (s *reservationStore) Allowed(alias, configuredAlias, fingerprint string) bool {
+	r, found := s.Get(alias)
+	if !found {
+		return true
+	}
+	if configuredAlias == alias {
+		return true
+	}
+	return "" != r.Owner && r.Owner == fingerprint
+}
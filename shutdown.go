@@ -0,0 +1,83 @@
+package echogy
+
+import (
+	"context"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/gliderlabs/ssh"
+	"time"
+)
+
+const drainPollInterval = 100 * time.Millisecond
+
+// rangeForwarders calls f with each running tunnel, skipping pending ones
+func rangeForwarders(f func(fwd *forwarder)) {
+	sessionHub.Range(func(key, value interface{}) bool {
+		if fwd, ok := value.(*forwarder); ok {
+			f(fwd)
+		}
+		return true
+	})
+}
+
+// activeConns counts the forwarded connections of all tunnels
+func activeConns() int64 {
+	var active int64
+	rangeForwarders(func(fwd *forwarder) {
+		active += fwd.chanCounter.Load()
+	})
+	return active
+}
+
+// activeTunnels counts the running tunnels
+func activeTunnels() int64 {
+	var active int64
+	rangeForwarders(func(fwd *forwarder) {
+		active++
+	})
+	return active
+}
+
+// waitIdle polls active until it reports nothing in flight, it reports false when ctx ends first
+func waitIdle(ctx context.Context, interval time.Duration, active func() int64) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for active() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// drain stops accepting SSH connections, warns clients and waits up to timeout
+// until active reports nothing left before closing everything
+func drain(server *ssh.Server, timeout time.Duration, active func() int64) {
+	deadline := time.Now().Add(timeout)
+	drainCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// Shutdown closes the listeners right away and returns once drainCtx is done
+	go server.Shutdown(drainCtx)
+
+	rangeForwarders(func(fwd *forwarder) {
+		go fwd.view.Shutdown(deadline)
+	})
+
+	fields := map[string]interface{}{
+		"module":  "serve",
+		"timeout": timeout.String(),
+		"active":  active(),
+	}
+	logger.Warn("draining connections", fields)
+	if !waitIdle(drainCtx, drainPollInterval, active) {
+		fields["active"] = active()
+		logger.Warn("drain timed out, closing connections", fields)
+	}
+
+	rangeForwarders(func(fwd *forwarder) {
+		fwd.Close()
+	})
+	server.Close()
+}
@@ -0,0 +1,35 @@
+package echogy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitIdle(t *testing.T) {
+	var active atomic.Int64
+	active.Store(2)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !waitIdle(ctx, 5*time.Millisecond, active.Load) {
+		t.Fatalf("waitIdle() = false, want true once connections finish")
+	}
+
+	active.Store(1)
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if waitIdle(ctx, 5*time.Millisecond, active.Load) {
+		t.Fatalf("waitIdle() = true, want false on timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waitIdle() took %v past its deadline", elapsed)
+	}
+}
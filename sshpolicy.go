@@ -0,0 +1,356 @@
+package echogy
+
+import (
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/limit"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/metrics"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const sshSessionSlot = "sshSessionSlot"
+
+// algorithmLists pairs each algorithm setting with what golang.org/x/crypto/ssh implements
+func (c *SSHConfig) algorithmLists() []struct {
+	field     string
+	names     []string
+	supported []string
+	insecure  []string
+} {
+	supported, insecure := gossh.SupportedAlgorithms(), gossh.InsecureAlgorithms()
+	return []struct {
+		field     string
+		names     []string
+		supported []string
+		insecure  []string
+	}{
+		{"ssh.ciphers", c.Ciphers, supported.Ciphers, insecure.Ciphers},
+		{"ssh.keyExchanges", c.KeyExchanges, supported.KeyExchanges, insecure.KeyExchanges},
+		{"ssh.macs", c.MACs, supported.MACs, insecure.MACs},
+		{"ssh.hostKeyAlgorithms", c.HostKeyAlgorithms, supported.HostKeys, insecure.HostKeys},
+		{"ssh.publicKeyAlgorithms", c.PublicKeyAlgorithms, supported.PublicKeyAuths, insecure.PublicKeyAuths},
+	}
+}
+
+func (c *SSHConfig) validate() []error {
+	var errs []error
+	for _, list := range c.algorithmLists() {
+		for _, name := range list.names {
+			if !slices.Contains(list.supported, name) && !slices.Contains(list.insecure, name) {
+				errs = append(errs, fmt.Errorf("%s: %q is not supported, use one of %v", list.field, name, list.supported))
+			}
+		}
+	}
+	if c.MaxAuthTries < 0 || c.HandshakesPerMinute < 0 || c.HandshakeBurst < 0 || c.MaxSessionsPerKey < 0 || c.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("ssh: limits must not be negative"))
+	}
+	return errs
+}
+
+// insecure lists the configured algorithms with known weaknesses
+func (c *SSHConfig) insecure() []string {
+	if nil == c {
+		return nil
+	}
+	result := make([]string, 0)
+	for _, list := range c.algorithmLists() {
+		for _, name := range list.names {
+			if slices.Contains(list.insecure, name) {
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+// serverConfig returns the base configuration of each connection, gliderlabs/ssh adds
+// the host keys and callbacks
+func (c *SSHConfig) serverConfig() *gossh.ServerConfig {
+	config := &gossh.ServerConfig{}
+	if nil == c {
+		return config
+	}
+	config.Ciphers = c.Ciphers
+	config.KeyExchanges = c.KeyExchanges
+	config.MACs = c.MACs
+	config.PublicKeyAuthAlgorithms = c.PublicKeyAlgorithms
+	config.MaxAuthTries = c.MaxAuthTries
+	return config
+}
+
+func (c *SSHConfig) idleTimeout() time.Duration {
+	if nil == c {
+		return 0
+	}
+	return time.Duration(c.IdleTimeout)
+}
+
+func (c *SSHConfig) hostKeyAlgorithms() []string {
+	if nil == c {
+		return nil
+	}
+	return c.HostKeyAlgorithms
+}
+
+// handshakeRate returns the connections per second and burst allowed per client IP,
+// zero when unlimited
+func (c *SSHConfig) handshakeRate() (float64, int) {
+	if nil == c || 0 == c.HandshakesPerMinute {
+		return 0, 0
+	}
+	burst := c.HandshakeBurst
+	if 0 == burst {
+		burst = max(int(c.HandshakesPerMinute), 1)
+	}
+	return c.HandshakesPerMinute / 60, burst
+}
+
+func (c *SSHConfig) maxSessionsPerKey() int {
+	if nil == c {
+		return 0
+	}
+	return c.MaxSessionsPerKey
+}
+
+// sshLimits hold the ssh policy of a server and its limiters, set changes them while
+// serving and established connections keep their session slots
+type sshLimits struct {
+	policy     atomic.Pointer[SSHConfig]
+	handshakes *limit.Rate
+	sessions   *limit.Concurrent
+}
+
+// liveSSHLimits are the limits of the serving ssh server, updated by Reload
+var liveSSHLimits atomic.Pointer[sshLimits]
+
+func newSSHLimits(policy *SSHConfig) *sshLimits {
+	l := &sshLimits{
+		handshakes: limit.NewRate(0, 1),
+		sessions:   limit.NewConcurrent(0),
+	}
+	l.set(policy)
+	return l
+}
+
+func (l *sshLimits) set(policy *SSHConfig) {
+	perSecond, burst := policy.handshakeRate()
+	l.handshakes.SetRate(perSecond, burst)
+	l.sessions.SetMax(policy.maxSessionsPerKey())
+	l.policy.Store(policy)
+}
+
+func (l *sshLimits) current() *SSHConfig {
+	return l.policy.Load()
+}
+
+// handshakeLimiter limits connections per client IP, nil when unlimited
+func (l *sshLimits) handshakeLimiter() *limit.Rate {
+	if perSecond, _ := l.current().handshakeRate(); 0 == perSecond {
+		return nil
+	}
+	return l.handshakes
+}
+
+// sessionLimiter counts connections per public key, nil when unlimited
+func (l *sshLimits) sessionLimiter() *limit.Concurrent {
+	if 0 == l.current().maxSessionsPerKey() {
+		return nil
+	}
+	return l.sessions
+}
+
+// idleConn closes an ssh connection without traffic for the idle timeout in effect.
+// The keepalives of the server are no traffic, they only check the client is there.
+type idleConn struct {
+	net.Conn
+	limits *sshLimits
+
+	mu sync.Mutex
+	// pending counts the keepalives on their way and back, sent all of them since
+	// none was pending and traffic the reads and writes seen meanwhile
+	pending, sent, traffic int
+}
+
+func newIdleConn(conn net.Conn, limits *sshLimits) *idleConn {
+	c := &idleConn{Conn: conn, limits: limits}
+	c.extendLocked()
+	return c
+}
+
+func (c *idleConn) active() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending > 0 {
+		c.traffic++
+		return
+	}
+	c.extendLocked()
+}
+
+func (c *idleConn) extendLocked() {
+	if timeout := c.limits.current().idleTimeout(); timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(timeout))
+	} else {
+		c.Conn.SetDeadline(time.Time{})
+	}
+}
+
+// keepalive sends a keepalive with send. A request and its reply leave the deadline
+// alone, any traffic beyond them is the client's and extends it once all are back.
+func (c *idleConn) keepalive(send func() error) error {
+	c.mu.Lock()
+	c.sent++
+	c.pending++
+	c.mu.Unlock()
+	err := send()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending--; 0 == c.pending {
+		if c.traffic > 2*c.sent {
+			c.extendLocked()
+		}
+		c.sent, c.traffic = 0, 0
+	}
+	return err
+}
+
+// Read extends the deadline once data came, a blocked read waits for it
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.active()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.active()
+	}
+	return n, err
+}
+
+// SetDeadline ignores the deadlines of the ssh server, the idle timeout owns them
+func (c *idleConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+// rsaCertAlgorithms maps the RSA certificate algorithms to the signature they use
+var rsaCertAlgorithms = map[string]string{
+	gossh.CertAlgoRSAv01:       gossh.KeyAlgoRSA,
+	gossh.CertAlgoRSASHA256v01: gossh.KeyAlgoRSASHA256,
+	gossh.CertAlgoRSASHA512v01: gossh.KeyAlgoRSASHA512,
+}
+
+// signerAlgorithms lists the host key algorithms a signer is offered with
+func signerAlgorithms(signer gossh.Signer) []string {
+	switch keyType := signer.PublicKey().Type(); keyType {
+	case gossh.KeyAlgoRSA:
+		return []string{gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSA}
+	case gossh.CertAlgoRSAv01:
+		return []string{gossh.CertAlgoRSASHA512v01, gossh.CertAlgoRSASHA256v01, gossh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
+
+// restrictHostKeys offers signers with the allowed algorithms only, dropping signers
+// with none of them. An empty allowed list keeps all.
+func restrictHostKeys(signers []ssh.Signer, allowed []string) ([]ssh.Signer, error) {
+	if 0 == len(allowed) {
+		return signers, nil
+	}
+	result := make([]ssh.Signer, 0, len(signers))
+	for _, signer := range signers {
+		offered := signerAlgorithms(signer)
+		algorithms := make([]string, 0, len(offered))
+		for _, algorithm := range offered {
+			if slices.Contains(allowed, algorithm) {
+				if plain, ok := rsaCertAlgorithms[algorithm]; ok {
+					algorithm = plain
+				}
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		switch {
+		case 0 == len(algorithms):
+			continue
+		case len(algorithms) == len(offered):
+			result = append(result, signer)
+			continue
+		}
+		as, ok := signer.(gossh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("host key %s cannot be restricted to %v", signer.PublicKey().Type(), algorithms)
+		}
+		restricted, err := gossh.NewSignerWithAlgorithms(as, algorithms)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, restricted)
+	}
+	if 0 == len(result) {
+		return nil, fmt.Errorf("ssh.hostKeyAlgorithms: no host key supports any of %v", allowed)
+	}
+	return result, nil
+}
+
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// admitHandshake applies the per-IP handshake rate to a new connection
+func admitHandshake(limiter *limit.Rate, conn net.Conn) bool {
+	ip := remoteIP(conn.RemoteAddr())
+	if limiter.Allow(ip) {
+		return true
+	}
+	metrics.SSHRejected.WithLabelValues("handshake_rate").Inc()
+	logger.Warn("ssh handshake rate exceeded", map[string]interface{}{
+		"module": "policy",
+		"ip":     ip,
+	})
+	return false
+}
+
+// admitSession takes a session slot of the client key, released when the connection ends
+func admitSession(limiter *limit.Concurrent, ctx ssh.Context) bool {
+	fingerprint, _ := ctx.Value(clientPublicKeyFingerprintSha256).(string)
+	if nil == limiter || "" == fingerprint {
+		return true
+	}
+	ctx.Lock()
+	defer ctx.Unlock()
+	if nil != ctx.Value(sshSessionSlot) {
+		return true
+	}
+	if !limiter.Acquire(fingerprint) {
+		metrics.SSHRejected.WithLabelValues("sessions_per_key").Inc()
+		logger.Warn("too many sessions for key", map[string]interface{}{
+			"module":      "policy",
+			"fingerprint": fingerprint,
+			"ip":          remoteIP(ctx.RemoteAddr()),
+		})
+		return false
+	}
+	ctx.SetValue(sshSessionSlot, true)
+	go func() {
+		<-ctx.Done()
+		limiter.Release(fingerprint)
+	}()
+	return true
+}
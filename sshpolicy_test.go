@@ -0,0 +1,233 @@
+package echogy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSSHConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SSHConfig
+		want   string
+	}{
+		{"defaults", SSHConfig{}, ""},
+		{"supported", SSHConfig{Ciphers: []string{"aes256-gcm@openssh.com"}, KeyExchanges: []string{"curve25519-sha256"}, HostKeyAlgorithms: []string{"ssh-ed25519"}}, ""},
+		{"insecure", SSHConfig{MACs: []string{"hmac-sha1-96"}}, ""},
+		{"unknown cipher", SSHConfig{Ciphers: []string{"aes256-gcm"}}, `ssh.ciphers: "aes256-gcm" is not supported`},
+		{"cipher as mac", SSHConfig{MACs: []string{"aes128-ctr"}}, `ssh.macs: "aes128-ctr" is not supported`},
+		{"negative", SSHConfig{MaxSessionsPerKey: -1}, "ssh: limits must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.config.validate()
+			if "" == tt.want {
+				if 0 != len(errs) {
+					t.Errorf("validate() = %v", errs)
+				}
+				return
+			}
+			if 1 != len(errs) || !strings.HasPrefix(errs[0].Error(), tt.want) {
+				t.Errorf("validate() = %v, want %q", errs, tt.want)
+			}
+		})
+	}
+	if insecure := (&SSHConfig{MACs: []string{"hmac-sha1-96", "hmac-sha2-256"}}).insecure(); 1 != len(insecure) || "hmac-sha1-96" != insecure[0] {
+		t.Errorf("insecure() = %v", insecure)
+	}
+}
+
+func TestRestrictHostKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner := newTestSigner(t, false)
+	signers := []ssh.Signer{rsaSigner, edSigner}
+
+	// want lists the algorithms of each signer left, nil for a signer kept as is
+	tests := []struct {
+		allowed []string
+		want    [][]string
+	}{
+		{nil, [][]string{nil, nil}},
+		{[]string{gossh.KeyAlgoED25519, gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSA}, [][]string{nil, nil}},
+		{[]string{gossh.KeyAlgoRSASHA512}, [][]string{{gossh.KeyAlgoRSASHA512}}},
+		{[]string{gossh.KeyAlgoED25519}, [][]string{nil}},
+	}
+	for _, tt := range tests {
+		got, err := restrictHostKeys(signers, tt.allowed)
+		if err != nil {
+			t.Fatalf("restrictHostKeys(%v) = %v", tt.allowed, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("restrictHostKeys(%v) = %d signers, want %d", tt.allowed, len(got), len(tt.want))
+		}
+		for i, signer := range got {
+			if nil == tt.want[i] {
+				if signer.PublicKey().Type() == gossh.KeyAlgoRSA && signer != rsaSigner || signer.PublicKey().Type() == gossh.KeyAlgoED25519 && signer != edSigner {
+					t.Errorf("restrictHostKeys(%v)[%d] was restricted", tt.allowed, i)
+				}
+				continue
+			}
+			multi, ok := signer.(gossh.MultiAlgorithmSigner)
+			if !ok || strings.Join(multi.Algorithms(), ",") != strings.Join(tt.want[i], ",") {
+				t.Errorf("restrictHostKeys(%v)[%d] = %v, want %v", tt.allowed, i, signer, tt.want[i])
+			}
+		}
+	}
+	if _, err = restrictHostKeys(signers, []string{gossh.KeyAlgoECDSA256}); nil == err {
+		t.Error("restrictHostKeys() without a matching key must fail")
+	}
+}
+
+func startTestServer(t *testing.T, policy *SSHConfig) string {
+	allowAccess(t, AccessConfig{Register: true})
+	server := newSessionServer("", "webs.sh", newHostKeys([]gossh.Signer{newTestSigner(t, false)}), 80, nil, newSSHLimits(policy))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return ln.Addr().String()
+}
+
+func dialTestServer(addr string, signer gossh.Signer, ciphers []string) (*gossh.Client, error) {
+	return gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User:            "register",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Config:          gossh.Config{Ciphers: ciphers},
+		Timeout:         5 * time.Second,
+	})
+}
+
+func forward(client *gossh.Client) bool {
+	ok, _, err := client.SendRequest(sshSessionTypeForward, true, gossh.Marshal(&remoteForwardRequest{}))
+	return nil == err && ok
+}
+
+func TestSSHConnectionLimits(t *testing.T) {
+	addr := startTestServer(t, &SSHConfig{
+		Ciphers:             []string{"aes256-gcm@openssh.com"},
+		HandshakesPerMinute: 1,
+		HandshakeBurst:      3,
+		MaxSessionsPerKey:   1,
+	})
+	key := newTestSigner(t, false)
+
+	if _, err := dialTestServer(addr, key, []string{"aes128-ctr"}); nil == err {
+		t.Fatal("handshake with a cipher outside the policy succeeded")
+	}
+
+	first, err := dialTestServer(addr, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if !forward(first) {
+		t.Fatal("first session refused")
+	}
+
+	second, err := dialTestServer(addr, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if forward(second) {
+		t.Error("second session of the same key admitted")
+	}
+
+	// the burst of three handshakes is used up
+	if c, err := dialTestServer(addr, newTestSigner(t, false), nil); nil == err {
+		c.Close()
+		t.Error("handshake beyond the rate limit succeeded")
+	}
+	if !forward(first) {
+		t.Error("first session closed by the limits of others")
+	}
+}
+
+func TestSSHSessionSlotReleased(t *testing.T) {
+	addr := startTestServer(t, &SSHConfig{MaxSessionsPerKey: 1})
+	key := newTestSigner(t, false)
+	for i := 0; i < 3; i++ {
+		client, err := dialTestServer(addr, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		admitted := forward(client)
+		client.Close()
+		if !admitted {
+			t.Fatalf("session %d refused after the previous one closed", i)
+		}
+		// the slot is released when the server notices the closed connection
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestSSHIdleTimeoutIgnoresKeepalives(t *testing.T) {
+	defer func(interval time.Duration) { keepaliveInterval = interval }(keepaliveInterval)
+	keepaliveInterval = 50 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		traffic bool
+		closed  bool
+	}{
+		{name: "idle apart from keepalives", closed: true},
+		{name: "client traffic", traffic: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startTestServer(t, &SSHConfig{IdleTimeout: Duration(300 * time.Millisecond)})
+			client, err := dialTestServer(addr, newTestSigner(t, false), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if !forward(client) {
+				t.Fatal("forward refused")
+			}
+			// the client answers the keepalives of the server every 50ms
+			closed := make(chan struct{})
+			go func() {
+				client.Wait()
+				close(closed)
+			}()
+			deadline := time.After(time.Second)
+			for {
+				select {
+				case <-closed:
+					if !tt.closed {
+						t.Fatal("connection with traffic closed")
+					}
+					return
+				case <-deadline:
+					if tt.closed {
+						t.Fatal("connection idle apart from keepalives kept open")
+					}
+					return
+				case <-time.After(100 * time.Millisecond):
+					if tt.traffic {
+						client.SendRequest("ping@echogy", true, nil)
+					}
+				}
+			}
+		})
+	}
+}
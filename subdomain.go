@@ -0,0 +1,214 @@
+package echogy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/auth"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/gliderlabs/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSubdomainTTL = 30 * 24 * time.Hour
+	subdomainLength     = 12
+	// subdomainUserPrefix marks a username of an anonymous client as a subdomain request
+	subdomainUserPrefix = "+"
+)
+
+// SubdomainConfig keeps the random subdomain of each public key without a configured alias
+type SubdomainConfig struct {
+	// TTL is how long a subdomain is kept after the last connection of its key, 30 days by default
+	TTL  Duration `json:"ttl"`
+	File string   `json:"file"` // persists subdomains across restarts, empty keeps them in memory
+}
+
+func (c *SubdomainConfig) validate() []error {
+	if c.TTL < 0 {
+		return []error{fmt.Errorf("subdomains.ttl: must not be negative")}
+	}
+	return nil
+}
+
+// KeySubdomain is the subdomain given to a public key
+type KeySubdomain struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subdomain   string    `json:"subdomain"`
+	LastUsed    time.Time `json:"lastUsed"`
+}
+
+type subdomainStore struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	path  string
+	items map[string]*KeySubdomain
+	now   func() time.Time
+}
+
+var keySubdomains = newSubdomainStore()
+
+func newSubdomainStore() *subdomainStore {
+	return &subdomainStore{
+		ttl:   defaultSubdomainTTL,
+		items: make(map[string]*KeySubdomain),
+		now:   time.Now,
+	}
+}
+
+// configure applies config and loads the subdomains persisted in its file
+func (s *subdomainStore) configure(config *SubdomainConfig) error {
+	if nil == config {
+		config = &SubdomainConfig{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = orDefault(config.TTL, defaultSubdomainTTL)
+	s.path = config.File
+	s.items = make(map[string]*KeySubdomain)
+	if "" == s.path {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	items := make([]*KeySubdomain, 0)
+	if err = json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, k := range items {
+		s.items[k.Fingerprint] = k
+	}
+	return nil
+}
+
+// For returns the subdomain of the key with fingerprint, a new one when it has none
+// or did not connect within the TTL
+func (s *subdomainStore) For(fingerprint string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	k, found := s.items[fingerprint]
+	if !found || now.Sub(k.LastUsed) > s.ttl {
+		subdomain, err := generateRandomString(subdomainLength, AlphaNum)
+		if err != nil {
+			return "", err
+		}
+		k = &KeySubdomain{Fingerprint: fingerprint, Subdomain: subdomain}
+		s.items[fingerprint] = k
+	}
+	k.LastUsed = now
+	if err := s.saveLocked(now); err != nil {
+		logger.Error("save subdomains failed", err, map[string]interface{}{
+			"module": "serve",
+			"path":   s.path,
+		})
+	}
+	return k.Subdomain, nil
+}
+
+// saveLocked drops expired subdomains and writes the others to the file
+func (s *subdomainStore) saveLocked(now time.Time) error {
+	items := make([]*KeySubdomain, 0, len(s.items))
+	for fingerprint, k := range s.items {
+		if now.Sub(k.LastUsed) > s.ttl {
+			delete(s.items, fingerprint)
+			continue
+		}
+		items = append(items, k)
+	}
+	if "" == s.path {
+		return nil
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Fingerprint < items[j].Fingerprint
+	})
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Owner returns the fingerprint of the key given subdomain, while it is kept
+func (s *subdomainStore) Owner(subdomain string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, k := range s.items {
+		if k.Subdomain == subdomain && now.Sub(k.LastUsed) <= s.ttl {
+			return k.Fingerprint, true
+		}
+	}
+	return "", false
+}
+
+// Forget drops the subdomain of the key with fingerprint, it returns the subdomain
+// and whether the key had one
+func (s *subdomainStore) Forget(fingerprint string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	k, found := s.items[fingerprint]
+	if !found || now.Sub(k.LastUsed) > s.ttl {
+		return "", false, nil
+	}
+	delete(s.items, fingerprint)
+	return k.Subdomain, true, s.saveLocked(now)
+}
+
+// requestedSubdomain returns the subdomain asked for with the bind address of the remote
+// forward, as in ssh -R myapp:80:localhost:3000, or by anonymous clients with a username
+// of the form +myapp. A plain username is the default login of the client, not a request.
+func requestedSubdomain(ctx ssh.Context, domain string) string {
+	if fwd, ok := ctx.Value(sshRemoteForward).(*remoteForwardRequest); ok {
+		addr := strings.TrimSuffix(strings.ToLower(fwd.BindAddr), "."+domain)
+		switch {
+		case "" == addr, "localhost" == addr, "*" == addr, nil != net.ParseIP(addr):
+		default:
+			return addr
+		}
+	}
+	user, _ := ctx.Value(ssh.ContextKeyUser).(string)
+	if subdomain, found := strings.CutPrefix(user, subdomainUserPrefix); found && isAnonymous(ctx) {
+		return strings.ToLower(subdomain)
+	}
+	return ""
+}
+
+// subdomainRefused returns why the client with the configured alias and key fingerprint
+// may not use the subdomain it asked for, empty when it may
+func subdomainRefused(subdomain, alias, fingerprint string, authenticator auth.Auth) string {
+	if !auth.ValidRequested(subdomain) {
+		return "must be 6 to 20 lowercase letters or digits"
+	}
+	if _, found := sessionHub.Load(subdomain); found {
+		return "is in use"
+	}
+	if !reservations.Allowed(subdomain, alias, fingerprint) {
+		return "is reserved"
+	}
+	if owners, ok := authenticator.(auth.AliasOwners); ok && subdomain != alias && owners.HasAlias(subdomain) {
+		return "is reserved"
+	}
+	if owner, found := keySubdomains.Owner(subdomain); found && owner != fingerprint {
+		return "is in use"
+	}
+	return ""
+}
@@ -0,0 +1,196 @@
+package echogy
+
+import (
+	"encoding/hex"
+	"github.com/echogy-io/echogy/pkg/auth"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeySubdomains(t *testing.T) {
+	now := time.Unix(0, 0)
+	path := filepath.Join(t.TempDir(), "subdomains.json")
+	config := &SubdomainConfig{TTL: Duration(time.Hour), File: path}
+	s := newSubdomainStore()
+	s.now = func() time.Time { return now }
+	if err := s.configure(config); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.For("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subdomainLength != len(first) || !containsOnlyChars(first, AlphaNum) {
+		t.Errorf("subdomain %q is not %d alphanumeric characters", first, subdomainLength)
+	}
+	other, _ := s.For("b")
+	if other == first {
+		t.Error("two keys share a subdomain")
+	}
+
+	tests := []struct {
+		advance time.Duration
+		same    bool
+	}{
+		{30 * time.Minute, true},
+		{time.Hour, true},
+		{time.Hour + time.Second, false},
+	}
+	want := first
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		got, _ := s.For("a")
+		if (got == want) != tt.same {
+			t.Errorf("step %d: subdomain %q after %v, previous %q", i, got, tt.advance, want)
+		}
+		want = got
+	}
+
+	restarted := newSubdomainStore()
+	restarted.now = s.now
+	if err = restarted.configure(config); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restarted.For("a"); got != want {
+		t.Errorf("subdomain %q after restart, want %q", got, want)
+	}
+	if 1 != len(restarted.items) {
+		t.Errorf("%d subdomains kept, the expired one of b must be dropped", len(restarted.items))
+	}
+}
+
+// testContext is an ssh.Context holding values only
+type testContext struct {
+	ssh.Context
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+func newTestContext(values map[interface{}]interface{}) *testContext {
+	return &testContext{values: values}
+}
+
+func (c *testContext) Lock()                             { c.mu.Lock() }
+func (c *testContext) Unlock()                           { c.mu.Unlock() }
+func (c *testContext) Value(key interface{}) interface{} { return c.values[key] }
+func (c *testContext) SetValue(key, value interface{})   { c.values[key] = value }
+
+func TestRequestedSubdomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		bindAddr string
+		user     string
+		alias    string
+		want     string
+	}{
+		{"bind address", "myapp01", "carol", "", "myapp01"},
+		{"full host name", "MyApp01.webs.sh", "carol", "", "myapp01"},
+		{"bind address of configured client", "myapp01", "alice", "alice", "myapp01"},
+		{"localhost", "localhost", "+carol01", "", "carol01"},
+		{"wildcard", "*", "+Carol01", "", "carol01"},
+		{"IP address", "127.0.0.1", "+carol01", "", "carol01"},
+		{"default login", "", "ubuntu", "", ""},
+		{"username of configured client", "", "+alice01", "alice", ""},
+		{"register user", "", registerUser, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := map[interface{}]interface{}{
+				ssh.ContextKeyUser: tt.user,
+				sshRemoteForward:   &remoteForwardRequest{BindAddr: tt.bindAddr, BindPort: 80},
+			}
+			if "" != tt.alias {
+				values[clientHttpAlias] = tt.alias
+			}
+			if got := requestedSubdomain(newTestContext(values), "webs.sh"); got != tt.want {
+				t.Errorf("requestedSubdomain = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubdomainRefused(t *testing.T) {
+	authenticator := auth.New(nil, []*auth.PasswordAuth{{Username: "bob", Password: "secret", Alias: "bobsapp"}})
+	sessionHub.Store("running", &forwarder{})
+	defer sessionHub.Delete("running")
+	if err := reservations.Reserve(&Reservation{Alias: "keptapp", Owner: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	defer reservations.Release("keptapp")
+	saved := keySubdomains
+	keySubdomains = newSubdomainStore()
+	defer func() {
+		keySubdomains = saved
+	}()
+	taken, _ := keySubdomains.For("other")
+
+	tests := []struct {
+		subdomain   string
+		alias       string
+		fingerprint string
+		want        string
+	}{
+		{"myapp01", "", "", ""},
+		{"app", "", "", "must be 6 to 20 lowercase letters or digits"},
+		{"my-app01", "", "", "must be 6 to 20 lowercase letters or digits"},
+		{"running", "", "", "is in use"},
+		{"keptapp", "", "", "is reserved"},
+		{"keptapp", "", "owner", ""},
+		{"bobsapp", "", "", "is reserved"},
+		{"bobsapp", "bobsapp", "", ""},
+		{taken, "", "", "is in use"},
+		{taken, "", "other", ""},
+	}
+	for _, tt := range tests {
+		if got := subdomainRefused(tt.subdomain, tt.alias, tt.fingerprint, authenticator); got != tt.want {
+			t.Errorf("subdomainRefused(%q, %q, %q) = %q, want %q", tt.subdomain, tt.alias, tt.fingerprint, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelsClaimDistinctSubdomains(t *testing.T) {
+	addr := startTestServer(t, nil)
+	clients := make([]*gossh.Client, 4)
+	for i := range clients {
+		client, err := dialTestServer(addr, newTestSigner(t, false), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients[i] = client
+	}
+	// all clients ask for the same subdomain at once, their tunnels start together
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.SendRequest(sshSessionTypeForward, true, gossh.Marshal(&remoteForwardRequest{BindAddr: "sharedapp", BindPort: 80}))
+		}()
+	}
+	wg.Wait()
+
+	accessIds := make(map[string]string)
+	for deadline := time.Now().Add(2 * time.Second); len(accessIds) < len(clients) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		for _, client := range clients {
+			sessionId := hex.EncodeToString(client.SessionID())
+			rangeForwarders(func(fwd *forwarder) {
+				if sessionId == fwd.sshCtx.SessionID() {
+					accessIds[fwd.accessId] = sessionId
+				}
+			})
+		}
+	}
+	if len(accessIds) != len(clients) {
+		t.Fatalf("%d clients got %d distinct subdomains: %v", len(clients), len(accessIds), accessIds)
+	}
+	if _, found := accessIds["sharedapp"]; !found {
+		t.Errorf("no client got the requested subdomain: %v", accessIds)
+	}
+}
@@ -5,11 +5,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"github.com/karlseguin/ccache/v3"
 	gossh "golang.org/x/crypto/ssh"
 	"net"
 	"strconv"
-	"time"
 )
 
 const (
@@ -53,33 +51,6 @@ func generateRandomString(length int, charset string) (string, error) {
 	return string(result), nil
 }
 
-var cache = ccache.New(ccache.Configure[string]().MaxSize(1_000_000))
-
-func withIPGenerateAccessId(ip net.IP) (string, error) {
-	cacheKey := ip.String()
-	fetch, err := cache.Fetch(cacheKey, time.Hour*2, func() (string, error) {
-		return generateRandomString(12, AlphaNum)
-	})
-	if nil != fetch && nil == err {
-		return fetch.Value(), nil
-	} else {
-		return generateRandomString(12, AlphaNum)
-	}
-}
-
-func withAddrGenerateAccessId(raddr net.Addr) (string, error) {
-	switch raddr.(type) {
-	case *net.TCPAddr:
-		addr := raddr.(*net.TCPAddr)
-		return withIPGenerateAccessId(addr.IP)
-	case *net.UDPAddr:
-		addr := raddr.(*net.UDPAddr)
-		return withIPGenerateAccessId(addr.IP)
-	default:
-		return "", fmt.Errorf("unknown address type: %T", raddr)
-	}
-}
-
 func generateAccessId() (string, error) {
 	return generateRandomString(8, AlphaNum)
 }
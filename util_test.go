@@ -1,7 +1,6 @@
 package echogy
 
 import (
-	"net"
 	"strings"
 	"testing"
 )
@@ -80,87 +79,12 @@ func TestGenerateRandomString(t *testing.T) {
 }
 
 func TestGenerateAccessId(t *testing.T) {
-	tests := []struct {
-		name    string
-		raddr   net.Addr
-		wantLen int
-	}{
-		{
-			name: "TCP address",
-			raddr: &net.TCPAddr{
-				IP:   net.ParseIP("127.0.0.1"),
-				Port: 8080,
-			},
-			wantLen: 8,
-		},
-		{
-			name: "Different TCP address",
-			raddr: &net.TCPAddr{
-				IP:   net.ParseIP("192.168.1.1"),
-				Port: 22,
-			},
-			wantLen: 8,
-		},
-		{
-			name: "UDP address",
-			raddr: &net.UDPAddr{
-				IP:   net.ParseIP("127.0.0.1"),
-				Port: 8080,
-			},
-			wantLen: 8,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := withAddrGenerateAccessId(tt.raddr)
-			if err != nil {
-				t.Errorf("withAddrGenerateAccessId() error = %v", err)
-				return
-			}
-			if len(got) != tt.wantLen {
-				t.Errorf("withAddrGenerateAccessId() got length = %v, want %v", len(got), tt.wantLen)
-			}
-			if !containsOnlyChars(got, AlphaNum) {
-				t.Errorf("withAddrGenerateAccessId() got = %v, contains invalid characters", got)
-			}
-		})
-	}
-
-	// Test caching for TCP addresses
-	tcpAddr := &net.TCPAddr{
-		IP:   net.ParseIP("127.0.0.1"),
-		Port: 8080,
-	}
-	first, _ := withAddrGenerateAccessId(tcpAddr)
-	second, _ := withAddrGenerateAccessId(tcpAddr)
-	if first != second {
-		t.Error("withAddrGenerateAccessId() cache not working, got different values for same TCP address")
-	}
-}
-
-func TestWithIPGenerateAccessId(t *testing.T) {
-	ip := net.ParseIP("127.0.0.1")
-	id, err := withIPGenerateAccessId(ip)
-	if err != nil {
-		t.Errorf("withIPGenerateAccessId() error = %v", err)
-	}
-	if len(id) != 8 {
-		t.Errorf("withIPGenerateAccessId() got length = %v, want 8", len(id))
-	}
-}
-
-func TestWithAddrGenerateAccessId(t *testing.T) {
-	addr := &net.TCPAddr{
-		IP:   net.ParseIP("127.0.0.1"),
-		Port: 8080,
-	}
-	id, err := withAddrGenerateAccessId(addr)
+	id, err := generateAccessId()
 	if err != nil {
-		t.Errorf("withAddrGenerateAccessId() error = %v", err)
+		t.Fatalf("generateAccessId() error = %v", err)
 	}
-	if len(id) != 8 {
-		t.Errorf("withAddrGenerateAccessId() got length = %v, want 8", len(id))
+	if len(id) != 8 || !containsOnlyChars(id, AlphaNum) {
+		t.Errorf("generateAccessId() = %v, want 8 alphanumeric characters", id)
 	}
 }
 
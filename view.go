@@ -0,0 +1,447 @@
+package echogy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/echogy-io/echogy/pkg/logger"
+	"github.com/echogy-io/echogy/pkg/stat"
+	"github.com/echogy-io/echogy/pkg/tui"
+	"github.com/gliderlabs/ssh"
+	"io"
+	"sync"
+	"time"
+)
+
+// tunnelView shows a tunnel to its client
+type tunnelView interface {
+	// Start shows the tunnel until the client leaves
+	Start() error
+	// Request shows a forwarded request once its response is complete
+	Request(e *stat.RequestEntity)
+	// ConnOpened and ConnClosed show a forwarded connection of a visitor
+	ConnOpened(id int64, remoteAddr string)
+	ConnClosed(id int64, bytesIn, bytesOut int64, duration time.Duration)
+	// Warn tells the client about something that may end the tunnel
+	Warn(message string)
+	// Shutdown warns that the server closes the tunnel at deadline
+	Shutdown(deadline time.Time)
+	// Goodbye tells the client why the tunnel ends
+	Goodbye(reason string)
+	// Close ends the session of the view, if any
+	Close() error
+}
+
+// newViewFunc creates the view of a tunnel once its URL is known
+type newViewFunc func(tunnel string, expiresAt time.Time) (tunnelView, error)
+
+// sessionView picks the view of a session: the dashboard with a PTY, otherwise
+// plain text lines, or JSON lines for the json command
+func sessionView(session ssh.Session, json bool) newViewFunc {
+	return func(tunnel string, expiresAt time.Time) (tunnelView, error) {
+		if _, _, hasPty := session.Pty(); hasPty {
+			pty, err := tui.NewHttpReverseProxyPty(session, tunnel, expiresAt)
+			if err != nil {
+				return nil, err
+			}
+			return &ptyView{pty: pty, session: session}, nil
+		}
+		return &textView{
+			ctx:       session.Context(),
+			session:   session,
+			out:       session,
+			json:      json,
+			tunnel:    tunnel,
+			expiresAt: expiresAt,
+			done:      make(chan struct{}),
+		}, nil
+	}
+}
+
+// ptyView is the dashboard of an interactive session
+type ptyView struct {
+	pty     *tui.HttpReversProxyPty
+	session ssh.Session
+}
+
+func (v *ptyView) Start() error {
+	return v.pty.Start()
+}
+
+func (v *ptyView) Request(*stat.RequestEntity) {
+	v.pty.Update()
+}
+
+// the dashboard shows connections and the expiry from the stats of the tunnel
+func (v *ptyView) ConnOpened(int64, string)                      {}
+func (v *ptyView) ConnClosed(int64, int64, int64, time.Duration) {}
+func (v *ptyView) Warn(string)                                   {}
+
+func (v *ptyView) Shutdown(deadline time.Time) {
+	v.pty.Shutdown(deadline)
+}
+
+// Goodbye restores the terminal before writing reason, a dashboard that does not quit
+// within a second is left behind
+func (v *ptyView) Goodbye(reason string) {
+	stopped := make(chan struct{})
+	go func() {
+		v.pty.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+	}
+	fmt.Fprintf(v.session.Stderr(), "\r\n%s\r\n", reason)
+}
+
+func (v *ptyView) Close() error {
+	return v.session.Exit(0)
+}
+
+// textView writes a line per event for sessions without a PTY, such as ssh -T in scripts
+type textView struct {
+	ctx       context.Context
+	session   ssh.Session // nil without a session, such as with ssh -N
+	out       io.Writer
+	json      bool
+	tunnel    string
+	expiresAt time.Time
+	mu        sync.Mutex
+	done      chan struct{}
+	closed    sync.Once
+}
+
+// silentView serves a tunnel without a session to write to
+func silentView(ctx context.Context) newViewFunc {
+	return func(tunnel string, expiresAt time.Time) (tunnelView, error) {
+		return &textView{ctx: ctx, out: io.Discard, tunnel: tunnel, expiresAt: expiresAt, done: make(chan struct{})}, nil
+	}
+}
+
+// sharedView shows a tunnel started by a forward request before any session, a session
+// opened later attaches its own view until it ends
+type sharedView struct {
+	mu        sync.Mutex
+	silent    tunnelView
+	attached  tunnelView
+	tunnel    string
+	expiresAt time.Time
+	// notices the tunnel gave before a session attached
+	notices []tunnelNotice
+	// session is set while a session waits for the tunnel or shows it
+	session bool
+	// ready is closed once the tunnel has its URL, ended once runTunnel returned
+	ready chan struct{}
+	ended chan struct{}
+}
+
+type tunnelNotice struct {
+	event   string
+	message string
+}
+
+func newSharedView() *sharedView {
+	return &sharedView{ready: make(chan struct{}), ended: make(chan struct{})}
+}
+
+// newView wraps silent, the tunnel it is made for is kept for the views of sessions
+func (v *sharedView) newView(silent newViewFunc) newViewFunc {
+	return func(tunnel string, expiresAt time.Time) (tunnelView, error) {
+		view, err := silent(tunnel, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.silent, v.tunnel, v.expiresAt = view, tunnel, expiresAt
+		v.mu.Unlock()
+		close(v.ready)
+		return v, nil
+	}
+}
+
+// notice keeps a notice of the tunnel for the sessions to come
+func (v *sharedView) notice(event, message string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.notices = append(v.notices, tunnelNotice{event, message})
+}
+
+// end marks the tunnel over, it reports whether a session is left to close the connection
+func (v *sharedView) end() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	close(v.ended)
+	return v.session
+}
+
+// show tells a session the notices of the tunnel and attaches the view made by newView
+// until it returns from Start. It reports false when another session shows the tunnel.
+func (v *sharedView) show(ctx context.Context, newView newViewFunc, notice noticeFunc) bool {
+	v.mu.Lock()
+	if v.session {
+		v.mu.Unlock()
+		return false
+	}
+	v.session = true
+	v.mu.Unlock()
+	defer func() {
+		v.mu.Lock()
+		v.session = false
+		v.attached = nil
+		v.mu.Unlock()
+	}()
+
+	select {
+	case <-v.ready:
+	case <-v.ended:
+	case <-ctx.Done():
+	}
+	v.mu.Lock()
+	notices := v.notices
+	v.mu.Unlock()
+	for _, n := range notices {
+		notice(n.event, n.message)
+	}
+	select {
+	case <-v.ready:
+	default:
+		// the tunnel could not start, the notices told why
+		return true
+	}
+
+	view, err := newView(v.tunnel, v.expiresAt)
+	if err != nil {
+		notice(eventError, "Cannot show the tunnel.")
+		return true
+	}
+	v.mu.Lock()
+	select {
+	case <-v.ended:
+		v.mu.Unlock()
+		return true
+	default:
+	}
+	v.attached = view
+	v.mu.Unlock()
+	if err = view.Start(); err != nil {
+		logger.Error("start tunnel view", err, map[string]interface{}{
+			"module": "view",
+			"tunnel": v.tunnel,
+		})
+	}
+	return true
+}
+
+func (v *sharedView) current() tunnelView {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if nil != v.attached {
+		return v.attached
+	}
+	return v.silent
+}
+
+// Start waits as the silent view does, sessions start their own views
+func (v *sharedView) Start() error {
+	return v.silent.Start()
+}
+
+func (v *sharedView) Request(e *stat.RequestEntity) {
+	v.current().Request(e)
+}
+
+func (v *sharedView) ConnOpened(id int64, remoteAddr string) {
+	v.current().ConnOpened(id, remoteAddr)
+}
+
+func (v *sharedView) ConnClosed(id int64, bytesIn, bytesOut int64, duration time.Duration) {
+	v.current().ConnClosed(id, bytesIn, bytesOut, duration)
+}
+
+func (v *sharedView) Warn(message string) {
+	v.current().Warn(message)
+}
+
+func (v *sharedView) Shutdown(deadline time.Time) {
+	v.current().Shutdown(deadline)
+}
+
+func (v *sharedView) Goodbye(reason string) {
+	v.current().Goodbye(reason)
+}
+
+// Close ends the attached session too
+func (v *sharedView) Close() error {
+	v.mu.Lock()
+	attached := v.attached
+	v.mu.Unlock()
+	if nil != attached {
+		attached.Close()
+	}
+	return v.silent.Close()
+}
+
+// Events of the JSON stream, each line is an object with the event name and time.
+// Fields are only ever added, consumers should ignore those they do not know.
+const (
+	eventTunnelReady  = "tunnel_ready"
+	eventRequest      = "request"
+	eventConnOpen     = "conn_open"
+	eventConnClose    = "conn_close"
+	eventWarning      = "warning"
+	eventError        = "error"
+	eventTunnelClosed = "tunnel_closed"
+	// eventSchema is raised when a field changes meaning or goes away
+	eventSchema = 1
+)
+
+type eventHeader struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+type readyEvent struct {
+	eventHeader
+	Schema    int        `json:"schema"`
+	URL       string     `json:"url"`
+	HTTPURL   string     `json:"httpUrl"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type requestEvent struct {
+	eventHeader
+	Method   string `json:"method"`
+	Host     string `json:"host"`
+	URI      string `json:"uri"`
+	Status   int    `json:"status"`
+	TTFB     int64  `json:"ttfbMs"`
+	Duration int64  `json:"durationMs"`
+}
+
+type connEvent struct {
+	eventHeader
+	Conn       int64  `json:"conn"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	BytesIn    *int64 `json:"bytesIn,omitempty"`
+	BytesOut   *int64 `json:"bytesOut,omitempty"`
+	Duration   *int64 `json:"durationMs,omitempty"`
+}
+
+type messageEvent struct {
+	eventHeader
+	Message string `json:"message"`
+}
+
+func writeJSONLine(w io.Writer, event interface{}) {
+	json.NewEncoder(w).Encode(event)
+}
+
+func newMessageEvent(event, message string) *messageEvent {
+	return &messageEvent{eventHeader{event, time.Now()}, message}
+}
+
+// noticeFunc tells the client about its tunnel before the view starts,
+// event is eventWarning or eventError
+type noticeFunc func(event, message string)
+
+func sessionNotice(session ssh.Session, json bool) noticeFunc {
+	if json {
+		return func(event, message string) {
+			writeJSONLine(session, newMessageEvent(event, message))
+		}
+	}
+	return func(_, message string) {
+		fmt.Fprintf(session.Stderr(), "%s\r\n", message)
+	}
+}
+
+// write writes event as JSON line, or format as plain line when it is not empty
+func (v *textView) write(event interface{}, format string, args ...interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.json {
+		writeJSONLine(v.out, event)
+		return
+	}
+	if "" != format {
+		fmt.Fprintf(v.out, format+"\n", args...)
+	}
+}
+
+// Start writes the URLs of the tunnel and waits for the client to leave or the view to close
+func (v *textView) Start() error {
+	ready := &readyEvent{
+		eventHeader: eventHeader{eventTunnelReady, time.Now()},
+		Schema:      eventSchema,
+		URL:         "https://" + v.tunnel,
+		HTTPURL:     "http://" + v.tunnel,
+	}
+	if v.expiresAt.IsZero() {
+		v.write(ready, "HTTP:  http://%s\nHTTPS: https://%s", v.tunnel, v.tunnel)
+	} else {
+		ready.ExpiresAt = &v.expiresAt
+		v.write(ready, "HTTP:  http://%s\nHTTPS: https://%s\nExpires at %s", v.tunnel, v.tunnel,
+			v.expiresAt.Format(time.RFC3339))
+	}
+	select {
+	case <-v.ctx.Done():
+	case <-v.done:
+	}
+	return nil
+}
+
+func (v *textView) Request(e *stat.RequestEntity) {
+	v.write(&requestEvent{
+		eventHeader: eventHeader{eventRequest, e.StartTime},
+		Method:      e.Request.Method,
+		Host:        e.Request.Host,
+		URI:         e.Request.RequestURI,
+		Status:      e.Response.StatusCode,
+		TTFB:        e.TTFB,
+		Duration:    e.UseTime,
+	}, "%s", requestLine(e))
+}
+
+// requestLine formats a forwarded request as a line of plain output
+func requestLine(e *stat.RequestEntity) string {
+	return fmt.Sprintf("%s %s %s %d %dms", e.StartTime.Format(time.RFC3339), e.Request.Method, e.Request.RequestURI,
+		e.Response.StatusCode, e.UseTime)
+}
+
+// ConnOpened and ConnClosed are only part of the JSON stream, plain output has a line per request
+func (v *textView) ConnOpened(id int64, remoteAddr string) {
+	v.write(&connEvent{eventHeader: eventHeader{eventConnOpen, time.Now()}, Conn: id, RemoteAddr: remoteAddr}, "")
+}
+
+func (v *textView) ConnClosed(id int64, bytesIn, bytesOut int64, duration time.Duration) {
+	ms := duration.Milliseconds()
+	v.write(&connEvent{
+		eventHeader: eventHeader{eventConnClose, time.Now()},
+		Conn:        id,
+		BytesIn:     &bytesIn,
+		BytesOut:    &bytesOut,
+		Duration:    &ms,
+	}, "")
+}
+
+func (v *textView) Warn(message string) {
+	v.write(newMessageEvent(eventWarning, message), "%s", message)
+}
+
+func (v *textView) Shutdown(deadline time.Time) {
+	v.Warn(fmt.Sprintf("Server is shutting down, this tunnel closes in %s", time.Until(deadline).Round(time.Second)))
+}
+
+func (v *textView) Goodbye(reason string) {
+	v.write(newMessageEvent(eventTunnelClosed, reason), "%s", reason)
+}
+
+func (v *textView) Close() error {
+	v.closed.Do(func() { close(v.done) })
+	if nil == v.session {
+		return nil
+	}
+	return v.session.Exit(0)
+}
@@ -0,0 +1,245 @@
+package echogy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/echogy-io/echogy/pkg/stat"
+	gossh "golang.org/x/crypto/ssh"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextView(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	entity := &stat.RequestEntity{
+		Request:   &http.Request{Method: "GET", Host: "demo01.webs.sh", RequestURI: "/health"},
+		Response:  &http.Response{StatusCode: 200},
+		StartTime: start,
+		UseTime:   12,
+		TTFB:      3,
+	}
+	tests := []struct {
+		name      string
+		json      bool
+		expiresAt time.Time
+		want      []string
+	}{
+		{"plain", false, time.Time{}, []string{
+			"HTTP:  http://demo01.webs.sh",
+			"HTTPS: https://demo01.webs.sh",
+			"2024-01-02T15:04:05Z GET /health 200 12ms",
+			"careful",
+			"bye",
+		}},
+		{"plain expiring", false, start, []string{
+			"HTTP:  http://demo01.webs.sh",
+			"HTTPS: https://demo01.webs.sh",
+			"Expires at 2024-01-02T15:04:05Z",
+			"2024-01-02T15:04:05Z GET /health 200 12ms",
+			"careful",
+			"bye",
+		}},
+		{"json", true, start, []string{
+			`{"event":"tunnel_ready","time":`,
+			`"schema":1,"url":"https://demo01.webs.sh","httpUrl":"http://demo01.webs.sh","expiresAt":"2024-01-02T15:04:05Z"}`,
+			`{"event":"conn_open","time":`,
+			`"conn":1,"remoteAddr":"192.0.2.1:51234"}`,
+			`{"event":"request","time":"2024-01-02T15:04:05Z","method":"GET","host":"demo01.webs.sh","uri":"/health","status":200,"ttfbMs":3,"durationMs":12}`,
+			`{"event":"conn_close","time":`,
+			`"conn":1,"bytesIn":120,"bytesOut":0,"durationMs":1500}`,
+			`{"event":"warning","time":`,
+			`"message":"careful"}`,
+			`{"event":"tunnel_closed","time":`,
+			`"message":"bye"}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			out := &bytes.Buffer{}
+			view := &textView{ctx: ctx, out: out, json: tt.json, tunnel: "demo01.webs.sh",
+				expiresAt: tt.expiresAt, done: make(chan struct{})}
+			started := make(chan struct{})
+			go func() {
+				view.Start()
+				close(started)
+			}()
+			// Start writes the URLs before it blocks
+			for deadline := time.Now().Add(time.Second); ; {
+				view.mu.Lock()
+				ready := out.Len() > 0
+				view.mu.Unlock()
+				if ready || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			view.ConnOpened(1, "192.0.2.1:51234")
+			view.Request(entity)
+			view.ConnClosed(1, 120, 0, 1500*time.Millisecond)
+			view.Warn("careful")
+			view.Goodbye("bye")
+			view.Close()
+			view.Close()
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+				t.Fatal("Start did not return after Close")
+			}
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if !tt.json && len(lines) != len(tt.want) {
+				t.Errorf("output = %q, want %d lines", out.String(), len(tt.want))
+			}
+			want := tt.want
+			for _, line := range lines {
+				if tt.json && !json.Valid([]byte(line)) {
+					t.Errorf("line %q is not JSON", line)
+				}
+				// JSON lines are matched by their start and end around the current time
+				for len(want) > 0 && strings.Contains(line, want[0]) {
+					want = want[1:]
+				}
+			}
+			if len(want) > 0 {
+				t.Errorf("output = %s, missing %q", out.String(), want[0])
+			}
+		})
+	}
+}
+
+func TestSessionWithoutPty(t *testing.T) {
+	addr := startTestServer(t, nil)
+	client, err := dialTestServer(addr, newTestSigner(t, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !forward(client) {
+		t.Fatal("forward refused")
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "HTTP:  http://") || !strings.HasSuffix(line, ".webs.sh\n") {
+		t.Errorf("first line = %q, want the tunnel URL", line)
+	}
+}
+
+func TestHeadlessTunnel(t *testing.T) {
+	addr := startTestServer(t, nil)
+	client, err := dialTestServer(addr, newTestSigner(t, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !forward(client) {
+		t.Fatal("forward refused")
+	}
+
+	// the tunnel starts with the forward request
+	var accessId string
+	for deadline := time.Now().Add(time.Second); "" == accessId && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		rangeForwarders(func(fwd *forwarder) {
+			if hex.EncodeToString(client.SessionID()) == fwd.sshCtx.SessionID() {
+				accessId = fwd.accessId
+			}
+		})
+	}
+	if "" == accessId {
+		t.Fatal("no tunnel without a session")
+	}
+
+	// a session opened later shows the running tunnel
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "HTTP:  http://" + accessId + ".webs.sh\n"; line != want {
+		t.Errorf("first line = %q, want %q", line, want)
+	}
+
+	second, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := &bytes.Buffer{}
+	second.Stderr = stderr
+	second.Run("")
+	if !strings.Contains(stderr.String(), "already running") {
+		t.Errorf("second session wrote %q", stderr.String())
+	}
+
+	client.Close()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, found := sessionHub.Load(accessId); !found {
+			return
+		}
+	}
+	t.Error("tunnel kept after the client left")
+}
+
+func TestHeadlessTunnelNotices(t *testing.T) {
+	addr := startTestServer(t, nil)
+	client, err := dialTestServer(addr, newTestSigner(t, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// the subdomain is refused before any session is there to hear it
+	if ok, _, err := client.SendRequest(sshSessionTypeForward, true, gossh.Marshal(&remoteForwardRequest{BindAddr: "ab", BindPort: 80})); nil != err || !ok {
+		t.Fatal("forward refused")
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stderr).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "Subdomain ab must be") {
+		t.Errorf("first notice = %q, want the refused subdomain", line)
+	}
+}